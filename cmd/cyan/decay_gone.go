@@ -0,0 +1,44 @@
+//go:build gone
+// +build gone
+
+package main
+
+import (
+	"github.com/rwcarlsen/cyan/gone"
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+func init() {
+	decayData = goneDecay{}
+	doseData = goneDecay{}
+}
+
+// svPerBqPerMremPerPCi converts pyne's dose factors in mrem/pCi to Sv/Bq.
+const svPerBqPerMremPerPCi = 1e-5 / 0.037
+
+// goneDecay adapts the pyne nuclear data of the gone package to
+// nuc.DecayData and nuc.DoseData.  Dose coefficients are the EPA factors.
+type goneDecay struct{}
+
+func (goneDecay) DecayConst(n nuc.Nuc) float64 { return gone.DecayConst(gone.Nuc(n)) }
+
+func (goneDecay) DecayEnergy(n nuc.Nuc) float64 { return gone.DecayEnergy(gone.Nuc(n)) * nuc.MeV }
+
+func (goneDecay) Branches(n nuc.Nuc) map[nuc.Nuc]float64 {
+	branches := map[nuc.Nuc]float64{}
+	for _, kid := range gone.DecayChildren(gone.Nuc(n)) {
+		branches[nuc.Nuc(kid)] = gone.BranchRatio(gone.Nuc(n), kid)
+	}
+	return branches
+}
+
+func (goneDecay) DoseCoeff(n nuc.Nuc, p nuc.Pathway) float64 {
+	v := gone.IngestDose(gone.Nuc(n), gone.EPA)
+	if p == nuc.Inhalation {
+		v = gone.InhaleDose(gone.Nuc(n), gone.EPA)
+	}
+	if v < 0 {
+		return 0
+	}
+	return v * svPerBqPerMremPerPCi
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/rwcarlsen/cyan/nuc"
+	"github.com/rwcarlsen/cyan/query"
+)
+
+// filterFlags holds the query filter flags shared by subcommands.  Every
+// subcommand registers the subset of them its queries support so the same
+// filter always has the same flag name and meaning.
+type filterFlags struct {
+	agents  *string
+	protos  *string
+	specs   *string
+	commods *string
+	nucs    *string
+	elems   *string
+	t0      *string
+	t1      *string
+}
+
+// addFilterFlags registers the named filter flags on fs.  Valid names are
+// "agents", "proto", "spec", "commod", "nucs", "elems" and "time" (which
+// registers both -t1 and -t2).
+func addFilterFlags(fs *flag.FlagSet, names ...string) *filterFlags {
+	ff := &filterFlags{}
+	for _, name := range names {
+		switch name {
+		case "agents":
+			ff.agents = fs.String("agents", "", "filter by comma separated agent IDs")
+		case "proto":
+			ff.protos = fs.String("proto", "", "filter by comma separated prototypes (default is all prototypes)")
+		case "spec":
+			ff.specs = fs.String("spec", "", "filter by comma separated archetype specs or names (e.g. ':cycamore:Reactor' or 'Reactor')")
+		case "commod":
+			ff.commods = fs.String("commod", "", "filter by comma separated commodities")
+		case "nucs":
+			ff.nucs = fs.String("nucs", "", "filter by comma separated `nuclide`s and nuclide groups (e.g. 'TRU,Cs137'): "+strings.Join(nuc.GroupNames(), ", "))
+		case "elems":
+			ff.elems = fs.String("elems", "", "filter by comma separated `element`s (e.g. 'U,Pu')")
+		case "time":
+			ff.t0 = fs.String("t1", "", "beginning of time interval in -tunit units (default is beginning of simulation)")
+			ff.t1 = fs.String("t2", "", "end of time interval in -tunit units (default is end of simulation)")
+		default:
+			panic("unknown filter flag " + name)
+		}
+	}
+	return ff
+}
+
+// Filter returns the query filter specified by the parsed flags.  Times
+// given in calendar units are converted to time steps using the database, so
+// it must be called after initdb.
+func (ff *filterFlags) Filter() query.Filter {
+	f := query.Filter{}
+	for _, s := range list(ff.agents) {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			fatalf("invalid agent ID '%v'", s)
+		}
+		f.Agents = append(f.Agents, id)
+	}
+	f.Prototypes = list(ff.protos)
+	f.Specs = list(ff.specs)
+	f.Commods = list(ff.commods)
+	for _, s := range list(ff.nucs) {
+		if g, ok := nuc.LookupGroup(s); ok {
+			f.Nucs = append(f.Nucs, g.Nucs...)
+			f.Elements = append(f.Elements, g.Elements...)
+			continue
+		}
+		n, err := nuc.Id(s)
+		fatalif(err)
+		f.Nucs = append(f.Nucs, n)
+	}
+	for _, s := range list(ff.elems) {
+		n, err := nuc.Id(s)
+		if err != nil || n.A() != 0 {
+			fatalf("'%v' is not a valid element", s)
+		}
+		f.Elements = append(f.Elements, n.Z())
+	}
+	if ff.t0 != nil {
+		f.T0 = timeStep(*ff.t0, 0)
+		f.T1 = timeStep(*ff.t1, -1)
+	}
+	return f
+}
+
+// protoArg treats the positional argument of fs, if any, as the deprecated
+// alias of the -proto flag of subcommands that used to take a prototype
+// argument.
+func (ff *filterFlags) protoArg(fs *flag.FlagSet) {
+	if fs.NArg() == 0 {
+		return
+	} else if fs.NArg() > 1 {
+		fatalf("too many arguments: %v", strings.Join(fs.Args(), " "))
+	} else if *ff.protos != "" {
+		fatal("prototypes must be given with either -proto or an argument, not both")
+	}
+	log.Printf("the prototype argument is deprecated - use -proto %v", fs.Arg(0))
+	*ff.protos = fs.Arg(0)
+}
+
+// timeStep converts the time v of a -t1 or -t2 flag in -tunit units to a
+// time step or returns def if v is empty.
+func timeStep(v string, def int) int {
+	if v == "" {
+		return def
+	} else if db == nil && timeUnit != query.Step {
+		// -query doesn't open the database or use the converted value
+		return 1
+	}
+	t, err := query.TimeStep(db, simid, timeUnit, v)
+	fatalif(err)
+	if t == 0 && def < 0 && timeUnit != query.Step {
+		fatalf("end of time interval %v is before the start of the simulation", v)
+	}
+	return t
+}
+
+// list splits the comma separated value of a flag (which may not be
+// registered).
+func list(s *string) []string {
+	if s == nil || *s == "" {
+		return nil
+	}
+	var vals []string
+	for _, v := range strings.Split(*s, ",") {
+		vals = append(vals, strings.TrimSpace(v))
+	}
+	return vals
+}
+
+// sqlFilter renders f on the columns in c, exiting on unsupported filters.
+func sqlFilter(f query.Filter, c query.Cols) (string, []interface{}) {
+	s, args, err := f.SQL(c)
+	fatalif(err)
+	return s, args
+}
+
+// transFilter renders f along with the comma separated sender (from) and
+// receiver (to) prototypes, or agent IDs if byagent is true, of a
+// transactions (t) query joined with its sending (send) and receiving (recv)
+// agents and the compositions (c) of its resources unless products is true.
+func transFilter(f query.Filter, from, to string, byagent, products bool) (string, []interface{}) {
+	cols := query.Cols{Commod: "t.Commodity", Nuc: "c.NucId", Time: "t.Time"}
+	if products {
+		cols.Nuc = ""
+	}
+	filter, args := sqlFilter(f, cols)
+	sfilt, sargs := sqlFilter(agentFilter("-from", from, byagent), query.Cols{Agent: "t.SenderId", Proto: "send.Prototype"})
+	rfilt, rargs := sqlFilter(agentFilter("-to", to, byagent), query.Cols{Agent: "t.ReceiverId", Proto: "recv.Prototype"})
+	return filter + sfilt + rfilt, append(append(args, sargs...), rargs...)
+}
+
+// agentFilter returns a filter for the comma separated prototypes, or agent
+// IDs if byagent is true, given to the named flag.
+func agentFilter(flagname, v string, byagent bool) query.Filter {
+	f := query.Filter{}
+	if !byagent {
+		f.Prototypes = list(&v)
+		return f
+	}
+	for _, s := range list(&v) {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			fatalf("invalid agent ID (%v=%v)", flagname, s)
+		}
+		f.Agents = append(f.Agents, id)
+	}
+	return f
+}
+
+// label returns the nuclide and element filters for use in plot titles
+// (e.g. " of U,Pu239") or an empty string if there are none.
+func (ff *filterFlags) label() string {
+	names := append(list(ff.nucs), list(ff.elems)...)
+	if len(names) == 0 {
+		return ""
+	}
+	return " of " + strings.Join(names, ",")
+}
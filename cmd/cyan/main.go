@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -11,34 +13,82 @@ import (
 	"log"
 	"math"
 	"os"
-	"os/exec"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
 	"text/template"
+	"time"
 
 	"code.google.com/p/go-uuid/uuid"
 	"github.com/rwcarlsen/cyan/nuc"
+	"github.com/rwcarlsen/cyan/plot"
 	"github.com/rwcarlsen/cyan/post"
 	"github.com/rwcarlsen/cyan/query"
+	"github.com/rwcarlsen/cyan/query/diff"
+	"github.com/rwcarlsen/cyan/query/validate"
+	"github.com/rwcarlsen/cyan/source"
 	"github.com/rwcarlsen/cyan/taint"
-	_ "github.com/rwcarlsen/go-sqlite3"
+	"github.com/rwcarlsen/go-sqlite3"
 )
 
 var (
 	custom    = flag.String("custom", "", "path to custom sql query spec file")
 	showquery = flag.Bool("query", false, "show query SQL for a subcommand instead of executing it")
-	dbname    = flag.String("db", "", "cyclus sqlite database to query")
-	simidstr  = flag.String("simid", "", "simulation id in hex (empty string defaults to first sim id in database")
+	dbname    = flag.String("db", "", "cyclus sqlite or HDF5 database to query")
+	postdb    = flag.String("postdb", "", "write post processing tables to this separate database leaving the -db database unmodified (an existing <db>.post.sqlite is used automatically)")
+	simidstr  = flag.String("simid", "", "simulation id in hex (empty string defaults to first sim id in database); time series subcommands accept a comma separated list of ids or 'all' to compare simulations")
+	alias     = flag.String("alias", "", "comma separated labels for the compared -simid simulations (default is a prefix of each simid)")
 	noheader  = flag.Bool("noheader", false, "don't print header line with output data")
+	format    = flag.String("format", tableFormat, "output format: table, csv, tsv, json (an array of row objects) or jsonl (a row object per line)")
+	progress  = flag.Bool("progress", false, "show a progress bar on stderr while post processing")
+	watch     = flag.Duration("watch", 0, "re-run the subcommand at this interval on a database cyclus is still writing, post processing new data incrementally")
+	watchidle = flag.Int("watchidle", 3, "with -watch, treat simulations as finished once their data has not changed for this many intervals (0 waits for cyclus to record that they finished)")
+	tunit     = flag.String("tunit", "step", "time unit for output and -t1/-t2 flags: step, month (elapsed), year (decimal calendar year) or date (YYYY-MM-DD)")
 )
 
+// timeUnit is the parsed -tunit flag.
+var timeUnit = query.Step
+
 var simid []byte
 
+// simids holds every simulation selected with -simid.  simid is the first.
+var simids [][]byte
+
+// compareCmds are the subcommands that can compare several simulations.
+var compareCmds = map[string]bool{
+	"ts":       true,
+	"power":    true,
+	"deployed": true,
+	"built":    true,
+	"decom":    true,
+	"inv":      true,
+	"flow":     true,
+}
+
+// postJobs is the number of simulations post processed concurrently.
+var postJobs = 1
+
+// postForce forces re-processing of already post processed simulations.
+var postForce bool
+
+// watchDone is set once every simulation in a watched database has finished
+// and been fully post processed.
+var watchDone bool
+
+// watchProcs holds the post processor of each database across -watch
+// intervals so that it can tell when a database stops changing.
+var watchProcs = map[*sql.DB]*post.Processor{}
+
 var command string
 
 var db *sql.DB
 
+// sidecar is true if post processing tables live in a separate database from
+// the raw cyclus database (see the -postdb flag).
+var sidecar bool
+
 var cmds = NewCmdSet()
 
 // map[cmdname]sqltext
@@ -49,7 +99,10 @@ func init() {
 	cmds.Register("sims", "list all simulations in the database", doSims)
 	cmds.Register("infile", "show the simulation's input file", doInfile)
 	cmds.Register("version", "show simulation's cyclus version info", doVersion)
+	cmds.Register("convert", "convert an HDF5 database to sqlite", doConvert)
 	cmds.Register("post", "post process the database", doPost)
+	cmds.Register("check", "check for mass conservation and data integrity violations", doCheck)
+	cmds.Register("diff", "compare the simulation with one in another database", doDiff)
 	cmds.Register("table", "show the contents of a specific table", doTable)
 	cmds.Register("ts", "investigate time-series data tables", doTimeSeries)
 	cmds.RegisterDiv("Agents")
@@ -67,8 +120,14 @@ func init() {
 	cmds.RegisterDiv("Other")
 	cmds.Register("inv", "time series of inventory by prototype", doInv)
 	cmds.Register("power", "time series of power produced", doPower)
-	cmds.Register("energy", "thermal energy (J) generated between 2 timesteps", doEnergy)
+	cmds.Register("energy", "thermal energy (J, MWh and GWd) generated by prototype between 2 timesteps", doEnergy)
 	cmds.Register("created", "material created by agents between 2 timesteps", doCreated)
+	cmds.Register("activity", "time series of inventory activity (Bq) by prototype", doDecay)
+	cmds.Register("decayheat", "time series of inventory decay heat (W) by prototype", doDecay)
+	cmds.Register("radiotox", "radiotoxicity (Sv) of inventories or commodity flows decayed over time", doRadiotox)
+	cmds.Register("swu", "time series of separative work (kg-SWU) of enrichment", doEnrich)
+	cmds.Register("enrich", "time series of enrichment product, feed, tails and SWU", doEnrich)
+	cmds.Register("burnup", "discharge burnup (MWd/kgHM) of reactor fuel by batch, prototype or time", doBurnup)
 	cmds.Register("taint", "taint analysis...", doTaint)
 }
 
@@ -91,6 +150,9 @@ func main() {
 		tw.Flush()
 	}
 	flag.Parse()
+	if flag.Arg(0) == "diff" {
+		fatalStatus = 2
+	}
 
 	if flag.NArg() < 1 {
 		fmt.Println("Usage: cyan -db <cyclus-db> [flags...] <command> [flags...] [args...]")
@@ -103,20 +165,48 @@ func main() {
 		return
 	}
 
+	var err error
+	timeUnit, err = query.ParseTimeUnit(*tunit)
+	fatalif(err)
+	outFormat = parseFormat(*format)
+
 	if *custom != "" {
 		data, err := ioutil.ReadFile(*custom)
 		fatalif(err)
 		fatalif(json.Unmarshal(data, &customSql))
 	}
 
+	if *simidstr == "all" || strings.Contains(*simidstr, ",") {
+		if cmd := flag.Arg(0); !compareCmds[cmd] {
+			fatalf("%v cannot compare multiple simulations", cmd)
+		}
+	}
+
 	// run command
+	if *watch > 0 {
+		watchCmd(flag.Args())
+		return
+	}
 	cmds.Execute(flag.Args())
 }
 
+// watchCmd runs the subcommand given by args every -watch interval until
+// all simulations in the database have finished.
+func watchCmd(args []string) {
+	for {
+		cmds.Execute(args)
+		if db == nil || watchDone {
+			return
+		}
+		time.Sleep(*watch)
+		fmt.Println()
+	}
+}
+
 func doCustom(w io.Writer, cmd string, args ...interface{}) {
 	s, ok := customSql[cmd]
 	if !ok {
-		log.Fatalf("Invalid command/query %v", cmd)
+		fatalf("Invalid command/query %v", cmd)
 	} else if *showquery {
 		fmt.Fprint(w, s)
 		return
@@ -124,57 +214,113 @@ func doCustom(w io.Writer, cmd string, args ...interface{}) {
 
 	rows, err := db.Query(s, args...)
 	fatalif(err)
-
-	tw := tabwriter.NewWriter(w, 4, 4, 1, ' ', 0)
+	defer rows.Close()
 	cols, err := rows.Columns()
 	fatalif(err)
 
-	simidcol := -1
-	if !*noheader {
-		// write header line
-		for i, c := range cols {
-			if strings.Contains(strings.ToLower(c), "simid") {
-				simidcol = i
-			}
-			_, err := tw.Write([]byte(c + "\t"))
-			fatalif(err)
-		}
-		_, err = tw.Write([]byte("\n"))
-		fatalif(err)
-	}
+	rw := newResultWriter(w)
+	fatalif(rw.Header(cols...))
 
-	vs := make([]interface{}, len(cols))
-	vals := make([]*sql.NullString, len(cols))
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
 	for i := range vals {
-		vals[i] = &sql.NullString{}
-		vs[i] = vals[i]
+		ptrs[i] = &vals[i]
 	}
-
 	for rows.Next() {
-		for i := range vals {
-			vals[i].Valid = false
+		fatalif(rows.Scan(ptrs...))
+		for i, c := range cols {
+			vals[i] = rowValue(c, vals[i])
 		}
+		fatalif(rw.Row(vals...))
+	}
+	fatalif(rows.Err())
+	fatalif(rw.Flush())
+}
 
-		err := rows.Scan(vs...)
-		fatalif(err)
+// doSeries runs the time series query of cmd for every -simid simulation.
+// The output of a single simulation is the same as doCustom's.  Several
+// simulations are compared with a time column followed by one value column
+// for each simulation.  args returns the query arguments for a simulation.
+func doSeries(w io.Writer, cmd string, args func(simid []byte) []interface{}) {
+	if len(simids) < 2 || *showquery {
+		doCustom(w, cmd, args(simid)...)
+		return
+	}
+
+	c, err := query.CompareSeries(db, simids, customSql[cmd], args)
+	fatalif(err)
 
-		for i, v := range vals {
+	rw := newResultWriter(w)
+	fatalif(rw.Header(append([]string{"Time"}, simLabels()...)...))
+	for i, t := range c.Times {
+		row := []interface{}{timeValue(t)}
+		for _, v := range c.Values[i] {
 			if v.Valid {
-				s = v.String
-				if i == simidcol {
-					s = uuid.UUID(v.String).String()
-				}
-				tw.Write([]byte(s + "\t"))
+				row = append(row, v.Float64)
 			} else {
-				tw.Write([]byte("NULL\t"))
+				row = append(row, nil)
 			}
 		}
+		fatalif(rw.Row(row...))
+	}
+	fatalif(rw.Flush())
+}
 
-		_, err = tw.Write([]byte("\n"))
-		fatalif(err)
+// timeValue converts a time of a compared series back to a number unless it
+// is a date.
+func timeValue(t string) interface{} {
+	if v, err := strconv.ParseInt(t, 10, 64); err == nil {
+		return v
+	} else if v, err := strconv.ParseFloat(t, 64); err == nil {
+		return v
+	}
+	return t
+}
+
+// timeValues maps the time steps of the simulation to their times in -tunit
+// units for subcommands that compute time series outside of SQL.
+func timeValues() map[int]interface{} {
+	rows, err := db.Query("SELECT Time,"+timeUnit.Col("tl")+" FROM TimeList AS tl WHERE SimId = ?;", simid)
+	fatalif(err)
+	defer rows.Close()
+	times := map[int]interface{}{}
+	for rows.Next() {
+		var t int
+		var v interface{}
+		fatalif(rows.Scan(&t, &v))
+		times[t] = rowValue("", v)
 	}
 	fatalif(rows.Err())
-	fatalif(tw.Flush())
+	return times
+}
+
+// writeSeries writes a time series computed outside of SQL as a time column
+// and a value column named col.
+func writeSeries(w io.Writer, col string, xys []query.XY) {
+	times := timeValues()
+	rw := newResultWriter(w)
+	fatalif(rw.Header("Time", col))
+	for _, xy := range xys {
+		fatalif(rw.Row(times[xy.X], xy.Y))
+	}
+	fatalif(rw.Flush())
+}
+
+// simLabels returns the -alias labels of the -simid simulations or a prefix
+// of each simid if no aliases were given.
+func simLabels() []string {
+	if *alias != "" {
+		labels := strings.Split(*alias, ",")
+		if len(labels) != len(simids) {
+			fatalf("got %v -alias labels for %v simulations", len(labels), len(simids))
+		}
+		return labels
+	}
+	labels := make([]string, len(simids))
+	for i, id := range simids {
+		labels[i] = uuid.UUID(id).String()[:8]
+	}
+	return labels
 }
 
 func doSims(cmd string, args []string) {
@@ -227,15 +373,146 @@ WHERE i.simid=?
 
 func doPost(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	jobs := fs.Int("j", 1, "number of simulations to post process concurrently")
+	force := fs.Bool("force", false, "re-process simulations that already have up-to-date post processing")
 	fs.Usage = func() {
 		log.Printf("Usage: %v", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
 		fs.PrintDefaults()
 	}
 	fs.Parse(args)
+	postJobs = *jobs
+	postForce = *force
 	initdb()
 }
 
+func doConvert(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	out := fs.String("o", "", "output sqlite database (default <db>.sqlite)")
+	fs.Usage = func() {
+		log.Printf("Usage: %v [h5-file]", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("Converts the -db (or given) HDF5 database even if a cached conversion exists.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *showquery {
+		fatalf("-query is not supported by %v", cmd)
+	}
+
+	in := *dbname
+	if fs.NArg() > 0 {
+		in = fs.Arg(0)
+	}
+	if in == "" {
+		fatal("must specify an HDF5 database with the -db flag or as an argument")
+	} else if !source.IsHDF5(in) {
+		fatalf("%v is not an HDF5 database", in)
+	}
+	if *out == "" {
+		*out = source.CachePath(in)
+	}
+	fatalif(source.Convert(in, *out))
+}
+
+func doCheck(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	tol := fs.Float64("tol", validate.DefaultTol, "relative tolerance for comparing quantities")
+	checks := fs.String("checks", "", "comma separated checks to run (default all): "+strings.Join(validate.AllChecks, ","))
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("Prints a JSON report of violations (or a row per violation with -format")
+		log.Printf("csv, tsv or jsonl) and exits with status 1 if any are found.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *showquery {
+		fatalf("-query is not supported by %v", cmd)
+	}
+	initdb()
+
+	var names []string
+	if *checks != "" {
+		for _, name := range strings.Split(*checks, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	r, err := validate.Check(db, simid, *tol, names...)
+	fatalif(err)
+
+	if outFormat == tableFormat || outFormat == jsonFormat {
+		data, err := json.MarshalIndent(r, "", "  ")
+		fatalif(err)
+		fmt.Printf("%s\n", data)
+	} else {
+		rw := newResultWriter(os.Stdout)
+		fatalif(rw.Header("Check", "Time", "AgentId", "ResourceIds", "TransactionId", "Expected", "Actual", "Message"))
+		for _, v := range r.Violations {
+			ids := make([]string, len(v.ResourceIds))
+			for i, id := range v.ResourceIds {
+				ids[i] = strconv.Itoa(id)
+			}
+			fatalif(rw.Row(v.Check, v.Time, v.AgentId, strings.Join(ids, " "), v.TransactionId, v.Expected, v.Actual, v.Message))
+		}
+		fatalif(rw.Flush())
+	}
+	if !r.OK() {
+		os.Exit(1)
+	}
+}
+
+func doDiff(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.StringVar(dbname, "db", *dbname, "cyclus database to compare (same as the global -db flag)")
+	db2 := fs.String("db2", "", "cyclus database to compare against (default is -db)")
+	postdb2 := fs.String("postdb2", "", "write -db2 post processing tables to this separate database leaving -db2 unmodified (an existing <db2>.post.sqlite is used automatically)")
+	simid2 := fs.String("simid2", "", "simulation id in hex from -db2 (default is the first simulation in -db2)")
+	tol := fs.Float64("tol", diff.DefaultTol, "relative tolerance for comparing quantities")
+	metrics := fs.String("metrics", "", "comma separated metrics to compare (default all): "+strings.Join(diff.AllMetrics, ","))
+	fs.Usage = func() {
+		log.Printf("Usage: %v -db2 <cyclus-db>", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("Prints quantities that differ between the -db/-simid and -db2/-simid2")
+		log.Printf("simulations and exits with status 1 if any are found or 2 on errors.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *showquery {
+		fatalf("-query is not supported by %v", cmd)
+	} else if *db2 == "" && *simid2 == "" {
+		fatal("must specify a database with the -db2 flag or a simulation with -simid2")
+	} else if *db2 == "" && *postdb2 != "" {
+		fatal("-postdb2 requires -db2")
+	}
+	initdb()
+
+	dbB := db
+	if *db2 != "" {
+		var sidecarB bool
+		dbB, sidecarB = openPath(*db2, *postdb2)
+		defer dbB.Close()
+		if sidecar && !sidecarB {
+			fatalf("-db uses a post processing sidecar but -db2 doesn't - give -postdb2 to leave %v unmodified too", *db2)
+		}
+		postprocess(dbB)
+	}
+	simidB := parseSimIds(dbB, *simid2)[0]
+
+	r, err := diff.Compare(db, simid, dbB, simidB, *tol, list(metrics)...)
+	fatalif(err)
+
+	rw := newResultWriter(os.Stdout)
+	fatalif(rw.Header("Metric", "Key", "A", "B", "Abs", "Rel"))
+	for _, d := range r.Differences {
+		fatalif(rw.Row(d.Metric, d.Key, d.A, d.B, d.Abs, d.Rel))
+	}
+	fatalif(rw.Flush())
+	if !r.OK() {
+		os.Exit(1)
+	}
+}
+
 func doInfile(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 	fs.Usage = func() {
@@ -248,18 +525,21 @@ func doInfile(cmd string, args []string) {
 	s := `
 SELECT data FROM inputfiles WHERE simid=?;
 `
-	var buf bytes.Buffer
 	customSql[cmd] = s
-	doCustom(&buf, cmd, simid)
-	data := buf.String()
-	data = strings.Replace(data, "Data", "", 1)
-	data = strings.TrimLeft(data, "\r\n\t ")
-	fmt.Print(data)
+	if outFormat != tableFormat || *showquery {
+		doCustom(os.Stdout, cmd, simid)
+		return
+	}
+
+	// print the raw input file rather than an aligned table of it
+	var data string
+	fatalif(db.QueryRow(s, simid).Scan(&data))
+	fmt.Println(strings.TrimRight(data, "\n"))
 }
 
 func doAgents(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	proto := fs.String("proto", "", "filter by prototype (default is all prototypes)")
+	ff := addFilterFlags(fs, "agents", "proto", "spec")
 	fs.Usage = func() {
 		log.Printf("Usage: %v", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
@@ -268,23 +548,19 @@ func doAgents(cmd string, args []string) {
 	fs.Parse(args)
 	initdb()
 
-	iargs := []interface{}{simid}
+	filter, fargs := sqlFilter(ff.Filter(), query.Cols{Agent: "AgentId", Proto: "Prototype", Spec: "Spec"})
+	iargs := append([]interface{}{simid}, fargs...)
 	s := `
 SELECT AgentId,Kind,Prototype,ParentId,EnterTime,ExitTime,Lifetime
 FROM Agents
-WHERE SimId = ?
-`
-	if *proto != "" {
-		s += `AND Prototype = ?`
-		iargs = append(iargs, *proto)
-	}
-	customSql[cmd] = s
+WHERE SimId = ?`
+	customSql[cmd] = s + filter + "\n"
 	doCustom(os.Stdout, cmd, iargs...)
 }
 
 func doAges(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	proto := fs.String("proto", "", "filter by prototype (default is all prototypes)")
+	ff := addFilterFlags(fs, "agents", "proto", "spec")
 	fs.Usage = func() {
 		log.Printf("Usage: %v [time-step]", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
@@ -295,22 +571,18 @@ func doAges(cmd string, args []string) {
 
 	t, err := strconv.Atoi(fs.Arg(0))
 	if err != nil {
-		log.Fatalf("invalid time step '%v')", fs.Arg(0))
+		fatalf("invalid time step '%v')", fs.Arg(0))
 	}
 
-	iargs := []interface{}{t, simid, t, t}
+	filter, fargs := sqlFilter(ff.Filter(), query.Cols{Agent: "a.AgentId", Proto: "a.Prototype", Spec: "a.Spec"})
+	iargs := append([]interface{}{t, simid, t, t}, fargs...)
 	s := `
 SELECT ? - a.entertime AS Age FROM Agents as a
 WHERE a.simid=?
 AND a.entertime <= ?
-AND (a.exittime >= ? OR a.exittime ISNULL)
-`
-	if *proto != "" {
-		s += `AND Prototype = ?`
-		iargs = append(iargs, *proto)
-	}
+AND (a.exittime >= ? OR a.exittime ISNULL)`
 
-	customSql[cmd] = s
+	customSql[cmd] = s + filter + "\n"
 	doCustom(os.Stdout, cmd, iargs...)
 }
 
@@ -330,16 +602,22 @@ func doTable(cmd string, args []string) {
 		customSql[cmd] = s
 		doCustom(os.Stdout, cmd, simid)
 	} else {
-		s := "SELECT name FROM sqlite_master WHERE type='table';"
-		customSql[cmd] = s
+		s := "SELECT name FROM sqlite_master WHERE type='table'"
+		if sidecar {
+			s += " UNION SELECT name FROM " + post.RawSchema + ".sqlite_master WHERE type='table'"
+		}
+		customSql[cmd] = s + ";"
 		doCustom(os.Stdout, cmd)
 	}
 }
 
+// seriesName matches the names of TimeSeries tables.
+var seriesName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
 func doTimeSeries(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	proto := fs.String("proto", "", "filter by prototype (default is all prototypes)")
-	plotit := fs.Bool("p", false, "plot the data")
+	ff := addFilterFlags(fs, "agents", "proto", "spec", "time")
+	pf := addPlotFlags(fs, plot.Line)
 	fs.Usage = func() {
 		log.Printf("Usage: %v [table-name]", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
@@ -347,23 +625,24 @@ func doTimeSeries(cmd string, args []string) {
 		fs.PrintDefaults()
 	}
 	fs.Parse(args)
+	pf.parse()
 	initdb()
 
 	if fs.NArg() == 0 {
-		s := "SELECT name as foobarbaz FROM sqlite_master WHERE type='table' AND instr(name,'TimeSeries');"
-		customSql[cmd] = s
-		var buf bytes.Buffer
-		doCustom(&buf, cmd)
-		data := buf.String()
-		data = strings.Replace(data, "foobarbaz", "", -1)
-		data = strings.Replace(data, "TimeSeries", "", -1)
-		data = strings.TrimLeft(data, "\r\n\t ")
-		fmt.Print(string(data))
+		s := "SELECT replace(name,'TimeSeries','') AS Series FROM sqlite_master WHERE type='table' AND instr(name,'TimeSeries')"
+		if sidecar {
+			s += " UNION SELECT replace(name,'TimeSeries','') FROM " + post.RawSchema + ".sqlite_master WHERE type='table' AND instr(name,'TimeSeries')"
+		}
+		customSql[cmd] = s + ";"
+		doCustom(os.Stdout, cmd)
 	} else {
 		tsname := fs.Arg(0)
-		filter := ""
+		// the name is spliced into the query
+		if !seriesName.MatchString(tsname) {
+			fatalf("invalid time series name '%v'", tsname)
+		}
 		s := `
-SELECT tl.Time AS Time,IFNULL(sub.Val,0) AS {{.Name}}
+SELECT {{time "tl"}} AS Time,IFNULL(sub.Val,0) AS {{.Name}}
 FROM timelist as tl LEFT JOIN (
 	SELECT p.simid AS simid,p.Time AS Time,TOTAL(p.Value) AS Val
 	FROM timeseries{{.Name}} AS p
@@ -371,40 +650,46 @@ FROM timelist as tl LEFT JOIN (
 	WHERE p.simid=? {{.Filter}}
 	GROUP BY p.Time
 ) AS sub ON tl.time=sub.time AND tl.simid=sub.simid
+WHERE tl.simid=?
 `
 
-		tmpl := template.Must(template.New("sql").Parse(s))
+		filter, fargs := sqlFilter(ff.Filter(), powerCols)
+		tmpl := sqlTmpl(s)
 		var buf bytes.Buffer
-		if *proto != "" {
-			filter = " AND a.prototype='" + *proto + "' "
-		}
 		tmpl.Execute(&buf, struct{ Name, Filter string }{tsname, filter})
 		customSql[cmd] = buf.String()
 
 		var buff bytes.Buffer
-		doCustom(&buff, cmd, simid)
-		if *plotit {
-			plot(&buff, "linespoints", "Time (Months)", "Power (MWe)", "Total Power Produced")
+		doSeries(&buff, cmd, func(id []byte) []interface{} {
+			return append(append([]interface{}{id}, fargs...), id)
+		})
+		if pf.on() {
+			pf.draw(&buff, timeUnit.Label(), tsname, tsname)
 		} else {
 			fmt.Print(buff.String())
 		}
 	}
 }
 
+// powerCols are the filter columns of time series queries over agents (a)
+// and a time series table (p).
+var powerCols = query.Cols{Agent: "p.AgentId", Proto: "a.Prototype", Spec: "a.Spec", Time: "p.Time"}
+
 func doPower(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	proto := fs.String("proto", "", "filter by prototype (default is all prototypes)")
-	plotit := fs.Bool("p", false, "plot the data")
+	ff := addFilterFlags(fs, "agents", "proto", "spec", "time")
+	pf := addPlotFlags(fs, plot.Line)
 	fs.Usage = func() {
 		log.Printf("Usage: %v", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
 		fs.PrintDefaults()
 	}
 	fs.Parse(args)
+	pf.parse()
 	initdb()
 
 	s := `
-SELECT tl.Time AS Time,IFNULL(sub.Power,0) AS Power
+SELECT {{time "tl"}} AS Time,IFNULL(sub.Power,0) AS Power
 FROM timelist as tl LEFT JOIN (
 	SELECT p.simid AS simid,p.Time AS Time,TOTAL(p.Value) AS Power
 	FROM timeseriespower AS p
@@ -412,134 +697,106 @@ FROM timelist as tl LEFT JOIN (
 	WHERE p.simid=? {{.}}
 	GROUP BY p.Time
 ) AS sub ON tl.time=sub.time AND tl.simid=sub.simid
+WHERE tl.simid=?
 `
 
-	tmpl := template.Must(template.New("sql").Parse(s))
+	filter, fargs := sqlFilter(ff.Filter(), powerCols)
+	tmpl := sqlTmpl(s)
 	var buf bytes.Buffer
-	if *proto == "" {
-		tmpl.Execute(&buf, "")
-	} else {
-		tmpl.Execute(&buf, " AND a.prototype='"+*proto+"' ")
-	}
+	tmpl.Execute(&buf, filter)
 	customSql[cmd] = buf.String()
 
 	var buff bytes.Buffer
-	doCustom(&buff, cmd, simid)
-	if *plotit {
-		plot(&buff, "linespoints", "Time (Months)", "Power (MWe)", "Total Power Produced")
+	doSeries(&buff, cmd, func(id []byte) []interface{} {
+		return append(append([]interface{}{id}, fargs...), id)
+	})
+	if pf.on() {
+		pf.draw(&buff, timeUnit.Label(), "Power (MWe)", "Total Power Produced")
 	} else {
 		fmt.Print(buff.String())
 	}
 }
 
 func doDeployed(cmd string, args []string) {
-	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	fs.Usage = func() {
-		log.Printf("Usage: %v <prototype>", cmd)
-		log.Printf("%v\n", cmds.Help(cmd))
-		fs.PrintDefaults()
-	}
-	plotit := fs.Bool("p", false, "plot the data")
-	fs.Parse(args)
-	if fs.NArg() < 1 {
-		log.Fatal("must specify a prototype")
-	}
-	initdb()
-
-	proto := fs.Arg(0)
 	s := `
-SELECT tl.Time AS Time,IFNULL(n, 0) AS N_Deployed
+SELECT {{time "tl"}} AS Time,IFNULL(n, 0) AS N_Deployed
 FROM timelist AS tl
 LEFT JOIN (
     SELECT tl.time AS time,COUNT(a.agentid) AS n
 	FROM timelist AS tl
     LEFT JOIN agents AS a ON a.entertime <= tl.time AND (a.exittime >= tl.time OR a.exittime ISNULL) AND (tl.time < a.entertime + a.lifetime) AND a.simid=tl.simid
-    WHERE a.simid=? AND a.prototype=?
+    WHERE a.simid=? {{.Agents}}
     GROUP BY tl.time
 ) AS sub ON sub.time=tl.time
-WHERE tl.simid=?
+WHERE tl.simid=? {{.Times}}
 `
-	customSql[cmd] = s
-	var buf bytes.Buffer
-	doCustom(&buf, cmd, simid, proto, simid)
-	if *plotit {
-		plot(&buf, "linespoints", "Time (Months)", "Number "+proto+" Deployed", "Deployed Facilities")
-	} else {
-		fmt.Print(buf.String())
-	}
+	doAgentCounts(cmd, args, s, plot.Line, "Facilities Deployed", "Deployed Facilities")
 }
 
 func doBuilt(cmd string, args []string) {
-	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	fs.Usage = func() {
-		log.Printf("Usage: %v <prototype>", cmd)
-		log.Printf("%v\n", cmds.Help(cmd))
-		fs.PrintDefaults()
-	}
-	plotit := fs.Bool("p", false, "plot the data")
-	fs.Parse(args)
-	if fs.NArg() < 1 {
-		log.Fatal("must specify a prototype")
-	}
-	initdb()
-
-	proto := fs.Arg(0)
 	s := `
-SELECT tl.time AS Time,ifnull(sub.n, 0) AS N_Built
+SELECT {{time "tl"}} AS Time,ifnull(sub.n, 0) AS N_Built
 FROM timelist AS tl
 LEFT JOIN (
 	SELECT a.simid,tl.time AS time,COUNT(a.agentid) AS n
 	FROM agents AS a
 	JOIN timelist AS tl ON tl.time=a.entertime AND tl.simid=a.simid
-	WHERE a.simid=? AND a.prototype=?
+	WHERE a.simid=? {{.Agents}}
 	GROUP BY time
 ) AS sub ON tl.time=sub.time AND tl.simid=sub.simid
-WHERE tl.simid=?
+WHERE tl.simid=? {{.Times}}
 `
-
-	customSql[cmd] = s
-	var buf bytes.Buffer
-	doCustom(&buf, cmd, simid, proto, simid)
-	if *plotit {
-		plot(&buf, "impulses", "Time (Months)", "Number "+proto+" Built", "New Facilities Built")
-	} else {
-		fmt.Print(buf.String())
-	}
+	doAgentCounts(cmd, args, s, plot.Impulse, "Facilities Built", "New Facilities Built")
 }
 
 func doDecom(cmd string, args []string) {
-	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	fs.Usage = func() {
-		log.Printf("Usage: %v <prototype>", cmd)
-		log.Printf("%v\n", cmds.Help(cmd))
-		fs.PrintDefaults()
-	}
-	plotit := fs.Bool("p", false, "plot the data")
-	fs.Parse(args)
-	if fs.NArg() < 1 {
-		log.Fatal("must specify a prototype")
-	}
-	initdb()
-
-	proto := fs.Arg(0)
 	s := `
-SELECT tl.time AS Time,ifnull(sub.n, 0) AS N_Built
+SELECT {{time "tl"}} AS Time,ifnull(sub.n, 0) AS N_Built
 FROM timelist AS tl
 LEFT JOIN (
 	SELECT a.simid,tl.time AS time,COUNT(a.agentid) AS n
 	FROM agents AS a
 	JOIN timelist AS tl ON tl.time=a.exittime AND tl.simid=a.simid
-	WHERE a.simid=? AND a.prototype=?
+	WHERE a.simid=? {{.Agents}}
 	GROUP BY time
 ) AS sub ON tl.time=sub.time AND tl.simid=sub.simid
-WHERE tl.simid=?
+WHERE tl.simid=? {{.Times}}
 `
+	doAgentCounts(cmd, args, s, plot.Impulse, "Facilities Decommissioned", "Facilities Decommissioned")
+}
+
+// doAgentCounts runs the deployed, built and decom subcommands that count
+// agents (a) by time step (tl) with the query template s.  The agent filters
+// are rendered as its Agents field and the time filters as its Times field.
+func doAgentCounts(cmd string, args []string, s string, style plot.Style, ylabel, title string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	ff := addFilterFlags(fs, "proto", "spec", "time")
+	pf := addPlotFlags(fs, style)
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Printf("A positional prototype argument is a deprecated alias of -proto.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	pf.parse()
+	ff.protoArg(fs)
+	initdb()
+
+	f := ff.Filter()
+	afilt, aargs := sqlFilter(f.Agent(), query.Cols{Proto: "a.Prototype", Spec: "a.Spec"})
+	tfilt, targs := sqlFilter(query.Filter{T0: f.T0, T1: f.T1}, query.Cols{Time: "tl.Time"})
+	customSql[cmd] = execTmpl(s, struct{ Agents, Times string }{afilt, tfilt})
 
-	customSql[cmd] = s
 	var buf bytes.Buffer
-	doCustom(&buf, cmd, simid, proto, simid)
-	if *plotit {
-		plot(&buf, "impulses", "Time (Months)", "Number "+proto+" Decommissioned", "Facilities Decommissioned")
+	doSeries(&buf, cmd, func(id []byte) []interface{} {
+		return append(append(append([]interface{}{id}, aargs...), id), targs...)
+	})
+	if pf.on() {
+		if len(f.Prototypes) > 0 {
+			title = strings.Join(f.Prototypes, ",") + " " + title
+		}
+		pf.draw(&buf, timeUnit.Label(), ylabel, title)
 	} else {
 		fmt.Print(buf.String())
 	}
@@ -589,123 +846,183 @@ func doTrans(cmd string, args []string) {
 		log.Printf("%v\n", cmds.Help(cmd))
 		fs.PrintDefaults()
 	}
-	from := fs.String("from", "", "filter by supplying prototype")
-	to := fs.String("to", "", "filter by receiving prototype")
+	from := fs.String("from", "", "filter by comma separated supplying prototypes")
+	to := fs.String("to", "", "filter by comma separated receiving prototypes")
 	byagent := fs.Bool("byagent", false, "switch to/from filters to be agent IDs")
-	nucs := fs.String("nucs", "", "filter by comma separated `nuclide`s")
-	commod := fs.String("commod", "", "filter by a commodity")
+	ff := addFilterFlags(fs, "commod", "nucs", "elems", "time")
+	products := fs.Bool("products", false, "show Product resource transactions by quality instead of material")
+	quality := fs.String("quality", "", "filter products by quality (requires -products)")
 	fs.Parse(args)
+	checkProductFlags(*products, *quality, ff)
 	initdb()
+	f := ff.Filter()
 
 	s := `
-SELECT t.time AS Time,t.SenderId AS SenderId,send.Prototype AS SenderProto,t.ReceiverId AS ReceiverId,recv.Prototype AS ReceiverProto,t.Commodity AS Commodity,SUM(r.Quantity*c.MassFrac) AS Quantity,r.ResourceId AS ResourceId
+SELECT {{time "tl"}} AS Time,t.SenderId AS SenderId,send.Prototype AS SenderProto,t.ReceiverId AS ReceiverId,recv.Prototype AS ReceiverProto,t.Commodity AS Commodity,SUM(r.Quantity*c.MassFrac) AS Quantity,r.ResourceId AS ResourceId
 FROM transactions AS t
+JOIN timelist AS tl ON tl.time=t.time AND tl.simid=t.simid
 JOIN resources AS r ON t.resourceid=r.resourceid AND r.simid=t.simid
 JOIN agents AS send ON t.senderid=send.agentid AND send.simid=t.simid
 JOIN agents AS recv ON t.receiverid=recv.agentid AND recv.simid=t.simid
 JOIN compositions AS c ON c.qualid=r.qualid AND c.simid=t.simid
-WHERE t.simid=? {{index . 0}} {{index . 1}} {{index . 2}} {{index . 3}}
+WHERE t.simid=? {{.}}
 GROUP BY t.transactionid
 `
-
-	filters := make([]string, 4)
-	iargs := []interface{}{simid}
-	if *from != "" {
-		if *byagent {
-			filters[0] = "AND t.senderid=?"
-			fromid, err := strconv.Atoi(*from)
-			if err != nil {
-				log.Fatalf("invalid agent ID (-from=%v)", *from)
-			}
-			iargs = append(iargs, fromid)
-		} else {
-			filters[0] = "AND send.prototype=?"
-			iargs = append(iargs, *from)
-		}
-	}
-	if *to != "" {
-		if *byagent {
-			filters[1] = "AND t.receiverid=?"
-			toid, err := strconv.Atoi(*to)
-			if err != nil {
-				log.Fatalf("invalid agent ID (-to=%v)", *to)
-			}
-			iargs = append(iargs, toid)
-		} else {
-			filters[1] = "AND recv.prototype=?"
-			iargs = append(iargs, *to)
-		}
+	if *products {
+		s = `
+SELECT {{time "tl"}} AS Time,t.SenderId AS SenderId,send.Prototype AS SenderProto,t.ReceiverId AS ReceiverId,recv.Prototype AS ReceiverProto,t.Commodity AS Commodity,pd.Quality AS Quality,r.Quantity AS Quantity,r.ResourceId AS ResourceId
+FROM transactions AS t
+JOIN timelist AS tl ON tl.time=t.time AND tl.simid=t.simid
+JOIN resources AS r ON t.resourceid=r.resourceid AND r.simid=t.simid
+JOIN agents AS send ON t.senderid=send.agentid AND send.simid=t.simid
+JOIN agents AS recv ON t.receiverid=recv.agentid AND recv.simid=t.simid
+JOIN products AS pd ON pd.qualid=r.qualid AND pd.simid=t.simid
+WHERE t.simid=? AND r.type='Product' {{.}}
+`
 	}
-	if *commod != "" {
-		filters[2] = "AND t.commodity=?"
-		iargs = append(iargs, *commod)
+
+	filter, fargs := transFilter(f, *from, *to, *byagent, *products)
+	iargs := append([]interface{}{simid}, fargs...)
+	if *quality != "" {
+		filter += " AND pd.quality=?"
+		iargs = append(iargs, *quality)
 	}
-	filters[3] = nuclidefilter(*nucs)
 
-	tmpl := template.Must(template.New("sql").Parse(s))
+	tmpl := sqlTmpl(s)
 	var buf bytes.Buffer
-	tmpl.Execute(&buf, filters)
+	tmpl.Execute(&buf, filter)
 	customSql[cmd] = buf.String()
 	doCustom(os.Stdout, cmd, iargs...)
 }
 
 func doInv(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	plotit := fs.Bool("p", false, "plot the data")
-	nucs := fs.String("nucs", "", "filter by comma separated `nuclide`s")
+	pf := addPlotFlags(fs, plot.Line)
+	ff := addFilterFlags(fs, "agents", "spec", "nucs", "elems")
+	products := fs.Bool("products", false, "show Product resource inventory by quality instead of material")
+	quality := fs.String("quality", "", "filter products by quality (requires -products)")
+	decay := fs.Bool("decay", false, "decay inventories for the time since their material was created - split off and transferred material keeps its age (requires the gone build tag)")
 	fs.Usage = func() {
-		log.Printf("Usage: %v <prototype>", cmd)
+		log.Printf("Usage: %v <prototype>[,<prototype>...]", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
 		fs.PrintDefaults()
 	}
 	fs.Parse(args)
+	pf.parse()
 	if fs.NArg() < 1 {
-		log.Fatal("must specify a prototype")
+		fatal("must specify a prototype")
 	}
+	proto := fs.Arg(0)
+	checkProductFlags(*products, *quality, ff)
+	if *products && pf.on() {
+		fatal("-p is not supported with -products")
+	}
+	checkDecayFlag(*decay, *products)
 	initdb()
+	if *products && len(simids) > 1 {
+		fatal("-products cannot be used to compare simulations")
+	} else if *decay && len(simids) > 1 {
+		fatal("-decay cannot be used to compare simulations")
+	}
+	f := ff.Filter()
+	f.Prototypes = list(&proto)
 
-	proto := fs.Arg(0)
+	if *decay {
+		xys, err := query.DecayedInvSeries(db, simid, f, decayData)
+		fatalif(err)
+		var buf bytes.Buffer
+		writeSeries(&buf, "Quantity", xys)
+		if pf.on() {
+			pf.draw(&buf, timeUnit.Label(), "Inventory (kg)", proto+" Decayed Inventory"+ff.label())
+		} else {
+			fmt.Print(buf.String())
+		}
+		return
+	}
+
+	cols := query.Cols{Agent: "inv.AgentId", Proto: "a.Prototype", Spec: "a.Spec", Nuc: "c.NucId"}
 
-	filter := nuclidefilter(*nucs)
+	if *products {
+		s := `
+SELECT {{time "tl"}} AS Time,q.Quality AS Quality,IFNULL(sub.qty, 0) AS Quantity
+FROM timelist AS tl
+JOIN (SELECT DISTINCT Quality FROM products WHERE simid=? {{index . 0}}) AS q
+LEFT JOIN (
+	SELECT tl.Time AS time,pd.Quality AS quality,SUM(inv.Quantity) AS qty
+	FROM inventories AS inv
+	JOIN timelist AS tl ON UNLIKELY(inv.starttime <= tl.time) AND inv.endtime > tl.time AND tl.simid=inv.simid
+	JOIN agents AS a ON a.agentid=inv.agentid AND a.simid=inv.simid
+	JOIN resources AS r ON r.resourceid=inv.resourceid AND r.simid=inv.simid
+	JOIN products AS pd ON pd.qualid=inv.qualid AND pd.simid=inv.simid
+	WHERE a.simid=? AND r.type='Product' {{index . 1}}
+	GROUP BY tl.Time,pd.Quality
+) AS sub ON sub.time=tl.time AND sub.quality=q.Quality
+WHERE tl.simid=?
+ORDER BY tl.Time,q.Quality
+`
+		filter, fargs := sqlFilter(f, cols)
+		qfilter := ""
+		iargs := []interface{}{simid}
+		if *quality != "" {
+			qfilter = "AND quality=?"
+			iargs = append(iargs, *quality)
+		}
+		iargs = append(append(iargs, simid), fargs...)
+		if *quality != "" {
+			filter += " AND quality=?"
+			iargs = append(iargs, *quality)
+		}
+		iargs = append(iargs, simid)
+		tmpl := sqlTmpl(s)
+		var buf bytes.Buffer
+		tmpl.Execute(&buf, []string{qfilter, filter})
+		customSql[cmd] = buf.String()
+		doCustom(os.Stdout, cmd, iargs...)
+		return
+	}
+
+	filter, fargs := sqlFilter(f, cols)
 	s := ""
-	if filter != "" {
+	if len(f.Nucs)+len(f.Elements) > 0 {
 		s = `
-SELECT tl.Time AS Time,IFNULL(sub.qty, 0) AS Quantity FROM timelist as tl
+SELECT {{time "tl"}} AS Time,IFNULL(sub.qty, 0) AS Quantity FROM timelist as tl
 LEFT JOIN (
 	SELECT tl.Time as time,SUM(inv.Quantity*c.MassFrac) AS qty
 	FROM inventories as inv
 	JOIN timelist as tl ON UNLIKELY(inv.starttime <= tl.time) AND inv.endtime > tl.time AND tl.simid=inv.simid
 	JOIN agents as a on a.agentid=inv.agentid AND a.simid=inv.simid
 	JOIN compositions as c on c.qualid=inv.qualid AND c.simid=inv.simid
-	WHERE a.simid=? AND a.prototype=? {{.}}
+	WHERE a.simid=? {{.}}
 	GROUP BY tl.Time
 ) AS sub ON sub.time=tl.time
 WHERE tl.simid=?
 `
 	} else {
 		s = `
-SELECT tl.Time AS Time,IFNULL(sub.qty, 0) AS Quantity
+SELECT {{time "tl"}} AS Time,IFNULL(sub.qty, 0) AS Quantity
 FROM timelist as tl
 LEFT JOIN (
 	SELECT tl.Time as time,SUM(inv.Quantity) AS qty
 	FROM inventories as inv
 	JOIN timelist as tl ON UNLIKELY(inv.starttime <= tl.time) AND inv.endtime > tl.time AND tl.simid=inv.simid
 	JOIN agents as a on a.agentid=inv.agentid AND a.simid=inv.simid
-	WHERE a.simid=? AND a.prototype=? {{.}}
+	WHERE a.simid=? {{.}}
 	GROUP BY tl.Time
 ) AS sub ON sub.time=tl.time
 WHERE tl.simid=?
 `
 	}
 
-	tmpl := template.Must(template.New("sql").Parse(s))
+	tmpl := sqlTmpl(s)
 	var buf bytes.Buffer
 	tmpl.Execute(&buf, filter)
 	customSql[cmd] = buf.String()
 	var buff bytes.Buffer
-	doCustom(&buff, cmd, simid, proto, simid)
-	if *plotit {
-		plot(&buff, "linespoints", "Time (Months)", proto+" inventory ( kg "+*nucs+")", "Inventory")
+	doSeries(&buff, cmd, func(id []byte) []interface{} {
+		return append(append([]interface{}{id}, fargs...), id)
+	})
+	if pf.on() {
+		pf.draw(&buff, timeUnit.Label(), "Inventory (kg)", proto+" Inventory"+ff.label())
 	} else {
 		fmt.Print(buff.String())
 	}
@@ -713,22 +1030,32 @@ WHERE tl.simid=?
 
 func doFlow(cmd string, args []string) {
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	plotit := fs.Bool("p", false, "plot the data")
-	commod := fs.String("commod", "", "filter by a commodity")
-	from := fs.String("from", "", "filter by supplying prototype")
-	to := fs.String("to", "", "filter by receiving prototype")
+	pf := addPlotFlags(fs, plot.Impulse)
+	from := fs.String("from", "", "filter by comma separated supplying prototypes")
+	to := fs.String("to", "", "filter by comma separated receiving prototypes")
 	byagent := fs.Bool("byagent", false, "switch to/from filters to be agent IDs")
-	nucs := fs.String("nucs", "", "filter by comma separated `nuclide`s")
+	ff := addFilterFlags(fs, "commod", "nucs", "elems", "time")
+	products := fs.Bool("products", false, "show Product resource flows by quality instead of material")
+	quality := fs.String("quality", "", "filter products by quality (requires -products)")
 	fs.Usage = func() {
 		log.Printf("Usage: %v", cmd)
 		log.Printf("%v\n", cmds.Help(cmd))
 		fs.PrintDefaults()
 	}
 	fs.Parse(args)
+	pf.parse()
+	checkProductFlags(*products, *quality, ff)
+	if *products && pf.on() {
+		fatal("-p is not supported with -products")
+	}
 	initdb()
+	if *products && len(simids) > 1 {
+		fatal("-products cannot be used to compare simulations")
+	}
+	f := ff.Filter()
 
 	s := `
-SELECT tl.Time AS Time,TOTAL(sub.qty) AS Quantity
+SELECT {{time "tl"}} AS Time,TOTAL(sub.qty) AS Quantity
 FROM timelist as tl
 LEFT JOIN (
 	SELECT t.simid AS simid,t.time as time,SUM(c.massfrac*r.quantity) as qty
@@ -737,56 +1064,62 @@ LEFT JOIN (
 	JOIN agents as send ON t.senderid=send.agentid AND send.simid=t.simid
 	JOIN agents as recv ON t.receiverid=recv.agentid AND recv.simid=t.simid
 	JOIN compositions as c ON c.qualid=r.qualid AND c.simid=r.simid
-	WHERE t.simid=? {{index . 0}} {{index . 1}} {{index . 2}} {{index . 3}}
+	WHERE t.simid=? {{index . 0}}
 	GROUP BY t.time
 ) AS sub ON tl.time=sub.time AND tl.simid=sub.simid
 WHERE tl.simid=?
 GROUP BY tl.Time;
 `
+	if *products {
+		s = `
+SELECT {{time "tl"}} AS Time,q.Quality AS Quality,TOTAL(sub.qty) AS Quantity
+FROM timelist AS tl
+JOIN (SELECT DISTINCT Quality FROM products WHERE simid=? {{index . 1}}) AS q
+LEFT JOIN (
+	SELECT t.simid AS simid,t.time AS time,pd.Quality AS quality,SUM(r.quantity) AS qty
+	FROM transactions AS t
+	JOIN resources AS r ON t.resourceid=r.resourceid AND r.simid=t.simid
+	JOIN agents AS send ON t.senderid=send.agentid AND send.simid=t.simid
+	JOIN agents AS recv ON t.receiverid=recv.agentid AND recv.simid=t.simid
+	JOIN products AS pd ON pd.qualid=r.qualid AND pd.simid=r.simid
+	WHERE t.simid=? AND r.type='Product' {{index . 0}}
+	GROUP BY t.time,pd.Quality
+) AS sub ON tl.time=sub.time AND tl.simid=sub.simid AND sub.quality=q.Quality
+WHERE tl.simid=?
+GROUP BY tl.Time,q.Quality;
+`
+	}
 
-	filters := make([]string, 4)
-	iargs := []interface{}{simid}
-	if *from != "" {
-		if *byagent {
-			filters[0] = "AND t.senderid=?"
-			fromid, err := strconv.Atoi(*from)
-			if err != nil {
-				log.Fatalf("invalid agent ID (-from=%v)", *from)
+	filters := make([]string, 2)
+	filter, fargs := transFilter(f, *from, *to, *byagent, *products)
+	iargs := func(id []byte) []interface{} {
+		var iargs []interface{}
+		if *products {
+			iargs = append(iargs, id)
+			if *quality != "" {
+				iargs = append(iargs, *quality)
 			}
-			iargs = append(iargs, fromid)
-		} else {
-			filters[0] = "AND send.prototype=?"
-			iargs = append(iargs, *from)
 		}
-	}
-	if *to != "" {
-		if *byagent {
-			filters[1] = "AND t.receiverid=?"
-			toid, err := strconv.Atoi(*to)
-			if err != nil {
-				log.Fatalf("invalid agent ID (-to=%v)", *to)
-			}
-			iargs = append(iargs, toid)
-		} else {
-			filters[1] = "AND recv.prototype=?"
-			iargs = append(iargs, *to)
+		iargs = append(append(iargs, id), fargs...)
+		if *quality != "" {
+			iargs = append(iargs, *quality)
 		}
+		return append(iargs, id)
 	}
-	if *commod != "" {
-		filters[2] = "AND t.commodity=?"
-		iargs = append(iargs, *commod)
+	if *quality != "" {
+		filters[1] = "AND quality=?"
+		filter += " AND pd.quality=?"
 	}
-	filters[3] = " " + nuclidefilter(*nucs)
-	iargs = append(iargs, simid)
+	filters[0] = filter
 
-	tmpl := template.Must(template.New("sql").Parse(s))
+	tmpl := sqlTmpl(s)
 	var buf bytes.Buffer
 	tmpl.Execute(&buf, filters)
 	customSql[cmd] = buf.String()
 	var buff bytes.Buffer
-	doCustom(&buff, cmd, iargs...)
-	if *plotit {
-		plot(&buff, "impulses", "Time (Months)", "Quantity Transacted ( kg "+*nucs+")", "Flow")
+	doSeries(&buff, cmd, iargs)
+	if pf.on() {
+		pf.draw(&buff, timeUnit.Label(), "Quantity Transacted (kg)", "Flow"+ff.label())
 	} else {
 		fmt.Print(buff.String())
 	}
@@ -800,14 +1133,27 @@ func doFlowGraph(cmd string, args []string) {
 		fs.PrintDefaults()
 	}
 	proto := fs.Bool("proto", false, "aggregate nodes by prototype")
-	t0 := fs.Int("t1", 0, "beginning of time interval (default is beginning of simulation)")
-	t1 := fs.Int("t2", -1, "end of time interval (default if end of simulation)")
+	ff := addFilterFlags(fs, "commod", "time")
 	fs.Parse(args)
 	initdb()
 
-	arcs, err := query.FlowGraph(db, simid, *t0, *t1, *proto)
+	arcs, err := query.FlowGraph(db, simid, ff.Filter(), *proto)
 	fatalif(err)
 
+	if outFormat != tableFormat {
+		rw := newResultWriter(os.Stdout)
+		fatalif(rw.Header("SrcId", "SrcProto", "DstId", "DstProto", "Commodity", "Quantity"))
+		for _, arc := range arcs {
+			var src, dst interface{}
+			if !*proto {
+				src, dst = arc.SrcId, arc.DstId
+			}
+			fatalif(rw.Row(src, arc.SrcProto, dst, arc.DstProto, arc.Commod, arc.Quantity))
+		}
+		fatalif(rw.Flush())
+		return
+	}
+
 	fmt.Println("digraph ResourceFlows {")
 	fmt.Println("    overlap = false;")
 	fmt.Println("    nodesep=1.0;")
@@ -831,28 +1177,46 @@ func doCreated(cmd string, args []string) {
 		log.Printf("%v\n", cmds.Help(cmd))
 		fs.PrintDefaults()
 	}
-	t0 := fs.Int("t1", 0, "beginning of time interval (default is beginning of simulation)")
-	t1 := fs.Int("t2", -1, "end of time interval (default if end of simulation)")
+	ff := addFilterFlags(fs, "proto", "spec", "nucs", "elems", "time")
+	decay := fs.Bool("decay", false, "decay created material to the end of the time interval (requires the gone build tag)")
 	fs.Parse(args)
+	checkDecayFlag(*decay, false)
 	initdb()
-
-	var agents []int
+	f := ff.Filter()
 
 	for _, arg := range fs.Args() {
 		id, err := strconv.Atoi(arg)
 		fatalif(err)
-		agents = append(agents, id)
+		f.Agents = append(f.Agents, id)
 	}
 
-	m, err := query.MatCreated(db, simid, *t0, *t1, agents...)
+	var m nuc.Material
+	var err error
+	if *decay {
+		m, err = query.DecayedMatCreated(db, simid, f, decayData)
+	} else {
+		m, err = query.MatCreated(db, simid, f)
+	}
 	fatalif(err)
-	fmt.Printf("%+v\n", m)
+
+	var nucs []int
+	for n := range m {
+		nucs = append(nucs, int(n))
+	}
+	sort.Ints(nucs)
+	rw := newResultWriter(os.Stdout)
+	fatalif(rw.Header("Nuc", "Quantity"))
+	for _, n := range nucs {
+		fatalif(rw.Row(n, float64(m[nuc.Nuc(n)])))
+	}
+	fatalif(rw.Flush())
 }
 
 func doEnergy(cmd string, args []string) {
 	fs := flag.NewFlagSet("energy", flag.ExitOnError)
-	t0 := fs.Int("t1", 0, "beginning of time interval (default is beginning of simulation)")
-	t1 := fs.Int("t2", -1, "end of time interval (default if end of simulation)")
+	ff := addFilterFlags(fs, "agents", "proto", "spec", "time")
+	model := fs.String("model", nuc.Recoverable.String(), "energy per fission `model`: recoverable (fission and capture), fission (no capture) or flat (200 MeV)")
+	data := fs.String("data", "", "read per nuclide fission and capture energies (MeV) from this `file` instead of the built-in data")
 	fs.Usage = func() {
 		log.Print("Usage: energy")
 		log.Printf("%v\n", cmds.Help(cmd))
@@ -861,14 +1225,345 @@ func doEnergy(cmd string, args []string) {
 	fs.Parse(args)
 	initdb()
 
-	e, err := query.EnergyProduced(db, simid, *t0, *t1)
+	em, err := nuc.ParseEnergyModel(*model)
+	fatalif(err)
+	fe := nuc.DefaultFissionEnergy
+	if *data != "" {
+		f, err := os.Open(*data)
+		fatalif(err)
+		fe, err = nuc.LoadFissionEnergy(f)
+		f.Close()
+		fatalif(err)
+	}
+	fpe := func(m nuc.Material) float64 { return fe.FPE(m, em) }
+
+	f := ff.Filter()
+	ags, err := query.AllAgents(db, simid, f.Agent())
+	fatalif(err)
+	var protos []string
+	seen := map[string]bool{}
+	for _, ag := range ags {
+		if ag.Kind == "Facility" && !seen[ag.Proto] {
+			seen[ag.Proto] = true
+			protos = append(protos, ag.Proto)
+		}
+	}
+	sort.Strings(protos)
+
+	rw := newResultWriter(os.Stdout)
+	fatalif(rw.Header("Prototype", "Energy", "MWh", "GWd"))
+	for _, proto := range protos {
+		pf := f
+		pf.Prototypes = []string{proto}
+		e, err := query.EnergyProducedFPE(db, simid, pf, fpe)
+		fatalif(err)
+		fatalif(rw.Row(proto, e, e/nuc.MWh, e/nuc.GWd))
+	}
+	fatalif(rw.Flush())
+}
+
+// decayData is the nuclear decay data used by the activity and decayheat
+// subcommands.  It is only available in binaries built with the gone tag
+// (see decay_gone.go).
+var decayData nuc.DecayData
+
+// doseData is the nuclear decay data and dose coefficients used by the
+// radiotox subcommand.  Like decayData it requires the gone build tag.
+var doseData nuc.DoseData
+
+func doDecay(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	pf := addPlotFlags(fs, plot.Line)
+	ff := addFilterFlags(fs, "agents", "spec", "nucs", "elems", "time")
+	at := fs.String("at", "", "show the per nuclide breakdown at this time in -tunit units instead of a time series (ignores -t1 and -t2)")
+	fs.Usage = func() {
+		log.Printf("Usage: %v <prototype>[,<prototype>...]", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	pf.parse()
+	if fs.NArg() < 1 {
+		fatal("must specify a prototype")
+	} else if *showquery {
+		fatalf("-query is not supported by %v", cmd)
+	} else if decayData == nil {
+		fatalf("%v requires cyan to be built with nuclear decay data (go build -tags gone)", cmd)
+	} else if *at != "" && pf.on() {
+		fatal("-p is not supported with -at")
+	}
+	proto := fs.Arg(0)
+	initdb()
+	f := ff.Filter()
+	f.Prototypes = list(&proto)
+
+	fn, series, col, title, unit := nuc.Activity, query.ActivitySeries, "Activity", "Activity", "Bq"
+	if cmd == "decayheat" {
+		fn, series, col, title, unit = nuc.DecayHeat, query.DecayHeatSeries, "DecayHeat", "Decay Heat", "W"
+	}
+
+	if *at != "" {
+		f.T0, f.T1 = 0, 0
+		m, err := query.InvAt(db, simid, timeStep(*at, 0), f)
+		fatalif(err)
+		vals := fn(m, decayData)
+		var nucs []nuc.Nuc
+		for n := range vals {
+			nucs = append(nucs, n)
+		}
+		sort.Slice(nucs, func(i, j int) bool { return vals[nucs[i]] > vals[nucs[j]] })
+		rw := newResultWriter(os.Stdout)
+		fatalif(rw.Header("Nuc", col))
+		for _, n := range nucs {
+			fatalif(rw.Row(int(n), vals[n]))
+		}
+		fatalif(rw.Flush())
+		return
+	}
+
+	xys, err := series(db, simid, f, decayData)
+	fatalif(err)
+	var buf bytes.Buffer
+	writeSeries(&buf, col, xys)
+	if pf.on() {
+		pf.draw(&buf, timeUnit.Label(), title+" ("+unit+")", proto+" "+title+ff.label())
+	} else {
+		fmt.Print(buf.String())
+	}
+}
+
+func doRadiotox(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	ff := addFilterFlags(fs, "agents", "spec", "commod", "nucs", "elems", "time")
+	at := fs.String("at", "", "time of the inventory in -tunit units (default is end of simulation)")
+	years := fs.String("years", "0", "comma separated times in years to decay the material before computing its radiotoxicity")
+	pathway := fs.String("pathway", "ingestion", "dose pathway: ingestion or inhalation")
+	fs.Usage = func() {
+		log.Printf("Usage: %v [<prototype>[,<prototype>...]]", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Print("Computes the radiotoxicity of the inventory of the prototypes' agents (all agents")
+		log.Print("if none are given) or, with -commod, of the material they transacted away on the")
+		log.Print("commodities between -t1 and -t2.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *showquery {
+		fatalf("-query is not supported by %v", cmd)
+	} else if doseData == nil {
+		fatalf("%v requires cyan to be built with nuclear decay data (go build -tags gone)", cmd)
+	}
+	p := nuc.Ingestion
+	switch *pathway {
+	case "ingestion":
+	case "inhalation":
+		p = nuc.Inhalation
+	default:
+		fatalf("invalid dose pathway '%v'", *pathway)
+	}
+	var decays []float64
+	for _, s := range list(years) {
+		y, err := strconv.ParseFloat(s, 64)
+		if err != nil || y < 0 {
+			fatalf("invalid decay time '%v'", s)
+		}
+		decays = append(decays, y)
+	}
+	initdb()
+	f := ff.Filter()
+	if fs.NArg() > 0 {
+		proto := fs.Arg(0)
+		f.Prototypes = list(&proto)
+	}
+
+	var m nuc.Material
+	var err error
+	if len(f.Commods) > 0 {
+		if *at != "" {
+			fatal("-at is not supported with -commod (use -t1 and -t2)")
+		}
+		from := f.Agent()
+		f.Agents, f.Prototypes, f.Specs = nil, nil, nil
+		m, err = query.Flow(db, simid, f, from, query.Filter{})
+	} else {
+		if *ff.t0 != "" || *ff.t1 != "" {
+			fatal("-t1 and -t2 require -commod (use -at for inventories)")
+		}
+		t := -1
+		if *at != "" {
+			t = timeStep(*at, 0)
+		}
+		m, err = query.InvAt(db, simid, t, f)
+	}
+	fatalif(err)
+
+	rw := newResultWriter(os.Stdout)
+	fatalif(rw.Header("Years", "Radiotox"))
+	for _, y := range decays {
+		decayed := nuc.Decay(m, doseData, y*nuc.Year)
+		fatalif(rw.Row(y, nuc.Total(nuc.Radiotox(decayed, doseData, p))))
+	}
+	fatalif(rw.Flush())
+}
+
+func doEnrich(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	pf := addPlotFlags(fs, plot.Impulse)
+	ff := addFilterFlags(fs, "agents", "proto", "spec", "commod", "time")
+	feed := fs.Float64("feed", nuc.NaturalAssay, "U235 mass fraction of the enrichment feed")
+	tails := fs.Float64("tails", nuc.DefaultTailsAssay, "U235 mass fraction of the enrichment tails")
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Print("Uranium transacted away by the agents is assumed to be enrichment product.")
+		log.Print("Zero agent filters uses all agents with the Enrichment archetype.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	pf.parse()
+	if *showquery {
+		fatalf("-query is not supported by %v", cmd)
+	}
+	initdb()
+	f := ff.Filter()
+	if len(f.Agents)+len(f.Prototypes)+len(f.Specs) == 0 {
+		f.Specs = []string{"Enrichment"}
+	}
+
+	xys, err := query.EnrichSeries(db, simid, f, *feed, *tails)
 	fatalif(err)
-	fmt.Println(e)
+
+	times := timeValues()
+	var buf bytes.Buffer
+	rw := newResultWriter(&buf)
+	if cmd == "swu" {
+		fatalif(rw.Header("Time", "SWU"))
+	} else {
+		fatalif(rw.Header("Time", "Product", "Feed", "Tails", "SWU"))
+	}
+	for _, xy := range xys {
+		if cmd == "swu" {
+			fatalif(rw.Row(times[xy.X], xy.SWU))
+		} else {
+			fatalif(rw.Row(times[xy.X], xy.Product, xy.Feed, xy.Tails, xy.SWU))
+		}
+	}
+	fatalif(rw.Flush())
+
+	if !pf.on() {
+		fmt.Print(buf.String())
+	} else if cmd == "swu" {
+		pf.draw(&buf, timeUnit.Label(), "Separative Work (kg-SWU)", "Enrichment SWU")
+	} else {
+		pf.draw(&buf, timeUnit.Label(), "Uranium (kg) / Separative Work (kg-SWU)", "Enrichment")
+	}
+}
+
+func doBurnup(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	pf := addPlotFlags(fs, plot.Impulse)
+	ff := addFilterFlags(fs, "agents", "proto", "spec", "commod", "time")
+	by := fs.String("by", "proto", "report the burnup of each discharged batch, the average by prototype or a time series of the average of batches discharged at each time: batch, proto or time")
+	eff := fs.Float64("eff", query.DefaultEfficiency, "thermal efficiency converting the reactors' TimeSeriesPower to thermal power (1 if it is thermal)")
+	vector := fs.Bool("vector", false, "show the average isotopic vector (mass fractions) of the discharged fuel instead")
+	fs.Usage = func() {
+		log.Printf("Usage: %v", cmd)
+		log.Printf("%v\n", cmds.Help(cmd))
+		log.Print("Reactors are agents reporting power.  A batch is the material a reactor transacts")
+		log.Print("away (on -commod commodities) at one time and is credited with the energy the")
+		log.Print("reactor produced since its previous discharge.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	pf.parse()
+	if *showquery {
+		fatalf("-query is not supported by %v", cmd)
+	} else if *by != "batch" && *by != "proto" && *by != "time" {
+		fatalf("invalid -by value '%v' (must be batch, proto or time)", *by)
+	} else if *eff <= 0 || *eff > 1 {
+		fatalf("invalid thermal efficiency %v (must be 0 < eff <= 1)", *eff)
+	} else if pf.on() && (*vector || *by != "time") {
+		fatal("only -by=time burnup can be plotted")
+	}
+	initdb()
+	f := ff.Filter()
+
+	if *by == "time" && !*vector {
+		xys, err := query.BurnupSeries(db, simid, f, *eff)
+		fatalif(err)
+		var buf bytes.Buffer
+		writeSeries(&buf, "Burnup", xys)
+		if pf.on() {
+			pf.draw(&buf, timeUnit.Label(), "Burnup (MWd/kgHM)", "Average Discharge Burnup")
+		} else {
+			fmt.Print(buf.String())
+		}
+		return
+	}
+
+	bs, err := query.Batches(db, simid, f, *eff)
+	fatalif(err)
+	rw := newResultWriter(os.Stdout)
+	switch {
+	case *vector:
+		m := query.DischargedMat(bs).Normalize()
+		var nucs []int
+		for n := range m {
+			nucs = append(nucs, int(n))
+		}
+		sort.Ints(nucs)
+		fatalif(rw.Header("Nuc", "MassFrac"))
+		for _, n := range nucs {
+			fatalif(rw.Row(n, float64(m[nuc.Nuc(n)])))
+		}
+	case *by == "batch":
+		times := timeValues()
+		fatalif(rw.Header("AgentId", "Prototype", "Time", "HM", "Energy", "Burnup"))
+		for _, b := range bs {
+			fatalif(rw.Row(b.AgentId, b.Proto, times[b.Time], b.HM, b.Energy/nuc.MWd, b.Burnup()))
+		}
+	default:
+		byProto := map[string][]query.Batch{}
+		var protos []string
+		for _, b := range bs {
+			if byProto[b.Proto] == nil {
+				protos = append(protos, b.Proto)
+			}
+			byProto[b.Proto] = append(byProto[b.Proto], b)
+		}
+		sort.Strings(protos)
+		fatalif(rw.Header("Prototype", "Batches", "HM", "Energy", "Burnup"))
+		for _, proto := range protos {
+			pbs := byProto[proto]
+			var hm, energy float64
+			for _, b := range pbs {
+				hm += b.HM
+				energy += b.Energy
+			}
+			fatalif(rw.Row(proto, len(pbs), hm, energy/nuc.MWd, query.AvgBurnup(pbs)))
+		}
+	}
+	fatalif(rw.Flush())
+}
+
+// fatalStatus is the exit status for errors.  It is 2 for commands that
+// report their result through an exit status of 1 (i.e. diff).
+var fatalStatus = 1
+
+// fatal prints v like log.Print and exits with fatalStatus.
+func fatal(v ...interface{}) {
+	log.Print(v...)
+	os.Exit(fatalStatus)
+}
+
+// fatalf prints v like log.Printf and exits with fatalStatus.
+func fatalf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	os.Exit(fatalStatus)
 }
 
 func fatalif(err error) {
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 }
 
@@ -922,27 +1617,29 @@ func (cs *CmdSet) Execute(args []string) {
 	f(cmd, args[1:])
 }
 
-func nuclidefilter(nucs string) string {
-	if len(nucs) == 0 {
-		return ""
-	}
-
-	nnucs := []nuc.Nuc{}
-	for _, n := range strings.Split(nucs, ",") {
-		nuc, err := nuc.Id(strings.TrimSpace(n))
-		fatalif(err)
-		nnucs = append(nnucs, nuc)
-	}
-
-	if len(nnucs) == 1 {
-		return fmt.Sprintf(" AND c.nucid = %v", int(nnucs[0]))
+// checkProductFlags exits if the -products and -quality flags of a
+// subcommand are used in an invalid combination with each other or with
+// nuclide filters.
+func checkProductFlags(products bool, quality string, ff *filterFlags) {
+	if quality != "" && !products {
+		fatal("-quality requires -products")
+	} else if products && len(list(ff.nucs))+len(list(ff.elems)) > 0 {
+		fatal("-nucs and -elems cannot be used with -products")
 	}
+}
 
-	filter := fmt.Sprintf(" AND c.nucid IN (%v", int(nnucs[0]))
-	for _, nuc := range nnucs[1:] {
-		filter += fmt.Sprintf(",%v", int(nuc))
+// checkDecayFlag exits if the -decay flag of inv or created is set but can't
+// be used.
+func checkDecayFlag(decay, products bool) {
+	if !decay {
+		return
+	} else if decayData == nil {
+		fatal("-decay requires cyan to be built with nuclear decay data (go build -tags gone)")
+	} else if products {
+		fatal("-decay cannot be used with -products")
+	} else if *showquery {
+		fatal("-query is not supported with -decay")
 	}
-	return filter + ") "
 }
 
 func doTaint(cmd string, args []string) {
@@ -958,7 +1655,7 @@ func doTaint(cmd string, args []string) {
 	initdb()
 
 	if *t == -1 || *res == -1 {
-		log.Fatalf("'-t' and '-res' flags are both required and cannot be negative")
+		fatalf("'-t' and '-res' flags are both required and cannot be negative")
 	}
 
 	roots := taint.TreeFromDb(db, simid)
@@ -970,7 +1667,7 @@ func doTaint(cmd string, args []string) {
 		}
 	}
 	if base == nil {
-		log.Fatalf("couldn't find resource id %v in graph", *res)
+		fatalf("couldn't find resource id %v in graph", *res)
 	}
 
 	si, err := query.SimStat(db, simid)
@@ -979,8 +1676,7 @@ func doTaint(cmd string, args []string) {
 
 	// print graph dot file
 	byproto := false
-	t1, t2 := 0, -1
-	arcs, err := query.FlowGraph(db, simid, t1, t2, byproto)
+	arcs, err := query.FlowGraph(db, simid, query.Filter{}, byproto)
 	fatalif(err)
 
 	fmt.Println("digraph ResourceFlows {")
@@ -1018,43 +1714,131 @@ func initdb() {
 		// don't need a database for printing queries
 		return
 	} else if *dbname == "" {
-		log.Fatal("must specify database with -db flag")
+		fatal("must specify database with -db flag")
 	}
 
-	var err error
-	db, err = sql.Open("sqlite3", *dbname)
+	if db == nil {
+		opendb()
+	}
+
+	simids := postprocess(db)
+
+	if *watch > 0 {
+		watchDone = true
+		for _, id := range simids {
+			info, err := post.Info(db, id)
+			fatalif(err)
+			if info == nil || info.Status != post.StatusComplete {
+				watchDone = false
+			}
+		}
+	}
+}
+
+// postprocess runs post processing on all simulations in db and returns
+// their simids.
+func postprocess(db *sql.DB) [][]byte {
+	proc := &post.Processor{DB: db, Jobs: postJobs, Force: postForce}
+	if *watch > 0 {
+		if watchProcs[db] == nil {
+			watchProcs[db] = &post.Processor{DB: db, Jobs: postJobs, Force: postForce, Incremental: true, IdleLimit: *watchidle}
+		}
+		proc = watchProcs[db]
+	}
+	if *progress {
+		proc.Progress = (&progressBar{w: os.Stderr}).Update
+	}
+	simids, err := proc.Run(context.Background())
+	fatalif(err)
+	return simids
+}
+
+// opendb opens the database named by the -db flag along with its post
+// processing sidecar and determines the simid to analyze.
+func opendb() {
+	db, sidecar = openPath(*dbname, *postdb)
+	simids = parseSimIds(db, *simidstr)
+	simid = simids[0]
+}
+
+// openPath opens the cyclus database at path with the post processing
+// sidecar at postpath, or at the default sidecar path if postpath is empty
+// and a sidecar exists there.  HDF5 databases are opened through their
+// cached sqlite conversion.  It returns true if a sidecar is used.
+func openPath(path, postpath string) (*sql.DB, bool) {
+	dbpath, err := source.Cached(path)
+	fatalif(err)
+
+	if postpath == "" {
+		if _, err := os.Stat(post.SidecarPath(dbpath)); err == nil {
+			postpath = post.SidecarPath(dbpath)
+		}
+	}
+
+	if postpath == "" {
+		conn, err := sql.Open("sqlite3", dbpath)
+		fatalif(err)
+		return conn, false
+	}
+	conn, err := openSidecar(dbpath, postpath)
 	fatalif(err)
+	return conn, true
+}
 
-	if *simidstr == "" {
+// parseSimIds parses a -simid flag value - a comma separated list of simids
+// in hex, "all" for every simulation in db or "" for the first one.
+func parseSimIds(db *sql.DB, v string) (ids [][]byte) {
+	if v == "" || v == "all" {
 		ids, err := query.SimIds(db)
 		fatalif(err)
-		simid = ids[0]
-	} else {
-		simid = uuid.Parse(*simidstr)
-		if simid == nil {
-			log.Fatalf("invalid simid '%s'", *simidstr)
+		if len(ids) == 0 {
+			fatal("no simulations found in the database")
+		} else if v == "" {
+			ids = ids[:1]
 		}
+		return ids
 	}
 
-	post.Process(db)
+	for _, s := range strings.Split(v, ",") {
+		id := uuid.Parse(strings.TrimSpace(s))
+		if id == nil {
+			fatalf("invalid simid '%s'", s)
+		}
+		ids = append(ids, id)
+	}
+	return ids
 }
 
-func plot(data *bytes.Buffer, style string, xlabel, ylabel, title string) {
-	s := ""
-	s += `set xlabel '{{.Xlabel}}';`
-	s += `set ylabel '{{.Ylabel}}';`
-	s += `plot '-' every ::2 using 1:2 with {{.Style}} title '{{.Title}}';`
-	s += `pause -1`
+// openSidecar opens the sidecar database at postpath with the cyclus
+// database at dbpath attached read-only to every connection.
+func openSidecar(dbpath, postpath string) (*sql.DB, error) {
+	if _, err := os.Stat(dbpath); err != nil {
+		return nil, err
+	}
+
+	// each sidecar attaches its own cyclus database so it needs a separately
+	// registered driver
+	name := fmt.Sprintf("sqlite3-sidecar-%v", len(sql.Drivers()))
+	sql.Register(name, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			_, err := conn.Exec(post.AttachSql, []driver.Value{post.RawURI(dbpath)})
+			return err
+		},
+	})
+	return sql.Open(name, postpath)
+}
 
-	tmpl := template.Must(template.New("gnuplot").Parse(s))
-	var buf bytes.Buffer
-	config := struct{ Style, Xlabel, Ylabel, Title string }{style, xlabel, ylabel, title}
-	err := tmpl.Execute(&buf, config)
-	fatalif(err)
+// sqlTmpl parses a subcommand's SQL template.  In addition to the
+// subcommand's own data, templates can use {{time "<alias>"}} for the column
+// of the TimeList table with the given alias that holds times in the -tunit
+// unit.
+func sqlTmpl(s string) *template.Template {
+	return template.Must(template.New("sql").Funcs(template.FuncMap{"time": timeUnit.Col}).Parse(s))
+}
 
-	cmd := exec.Command("gnuplot", "-p", "-e", buf.String())
-	cmd.Stdin = data
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	fatalif(cmd.Run())
+// execTmpl returns the SQL produced by the template s for data.
+func execTmpl(s string, data interface{}) string {
+	var buf bytes.Buffer
+	fatalif(sqlTmpl(s).Execute(&buf, data))
+	return buf.String()
 }
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// Output formats selected with the -format flag.
+const (
+	tableFormat = "table"
+	csvFormat   = "csv"
+	tsvFormat   = "tsv"
+	jsonFormat  = "json"
+	jsonlFormat = "jsonl"
+)
+
+var formats = []string{tableFormat, csvFormat, tsvFormat, jsonFormat, jsonlFormat}
+
+// outFormat is the parsed -format flag.
+var outFormat = tableFormat
+
+func parseFormat(s string) string {
+	for _, f := range formats {
+		if f == s {
+			return f
+		}
+	}
+	fatalf("invalid output format '%v' (must be one of %v)", s, strings.Join(formats, ","))
+	return ""
+}
+
+// resultWriter writes the rows of a subcommand's result in the -format
+// output format.  Header must be called once before any rows are written.
+// Row values are nil (NULL), int64, float64, bool, string, []byte or
+// time.Time.
+type resultWriter interface {
+	Header(cols ...string) error
+	Row(vals ...interface{}) error
+	Flush() error
+}
+
+func newResultWriter(w io.Writer) resultWriter {
+	switch outFormat {
+	case csvFormat:
+		return &delimWriter{w: bufio.NewWriter(w), sep: ',', field: csvField, null: ""}
+	case tsvFormat:
+		return &delimWriter{w: bufio.NewWriter(w), sep: '\t', field: tsvField, null: `\N`}
+	case jsonFormat:
+		return &jsonWriter{w: bufio.NewWriter(w), array: true}
+	case jsonlFormat:
+		return &jsonWriter{w: bufio.NewWriter(w)}
+	}
+	return &tableWriter{w: tabwriter.NewWriter(w, 4, 4, 1, ' ', 0)}
+}
+
+// rowValue converts a value scanned from column col of a query result for
+// writing.  Simulation ids are converted to their UUID form.
+func rowValue(col string, v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		if len(b) == 16 && strings.Contains(strings.ToLower(col), "simid") {
+			return uuid.UUID(b).String()
+		}
+		return string(b)
+	}
+	return v
+}
+
+// text formats a non-NULL value for the text based formats.
+func text(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case int:
+		return strconv.Itoa(x)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		return x.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// tableWriter writes space aligned columns with NULL for NULL values.  Tabs
+// within values are replaced by spaces to keep the columns aligned.  The
+// header line is omitted with -noheader.
+type tableWriter struct {
+	w *tabwriter.Writer
+}
+
+func (tw *tableWriter) Header(cols ...string) error {
+	if *noheader {
+		return nil
+	}
+	return tw.line(cols)
+}
+
+func (tw *tableWriter) Row(vals ...interface{}) error {
+	fields := make([]string, len(vals))
+	for i, v := range vals {
+		if v == nil {
+			fields[i] = "NULL"
+		} else {
+			fields[i] = text(v)
+		}
+	}
+	return tw.line(fields)
+}
+
+func (tw *tableWriter) line(fields []string) error {
+	for _, f := range fields {
+		f = strings.Replace(f, "\t", " ", -1)
+		if _, err := tw.w.Write([]byte(f + "\t")); err != nil {
+			return err
+		}
+	}
+	_, err := tw.w.Write([]byte("\n"))
+	return err
+}
+
+func (tw *tableWriter) Flush() error { return tw.w.Flush() }
+
+// delimWriter writes delimiter separated fields (i.e. CSV or TSV) encoded
+// with field and null for NULL values.  The header line is omitted with
+// -noheader.
+type delimWriter struct {
+	w     *bufio.Writer
+	sep   byte
+	field func(string) string
+	null  string
+}
+
+func (dw *delimWriter) Header(cols ...string) error {
+	if *noheader {
+		return nil
+	}
+	fields := make([]interface{}, len(cols))
+	for i, c := range cols {
+		fields[i] = c
+	}
+	return dw.Row(fields...)
+}
+
+func (dw *delimWriter) Row(vals ...interface{}) error {
+	for i, v := range vals {
+		if i > 0 {
+			dw.w.WriteByte(dw.sep)
+		}
+		if v == nil {
+			dw.w.WriteString(dw.null)
+		} else {
+			dw.w.WriteString(dw.field(text(v)))
+		}
+	}
+	_, err := dw.w.WriteString("\n")
+	return err
+}
+
+func (dw *delimWriter) Flush() error { return dw.w.Flush() }
+
+// csvField quotes s as described in RFC 4180 if necessary.  Empty strings
+// are always quoted so they are distinct from NULLs, which are empty
+// unquoted fields.
+func csvField(s string) string {
+	if s != "" && !strings.ContainsAny(s, ",\"\r\n") {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// tsvField escapes backslashes, tabs and line breaks in s.  NULLs are
+// written as \N.
+var tsvField = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`).Replace
+
+// jsonWriter writes each row as a JSON object keyed by column name with
+// null for NULL values.  Rows are either written as elements of a single
+// array or one per line (JSON lines).
+type jsonWriter struct {
+	w     *bufio.Writer
+	array bool
+	keys  []string
+	n     int
+}
+
+func (jw *jsonWriter) Header(cols ...string) error {
+	jw.keys = make([]string, len(cols))
+	for i, c := range cols {
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		jw.keys[i] = string(data)
+	}
+	return nil
+}
+
+func (jw *jsonWriter) Row(vals ...interface{}) error {
+	if jw.array && jw.n == 0 {
+		jw.w.WriteString("[\n")
+	} else if jw.array {
+		jw.w.WriteString(",\n")
+	}
+	jw.n++
+
+	jw.w.WriteString("{")
+	for i, v := range vals {
+		if i > 0 {
+			jw.w.WriteString(",")
+		}
+		data, err := json.Marshal(jsonValue(v))
+		if err != nil {
+			return err
+		}
+		jw.w.WriteString(jw.keys[i] + ":")
+		jw.w.Write(data)
+	}
+	jw.w.WriteString("}")
+	if !jw.array {
+		jw.w.WriteString("\n")
+	}
+	return nil
+}
+
+func (jw *jsonWriter) Flush() error {
+	if jw.array && jw.n == 0 {
+		jw.w.WriteString("[]\n")
+	} else if jw.array {
+		jw.w.WriteString("\n]\n")
+	}
+	return jw.w.Flush()
+}
+
+// jsonValue converts v to a value encoding/json can marshal.  Non-finite
+// floats have no JSON representation and become null.  Binary data that
+// isn't valid text is hex encoded.
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil
+		}
+	case []byte:
+		if utf8.Valid(x) {
+			return string(x)
+		}
+		return hex.EncodeToString(x)
+	}
+	return v
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestResultWriter(t *testing.T) {
+	var tests = []struct {
+		Format string
+		Want   string
+	}{
+		{tableFormat, "Name    Qty Note \nU235    1.5 NULL \na b\"c,d 2        \n"},
+		{csvFormat, "Name,Qty,Note\nU235,1.5,\n\"a\tb\"\"c,d\",2,\"\"\n"},
+		{tsvFormat, "Name\tQty\tNote\nU235\t1.5\t\\N\na\\tb\"c,d\t2\t\n"},
+		{jsonFormat, "[\n{\"Name\":\"U235\",\"Qty\":1.5,\"Note\":null},\n{\"Name\":\"a\\tb\\\"c,d\",\"Qty\":2,\"Note\":\"\"}\n]\n"},
+		{jsonlFormat, "{\"Name\":\"U235\",\"Qty\":1.5,\"Note\":null}\n{\"Name\":\"a\\tb\\\"c,d\",\"Qty\":2,\"Note\":\"\"}\n"},
+	}
+
+	defer func(f string) { outFormat = f }(outFormat)
+	for _, test := range tests {
+		outFormat = test.Format
+		var buf bytes.Buffer
+		rw := newResultWriter(&buf)
+		if err := rw.Header("Name", "Qty", "Note"); err != nil {
+			t.Fatal(err)
+		}
+		if err := rw.Row([]byte("U235"), 1.5, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := rw.Row("a\tb\"c,d", int64(2), ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := rw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.Want {
+			t.Errorf("[%v] got:\n%q\nwant:\n%q", test.Format, got, test.Want)
+		}
+	}
+}
+
+func TestResultWriter_Empty(t *testing.T) {
+	defer func(f string) { outFormat = f }(outFormat)
+	outFormat = jsonFormat
+	var buf bytes.Buffer
+	rw := newResultWriter(&buf)
+	rw.Header("Energy")
+	rw.Flush()
+	if got := buf.String(); got != "[]\n" {
+		t.Errorf("got %q, want %q", got, "[]\n")
+	}
+
+	if v := jsonValue(math.NaN()); v != nil {
+		t.Errorf("NaN: got %v, want nil", v)
+	}
+}
+
+func TestRowValue(t *testing.T) {
+	id := []byte("\x12\x34\x56\x78\x90\xab\xcd\xef\x12\x34\x56\x78\x90\xab\xcd\xef")
+	if got, want := rowValue("SimId", id), "12345678-90ab-cdef-1234-567890abcdef"; got != want {
+		t.Errorf("simid: got %v, want %v", got, want)
+	}
+	if got, want := rowValue("Prototype", []byte("LWR")), "LWR"; got != want {
+		t.Errorf("text: got %v, want %v", got, want)
+	}
+}
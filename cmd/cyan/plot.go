@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"log"
+	"math"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/cyan/plot"
+	"github.com/rwcarlsen/cyan/query"
+)
+
+// plotFlags holds the plotting flags shared by time series subcommands.
+type plotFlags struct {
+	plot  *bool
+	out   *string
+	style *string
+}
+
+// addPlotFlags registers the plotting flags on fs with style as the
+// subcommand's default plot style.
+func addPlotFlags(fs *flag.FlagSet, style plot.Style) *plotFlags {
+	var styles []string
+	for _, s := range plot.Styles {
+		styles = append(styles, string(s))
+	}
+	return &plotFlags{
+		plot:  fs.Bool("p", false, "plot the data"),
+		out:   fs.String("o", "", "write the plot to this .svg or .png file instead of opening it in the system viewer (implies -p)"),
+		style: fs.String("style", string(style), "plot style: "+strings.Join(styles, ", ")),
+	}
+}
+
+// on returns true if the data should be plotted.
+func (pf *plotFlags) on() bool { return *pf.plot || *pf.out != "" }
+
+// parse validates the parsed plot flags and, if plotting, selects the output
+// format draw reads the data from.
+func (pf *plotFlags) parse() {
+	if _, err := plot.ParseStyle(*pf.style); err != nil {
+		fatal(err)
+	}
+	if pf.on() {
+		outFormat = tsvFormat
+		*noheader = false
+	}
+}
+
+// draw plots the time series output of a subcommand - a time column
+// followed by a series per value column - and saves it to the -o file or
+// opens it in the system viewer.
+func (pf *plotFlags) draw(data *bytes.Buffer, xlabel, ylabel, title string) {
+	if *showquery {
+		return
+	}
+
+	lines := strings.Split(strings.TrimRight(data.String(), "\n"), "\n")
+	cols := strings.Split(lines[0], "\t")
+	series := make([]plot.Series, len(cols)-1)
+	for i := range series {
+		series[i].Label = cols[i+1]
+		if len(series) == 1 {
+			series[i].Label = title
+		}
+	}
+
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		x, err := strconv.ParseFloat(fields[0], 64)
+		if timeUnit == query.Date {
+			var t time.Time
+			t, err = time.Parse("2006-01-02", fields[0])
+			x = float64(t.Unix())
+		}
+		fatalif(err)
+
+		for i := range series {
+			y, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				y = math.NaN()
+			}
+			series[i].X = append(series[i].X, x)
+			series[i].Y = append(series[i].Y, y)
+		}
+	}
+
+	p := &plot.Plot{
+		Title:  title,
+		XLabel: xlabel,
+		YLabel: ylabel,
+		Style:  plot.Style(*pf.style),
+		Series: series,
+		XTime:  timeUnit == query.Date,
+	}
+	if *pf.out != "" {
+		fatalif(p.Save(*pf.out))
+		return
+	}
+
+	f, err := ioutil.TempFile("", "cyan-plot-*.svg")
+	fatalif(err)
+	fatalif(p.SVG(f))
+	fatalif(f.Close())
+	log.Printf("plot written to %v", f.Name())
+	if err := view(f.Name()); err != nil {
+		log.Printf("failed to open plot viewer: %v", err)
+	}
+}
+
+// view opens the file at path with the system's default application.
+func view(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", path)
+	case "darwin":
+		cmd = exec.Command("open", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	return cmd.Start()
+}
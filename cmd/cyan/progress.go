@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rwcarlsen/cyan/post"
+)
+
+const (
+	barWidth    = 40
+	barInterval = 100 * time.Millisecond
+)
+
+// progressBar draws post processing progress reports as a single
+// self-overwriting line.  Simulations walked concurrently share the line.
+type progressBar struct {
+	w    io.Writer
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (b *progressBar) Update(p post.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !p.Done && time.Since(b.last) < barInterval {
+		return
+	}
+	b.last = time.Now()
+
+	frac := 1.0
+	if !p.Done && p.TotalRoots > 0 {
+		frac = float64(p.Roots) / float64(p.TotalRoots)
+	}
+	n := int(frac * barWidth)
+	fmt.Fprintf(b.w, "\rsim %x [%v%v] %3.0f%% %v/%v resources, %v rows",
+		p.Simid, strings.Repeat("=", n), strings.Repeat(" ", barWidth-n), frac*100,
+		p.Resources, p.TotalResources, p.Rows)
+	if p.Done {
+		fmt.Fprintln(b.w)
+	}
+}
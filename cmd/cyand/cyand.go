@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -57,7 +58,7 @@ func share(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-func uploadInner(w http.ResponseWriter, r *http.Request, kill chan bool) {
+func uploadInner(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	// parse database from multi part form data
 	if err := r.ParseMultipartForm(MAX_MEMORY); err != nil {
 		log.Println(err)
@@ -90,10 +91,9 @@ func uploadInner(w http.ResponseWriter, r *http.Request, kill chan bool) {
 		return
 	}
 
-	select {
-	case <-kill:
+	if ctx.Err() != nil {
+		timedOut(w)
 		return
-	default:
 	}
 
 	// post process the database
@@ -120,9 +120,16 @@ func uploadInner(w http.ResponseWriter, r *http.Request, kill chan bool) {
 		return
 	}
 
-	ctx := post.NewContext(db, simids[0])
-	if err := ctx.WalkAll(); err != nil {
+	pctx := post.NewContext(db, simids[0])
+	if err := pctx.WalkAll(ctx); post.IsAlreadyPostErr(err) {
 		log.Println(err)
+	} else if ctx.Err() != nil {
+		timedOut(w)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Print(err)
+		return
 	}
 
 	// get simid
@@ -135,15 +142,14 @@ func uploadInner(w http.ResponseWriter, r *http.Request, kill chan bool) {
 	simid := ids[0]
 	rs := &Results{}
 
-	select {
-	case <-kill:
+	if ctx.Err() != nil {
+		timedOut(w)
 		return
-	default:
 	}
 
 	// create flow graph
 	combineProto := false
-	arcs, err := query.FlowGraph(db, simid, 0, -1, combineProto)
+	arcs, err := query.FlowGraph(db, simid, query.Filter{}, combineProto)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		log.Print(err)
@@ -171,7 +177,7 @@ func uploadInner(w http.ResponseWriter, r *http.Request, kill chan bool) {
 	dotf.Close()
 
 	var buf bytes.Buffer
-	cmd := exec.Command("dot", "-Tsvg", dotname)
+	cmd := exec.CommandContext(ctx, "dot", "-Tsvg", dotname)
 	cmd.Stdout = &buf
 	err = cmd.Run()
 	if err != nil {
@@ -181,14 +187,13 @@ func uploadInner(w http.ResponseWriter, r *http.Request, kill chan bool) {
 	}
 	rs.Flowgraph = buf.String()
 
-	select {
-	case <-kill:
+	if ctx.Err() != nil {
+		timedOut(w)
 		return
-	default:
 	}
 
 	// create agents table
-	rs.Agents, err = query.AllAgents(db, simid, "")
+	rs.Agents, err = query.AllAgents(db, simid, query.Filter{})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		log.Print(err)
@@ -257,10 +262,9 @@ func uploadInner(w http.ResponseWriter, r *http.Request, kill chan bool) {
 		return
 	}
 
-	select {
-	case <-kill:
+	if ctx.Err() != nil {
+		timedOut(w)
 		return
-	default:
 	}
 
 	// render all results and save page
@@ -275,20 +279,17 @@ func uploadInner(w http.ResponseWriter, r *http.Request, kill chan bool) {
 	}
 	defer f.Close()
 	resultTmpl.Execute(f, rs)
-	close(kill)
 }
 
 func upload(w http.ResponseWriter, r *http.Request) {
-	kill := make(chan bool)
-	go uploadInner(w, r, kill)
-
-	select {
-	case <-time.After(timeout):
-		close(kill)
-		http.Error(w, "operation timed out", http.StatusInternalServerError)
-		log.Print("db processing operation timed out")
-	case <-kill:
-	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	uploadInner(ctx, w, r)
+}
+
+func timedOut(w http.ResponseWriter) {
+	http.Error(w, "operation timed out", http.StatusInternalServerError)
+	log.Print("db processing operation timed out")
 }
 
 type ProdTrans struct {
@@ -13,6 +13,7 @@ import (
 
 var shownuc = flag.Bool("shownuc", true, "print corresponding nuclide next to each value")
 var decay = flag.Bool("decay", false, "print decay constants")
+var qval = flag.Bool("qval", false, "print energy (MeV) released per decay")
 var energy = flag.String("energy", "thermal", "thermal, fission")
 var yield = flag.String("yield", "", "print fission yields from `from-nuc` to given nuclides")
 var list = flag.Bool("list", false, "print a list of all nuclides")
@@ -71,6 +72,17 @@ func main() {
 				fmt.Printf("%v\n", v)
 			}
 		}
+	case *qval:
+		for _, nuc := range nucs {
+			v := gone.DecayEnergy(nuc)
+			if *shownuc {
+				if !*nonzero || v > 0 {
+					fmt.Printf("%v %v\n", nuc, v)
+				}
+			} else {
+				fmt.Printf("%v\n", v)
+			}
+		}
 	case len(*yield) > 0:
 		from := gone.Id(*yield)
 		e := gone.Thermal
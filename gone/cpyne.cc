@@ -2,6 +2,7 @@
 #include "cpyne.h"
 #include <string>
 #include <cstring>
+#include <set>
 
 int
 id_str(const char* nuc) {
@@ -38,6 +39,39 @@ decay_const(int nuc) {
   return pyne::decay_const(nuc);
 }
 
+double
+qval(int nuc) {
+  return pyne::q_val(nuc);
+}
+
+int
+decay_children(int nuc, int* kids, int n) {
+  std::set<int> children = pyne::decay_children(nuc);
+  int i = 0;
+  for (std::set<int>::iterator it = children.begin(); it != children.end(); ++it) {
+    if (i < n) {
+      kids[i] = *it;
+    }
+    i++;
+  }
+  return i;
+}
+
+double
+branch_ratio(int from, int to) {
+  return pyne::branch_ratio(from, to);
+}
+
+double
+ingest(int nuc, int source) {
+  return pyne::ingest_dose(nuc, source);
+}
+
+double
+inhale(int nuc, int source) {
+  return pyne::inhale_dose(nuc, source);
+}
+
 void
 init_nuc_data(const char* fpath) {
   pyne::NUC_DATA_PATH = fpath;
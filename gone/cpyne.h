@@ -14,6 +14,21 @@ extern "C" {
   double
   decay_const(int nuc);
 
+  double
+  qval(int nuc);
+
+  int
+  decay_children(int nuc, int* kids, int n);
+
+  double
+  branch_ratio(int from, int to);
+
+  double
+  ingest(int nuc, int source);
+
+  double
+  inhale(int nuc, int source);
+
   void
   init_nuc_data(const char* fpath);
 
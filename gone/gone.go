@@ -52,6 +52,32 @@ func DecayConst(nuc Nuc) float64 {
 	return float64(C.decay_const(C.int(nuc)))
 }
 
+// DecayEnergy returns the recoverable energy released per decay of nuc in
+// MeV (pyne's decay heat Q value) or zero if it is unknown.
+func DecayEnergy(nuc Nuc) float64 {
+	return float64(C.qval(C.int(nuc)))
+}
+
+// DecayChildren returns the nuclides nuc decays into.
+func DecayChildren(nuc Nuc) []Nuc {
+	kids := make([]C.int, 16)
+	n := int(C.decay_children(C.int(nuc), &kids[0], C.int(len(kids))))
+	if n > len(kids) {
+		kids = make([]C.int, n)
+		C.decay_children(C.int(nuc), &kids[0], C.int(len(kids)))
+	}
+	children := make([]Nuc, n)
+	for i := range children {
+		children[i] = Nuc(kids[i])
+	}
+	return children
+}
+
+// BranchRatio returns the fraction of decays of from producing to.
+func BranchRatio(from, to Nuc) float64 {
+	return float64(C.branch_ratio(C.int(from), C.int(to)))
+}
+
 type Energy int
 
 const (
@@ -74,3 +100,23 @@ func FissProdYield(from, to Nuc, e Energy) float64 {
 	}
 	return float64(C.fpyield(C.int(from), C.int(to), C.int(source))) / 100
 }
+
+type DoseSource int
+
+const (
+	EPA DoseSource = iota
+	DOE
+	GENII
+)
+
+// IngestDose returns the dose factor of nuc due to ingestion in mrem/pCi
+// from the given source or -1 if it is unknown.
+func IngestDose(nuc Nuc, src DoseSource) float64 {
+	return float64(C.ingest(C.int(nuc), C.int(src)))
+}
+
+// InhaleDose returns the dose factor of nuc due to inhalation in mrem/pCi
+// from the given source or -1 if it is unknown.
+func InhaleDose(nuc Nuc, src DoseSource) float64 {
+	return float64(C.inhale(C.int(nuc), C.int(src)))
+}
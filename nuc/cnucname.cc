@@ -1,3 +1,6 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
 #include "nucname.h"
 #include "cnucname.h"
 #include <string>
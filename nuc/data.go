@@ -6,20 +6,28 @@ const (
 	U233  = 922330000
 	Pu239 = 942390000
 	Pu241 = 942410000
-	Cu243 = 962430000
-	Cu245 = 962450000
-	Cu247 = 962470000
+	Cm243 = 962430000
+	Cm245 = 962450000
+	Cm247 = 962470000
 	Cf251 = 982510000
 )
 
+// Deprecated: the curium isotopes were misnamed.  Use Cm243, Cm245 and Cm247
+// instead.
+const (
+	Cu243 = Cm243
+	Cu245 = Cm245
+	Cu247 = Cm247
+)
+
 var FissNuc = []Nuc{
 	U235,
 	U233,
 	Pu239,
 	Pu241,
-	Cu243,
-	Cu245,
-	Cu247,
+	Cm243,
+	Cm245,
+	Cm247,
 	Cf251,
 }
 
@@ -41,15 +49,16 @@ var FertNuc = []Nuc{
 }
 
 // FissFertE contains eventual energy release per fission in MeV for fissile
-// and fertile isotopes.
+// and fertile isotopes.  It is the Flat energy model - see
+// DefaultFissionEnergy for nuclide specific values.
 var FissFertE = map[Nuc]float64{
 	U235:  200 * MeV,
 	U233:  200 * MeV,
 	Pu239: 200 * MeV,
 	Pu241: 200 * MeV,
-	Cu243: 200 * MeV,
-	Cu245: 200 * MeV,
-	Cu247: 200 * MeV,
+	Cm243: 200 * MeV,
+	Cm245: 200 * MeV,
+	Cm247: 200 * MeV,
 	Cf251: 200 * MeV,
 	Th232: 200 * MeV,
 	U234:  200 * MeV,
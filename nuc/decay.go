@@ -0,0 +1,178 @@
+package nuc
+
+import "math"
+
+// DecayData provides the nuclear decay data needed to compute the activity
+// and decay heat of materials.  The gone package's pyne data can be adapted
+// to it.
+type DecayData interface {
+	// DecayConst returns the decay constant of n in 1/s or zero if n is
+	// stable.
+	DecayConst(n Nuc) float64
+	// DecayEnergy returns the recoverable energy released per decay of n in
+	// Joules.
+	DecayEnergy(n Nuc) float64
+	// Branches returns the nuclides n decays into with the fraction of its
+	// decays (branching ratio) producing each.
+	Branches(n Nuc) map[Nuc]float64
+}
+
+// Activity returns the activity in Bq of each radioactive nuclide in m.
+func Activity(m Material, d DecayData) map[Nuc]float64 {
+	act := map[Nuc]float64{}
+	for n, qty := range m {
+		if a := d.DecayConst(n) * Atoms(n, qty); a > 0 {
+			act[n] = a
+		}
+	}
+	return act
+}
+
+// DecayHeat returns the decay heat in Watts of each radioactive nuclide in m.
+func DecayHeat(m Material, d DecayData) map[Nuc]float64 {
+	heat := map[Nuc]float64{}
+	for n, a := range Activity(m, d) {
+		if h := a * d.DecayEnergy(n); h > 0 {
+			heat[n] = h
+		}
+	}
+	return heat
+}
+
+// Total returns the sum of the per nuclide values in vals (e.g. from
+// Activity or DecayHeat).
+func Total(vals map[Nuc]float64) (tot float64) {
+	for _, v := range vals {
+		tot += v
+	}
+	return tot
+}
+
+// Decay returns a copy of m decayed for t seconds following the full decay
+// chains of d.
+func Decay(m Material, d DecayData, t float64) Material {
+	return NewDecayer(d).Decay(m, t)
+}
+
+// MinBranch is the fraction of a nuclide's atoms below which a decay chain
+// is no longer followed.
+const MinBranch = 1e-12
+
+// maxChain limits the length of followed decay chains as a guard against
+// cycles in decay data.
+const maxChain = 100
+
+// Decayer decays materials by solving the Bateman equations along every
+// decay chain of their nuclides.  The decay of each nuclide is cached, so a
+// Decayer should be reused when decaying many materials for the same times.
+type Decayer struct {
+	d     DecayData
+	cache map[decayKey]map[Nuc]float64
+}
+
+type decayKey struct {
+	n Nuc
+	t float64
+}
+
+func NewDecayer(d DecayData) *Decayer {
+	return &Decayer{d: d, cache: map[decayKey]map[Nuc]float64{}}
+}
+
+// Decay returns a copy of m decayed for t seconds.
+func (dc *Decayer) Decay(m Material, t float64) Material {
+	decayed := Material{}
+	for n, qty := range m {
+		if qty == 0 {
+			continue
+		} else if t == 0 {
+			decayed[n] += qty
+			continue
+		}
+		for kid, frac := range dc.nuclide(n, t) {
+			// frac is in atoms, so convert it to mass
+			decayed[kid] += qty * Mass(frac*float64(kid.A())/float64(n.A()))
+		}
+	}
+	return decayed
+}
+
+// nuclide returns the atoms of each nuclide in the decay chains of n per atom
+// of n after t seconds.
+func (dc *Decayer) nuclide(n Nuc, t float64) map[Nuc]float64 {
+	key := decayKey{n, t}
+	if atoms, ok := dc.cache[key]; ok {
+		return atoms
+	}
+
+	atoms := map[Nuc]float64{}
+	var path []Nuc
+	var lambdas []float64
+	var walk func(n Nuc, branch float64)
+	walk = func(n Nuc, branch float64) {
+		lambda := dc.d.DecayConst(n)
+		for _, l := range lambdas {
+			// the Bateman solution is singular for equal decay constants,
+			// so nudge them apart
+			if lambda > 0 && math.Abs(lambda-l) < 1e-6*lambda {
+				lambda *= 1 + 1e-5
+			}
+		}
+		path = append(path, n)
+		lambdas = append(lambdas, lambda)
+		defer func() {
+			path = path[:len(path)-1]
+			lambdas = lambdas[:len(lambdas)-1]
+		}()
+
+		if v := branch * bateman(lambdas, t); v > 0 {
+			atoms[n] += v
+		}
+		if lambda == 0 || len(path) >= maxChain {
+			return
+		}
+	kids:
+		for kid, br := range dc.d.Branches(n) {
+			if br*branch < MinBranch || kid == n {
+				continue
+			}
+			for _, prev := range path {
+				if prev == kid {
+					continue kids
+				}
+			}
+			walk(kid, branch*br)
+		}
+	}
+	walk(n, 1)
+
+	dc.cache[key] = atoms
+	return atoms
+}
+
+// bateman returns the atoms of the last nuclide of a linear decay chain per
+// initial atom of the first after t seconds.  lambdas are the (distinct)
+// decay constants of the chain's nuclides.
+func bateman(lambdas []float64, t float64) float64 {
+	n := len(lambdas)
+	sum := 0.0
+	for i, li := range lambdas {
+		// the product of the decay constants of all but the last nuclide
+		// over the differences with li is accumulated a factor at a time to
+		// avoid overflow
+		term := math.Exp(-li * t)
+		k := 0
+		for j, lj := range lambdas {
+			if j == i {
+				continue
+			}
+			term *= lambdas[k] / (lj - li)
+			k++
+		}
+		sum += term
+	}
+	if n > 1 && sum < 0 {
+		return 0
+	}
+	return sum
+}
@@ -0,0 +1,30 @@
+package nuc
+
+// Pathway is a route by which radionuclides are taken into the body.
+type Pathway int
+
+const (
+	Ingestion Pathway = iota
+	Inhalation
+)
+
+// DoseData provides the decay data and dose coefficients needed to compute
+// the radiotoxicity of materials.
+type DoseData interface {
+	DecayData
+	// DoseCoeff returns the committed effective dose in Sv per Bq of n taken
+	// in by pathway p or zero if it is unknown.
+	DoseCoeff(n Nuc, p Pathway) float64
+}
+
+// Radiotox returns the radiotoxicity in Sv of each radioactive nuclide in m
+// for pathway p.
+func Radiotox(m Material, d DoseData, p Pathway) map[Nuc]float64 {
+	tox := map[Nuc]float64{}
+	for n, a := range Activity(m, d) {
+		if v := a * d.DoseCoeff(n, p); v > 0 {
+			tox[n] = v
+		}
+	}
+	return tox
+}
@@ -0,0 +1,85 @@
+package nuc
+
+import (
+	"fmt"
+	"math"
+)
+
+// Uranium assays as U235 mass fractions.
+const (
+	// NaturalAssay is the assay of natural uranium.
+	NaturalAssay = 0.00711
+	// DefaultTailsAssay is a typical assay of enrichment plant tails.
+	DefaultTailsAssay = 0.003
+)
+
+// Assay returns the U235 fraction of the uranium mass in m or zero if m
+// contains no uranium.
+func Assay(m Material) float64 {
+	u := m.EltMass(92)
+	if u <= 0 {
+		return 0
+	}
+	return float64(m[U235] / u)
+}
+
+// V is the separation potential (value function) of uranium with assay x.
+func V(x float64) float64 {
+	return (1 - 2*x) * math.Log((1-x)/x)
+}
+
+// Enrichment holds the feed, tails and separative work of enriching uranium
+// into product.  Masses are uranium masses in kg and SWU is in kg-SWU.
+type Enrichment struct {
+	Product, Feed, Tails float64
+	// ProductAssay, FeedAssay and TailsAssay are U235 mass fractions.
+	ProductAssay, FeedAssay, TailsAssay float64
+	SWU                                 float64
+}
+
+// Enrich returns the enrichment of feed with assay xf into product kg of
+// uranium with assay xp leaving tails with assay xt.  Product with an assay
+// at or below the feed's (e.g. natural or depleted uranium) requires no
+// enrichment and just passes the feed through.
+func Enrich(product, xp, xf, xt float64) (Enrichment, error) {
+	e := Enrichment{Product: product, ProductAssay: xp, FeedAssay: xf, TailsAssay: xt}
+	if !(0 < xt && xt < xf && xf < 1) {
+		return e, fmt.Errorf("invalid feed assay %v and tails assay %v (must be 0 < tails < feed < 1)", xf, xt)
+	} else if xp >= 1 {
+		return e, fmt.Errorf("invalid product assay %v (must be < 1)", xp)
+	} else if xp <= xf {
+		e.Feed = product
+		return e, nil
+	}
+
+	e.Feed = product * (xp - xt) / (xf - xt)
+	e.Tails = e.Feed - product
+	e.SWU = product*V(xp) + e.Tails*V(xt) - e.Feed*V(xf)
+	return e, nil
+}
+
+// EnrichMat returns the enrichment of feed with assay xf leaving tails with
+// assay xt into the uranium of the product material m.
+func EnrichMat(m Material, xf, xt float64) (Enrichment, error) {
+	return Enrich(float64(m.EltMass(92)), Assay(m), xf, xt)
+}
+
+// Add returns the sum of e and other.  Assays of the sum are the mass
+// weighted averages of the assays of both.
+func (e Enrichment) Add(other Enrichment) Enrichment {
+	avg := func(x, mx, y, my float64) float64 {
+		if mx+my == 0 {
+			return 0
+		}
+		return (x*mx + y*my) / (mx + my)
+	}
+	return Enrichment{
+		Product:      e.Product + other.Product,
+		Feed:         e.Feed + other.Feed,
+		Tails:        e.Tails + other.Tails,
+		ProductAssay: avg(e.ProductAssay, e.Product, other.ProductAssay, other.Product),
+		FeedAssay:    avg(e.FeedAssay, e.Feed, other.FeedAssay, other.Feed),
+		TailsAssay:   avg(e.TailsAssay, e.Tails, other.TailsAssay, other.Tails),
+		SWU:          e.SWU + other.SWU,
+	}
+}
@@ -0,0 +1,144 @@
+package nuc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FissionQ holds the energy released per fission of a nuclide in Joules.
+type FissionQ struct {
+	// Fission is the recoverable energy of the fission itself: the kinetic
+	// energy of the fragments, prompt neutrons and gammas and the delayed
+	// betas and gammas of the fission products (neutrinos escape).
+	Fission float64
+	// Capture is the energy of the gammas released when the neutrons from
+	// the fission that don't cause another fission are captured.
+	Capture float64
+}
+
+// Recoverable returns the total recoverable energy per fission including
+// capture contributions.
+func (q FissionQ) Recoverable() float64 { return q.Fission + q.Capture }
+
+// FissionEnergy holds per nuclide fission energy data.
+type FissionEnergy map[Nuc]FissionQ
+
+// DefaultFissionEnergy holds approximate fission and capture energies of the
+// fissionable actinides in a thermal reactor.  They are accurate to a few MeV
+// - evaluated data for a specific reactor can be read with
+// LoadFissionEnergy.
+var DefaultFissionEnergy = FissionEnergy{
+	Th232:     {182.0 * MeV, 9.0 * MeV},
+	U233:      {191.0 * MeV, 8.0 * MeV},
+	U234:      {192.0 * MeV, 8.5 * MeV},
+	U235:      {193.7 * MeV, 8.8 * MeV},
+	922360000: {194.5 * MeV, 8.8 * MeV},
+	U238:      {197.7 * MeV, 8.8 * MeV},
+	932370000: {198.0 * MeV, 9.5 * MeV},
+	Pu238:     {197.5 * MeV, 9.5 * MeV},
+	Pu239:     {198.7 * MeV, 9.5 * MeV},
+	Pu240:     {199.5 * MeV, 9.5 * MeV},
+	Pu241:     {201.7 * MeV, 9.5 * MeV},
+	942420000: {202.5 * MeV, 9.5 * MeV},
+	952410000: {202.0 * MeV, 9.5 * MeV},
+	952430000: {203.0 * MeV, 9.5 * MeV},
+	Cm243:     {203.5 * MeV, 9.5 * MeV},
+	962440000: {204.0 * MeV, 9.5 * MeV},
+	Cm245:     {204.5 * MeV, 9.5 * MeV},
+	Cm247:     {205.0 * MeV, 9.5 * MeV},
+	Cf251:     {207.0 * MeV, 9.5 * MeV},
+}
+
+// LoadFissionEnergy reads fission energy data from r.  Each line holds a
+// nuclide (in any form accepted by Id), its fission energy and optionally
+// its capture energy in MeV separated by white space.  Blank lines and
+// everything after a '#' are ignored.
+func LoadFissionEnergy(r io.Reader) (FissionEnergy, error) {
+	fe := FissionEnergy{}
+	s := bufio.NewScanner(r)
+	for lineno := 1; s.Scan(); lineno++ {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		} else if len(fields) > 3 || len(fields) < 2 {
+			return nil, fmt.Errorf("fission energy line %v: want a nuclide and 1 or 2 energies, got %q", lineno, s.Text())
+		}
+
+		n, err := Id(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("fission energy line %v: %v", lineno, err)
+		}
+		var vals [2]float64
+		for i, field := range fields[1:] {
+			if vals[i], err = strconv.ParseFloat(field, 64); err != nil {
+				return nil, fmt.Errorf("fission energy line %v: invalid energy %q", lineno, field)
+			}
+		}
+		fe[n] = FissionQ{Fission: vals[0] * MeV, Capture: vals[1] * MeV}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return fe, nil
+}
+
+// EnergyModel selects the energy released per fission used to compute the
+// fission potential energy of materials.
+type EnergyModel int
+
+const (
+	// Recoverable uses the fission and capture energy of each nuclide.
+	Recoverable EnergyModel = iota
+	// FissionOnly uses the fission energy of each nuclide ignoring capture.
+	FissionOnly
+	// Flat uses 200 MeV for every nuclide in FissFertE.
+	Flat
+)
+
+var energyModels = []string{"recoverable", "fission", "flat"}
+
+func (em EnergyModel) String() string {
+	if int(em) < len(energyModels) {
+		return energyModels[em]
+	}
+	return fmt.Sprintf("EnergyModel(%d)", int(em))
+}
+
+// ParseEnergyModel returns the energy model named s (one of recoverable,
+// fission or flat).
+func ParseEnergyModel(s string) (EnergyModel, error) {
+	for i, name := range energyModels {
+		if s == name {
+			return EnergyModel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown energy model '%v' (must be one of %v)", s, strings.Join(energyModels, ", "))
+}
+
+// PerFission returns the energy in Joules released per fission of n under
+// model or zero if fe has no data for n.
+func (fe FissionEnergy) PerFission(n Nuc, model EnergyModel) float64 {
+	switch model {
+	case FissionOnly:
+		return fe[n].Fission
+	case Flat:
+		return FissFertE[n]
+	}
+	return fe[n].Recoverable()
+}
+
+// FPE returns the fission potential energy in Joules of m under model - the
+// energy released by fissioning all of its fissionable nuclides.
+func (fe FissionEnergy) FPE(m Material, model EnergyModel) (energy float64) {
+	for n, qty := range m {
+		energy += fe.PerFission(n, model) * Atoms(n, qty)
+	}
+	return energy
+}
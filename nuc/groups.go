@@ -0,0 +1,99 @@
+package nuc
+
+import (
+	"sort"
+	"strings"
+)
+
+// Long-lived fission products
+const (
+	Se79  = 340790000
+	Zr93  = 400930000
+	Tc99  = 430990000
+	Pd107 = 461070000
+	Sn126 = 501260000
+	I129  = 531290000
+	Cs135 = 551350000
+)
+
+// Group is a named set of nuclides made up of whole elements and individual
+// nuclides.
+type Group struct {
+	Name string
+	// Elements holds the atomic numbers of the group's elements.
+	Elements []int
+	Nucs     []Nuc
+}
+
+// Contains returns true if n is in g.  It can be passed to Material.Filter
+// to select a group's nuclides.
+func (g Group) Contains(n Nuc) bool {
+	for _, z := range g.Elements {
+		if n.Z() == z {
+			return true
+		}
+	}
+	for _, other := range g.Nucs {
+		if n == other {
+			return true
+		}
+	}
+	return false
+}
+
+// zrange returns the atomic numbers from z0 to z1 inclusive that have a
+// known element.
+func zrange(z0, z1 int) []int {
+	var zs []int
+	for z := z0; z <= z1; z++ {
+		if isElement(z) {
+			zs = append(zs, z)
+		}
+	}
+	return zs
+}
+
+// Predefined nuclide groups.  The actinide, lanthanide, minor actinide and
+// fission product groups follow pyne's definitions.
+var (
+	Uranium        = Group{Name: "U", Elements: []int{92}}
+	Plutonium      = Group{Name: "Pu", Elements: []int{94}}
+	Actinides      = Group{Name: "ACT", Elements: zrange(89, 103)}
+	TRU            = Group{Name: "TRU", Elements: zrange(93, len(elements)-1)}
+	MinorActinides = Group{Name: "MA", Elements: []int{93, 95, 96, 97, 98, 99, 100, 101, 102, 103}}
+	Lanthanides    = Group{Name: "LAN", Elements: zrange(57, 71)}
+	// FissionProducts holds every element lighter than the actinides.
+	FissionProducts = Group{Name: "FP", Elements: zrange(1, 88)}
+	NobleGases      = Group{Name: "NG", Elements: []int{2, 10, 18, 36, 54, 86}}
+	LLFP            = Group{Name: "LLFP", Nucs: []Nuc{Se79, Zr93, Tc99, Pd107, Sn126, I129, Cs135}}
+)
+
+var groups = map[string]Group{}
+
+func init() {
+	for _, g := range []Group{Uranium, Plutonium, Actinides, TRU, MinorActinides,
+		Lanthanides, FissionProducts, NobleGases, LLFP} {
+		AddGroup(g)
+	}
+}
+
+// AddGroup registers g so it can be found by name with LookupGroup,
+// replacing any group with the same (case insensitive) name.
+func AddGroup(g Group) { groups[strings.ToUpper(g.Name)] = g }
+
+// LookupGroup returns the registered group with the given case insensitive
+// name.
+func LookupGroup(name string) (Group, bool) {
+	g, ok := groups[strings.ToUpper(name)]
+	return g, ok
+}
+
+// GroupNames returns the names of the registered groups in sorted order.
+func GroupNames() []string {
+	var names []string
+	for _, g := range groups {
+		names = append(names, g.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,128 @@
+package nuc
+
+import "math"
+
+// Add returns a new material holding the sum of m and other.
+func (m Material) Add(other Material) Material {
+	sum := make(Material, len(m))
+	for n, qty := range m {
+		sum[n] = qty
+	}
+	for n, qty := range other {
+		sum[n] += qty
+	}
+	return sum
+}
+
+// Sub returns a new material holding m minus other.  Nuclides with more mass
+// in other than in m have negative quantities in the result.
+func (m Material) Sub(other Material) Material {
+	return m.Add(other.Scale(-1))
+}
+
+// Scale returns a copy of m with the quantity of each nuclide multiplied by
+// f.
+func (m Material) Scale(f float64) Material {
+	scaled := make(Material, len(m))
+	for n, qty := range m {
+		scaled[n] = qty * Mass(f)
+	}
+	return scaled
+}
+
+// Normalize returns a copy of m scaled to a total mass of one.  A material
+// without mass normalizes to an empty material.
+func (m Material) Normalize() Material {
+	tot := m.Mass()
+	if tot == 0 {
+		return Material{}
+	}
+	return m.Scale(1 / float64(tot))
+}
+
+// MassFractions returns the fraction of m's mass made up by each nuclide.
+func (m Material) MassFractions() map[Nuc]float64 {
+	fracs := map[Nuc]float64{}
+	for n, qty := range m.Normalize() {
+		fracs[n] = float64(qty)
+	}
+	return fracs
+}
+
+// AtomFractions returns the fraction of m's atoms made up by each nuclide.
+func (m Material) AtomFractions() map[Nuc]float64 {
+	fracs := map[Nuc]float64{}
+	tot := 0.0
+	for n, qty := range m {
+		fracs[n] = Atoms(n, qty)
+		tot += fracs[n]
+	}
+	if tot == 0 {
+		return map[Nuc]float64{}
+	}
+	for n := range fracs {
+		fracs[n] /= tot
+	}
+	return fracs
+}
+
+// FromAtomFractions returns a material with the given total mass and the
+// nuclide atom fractions of fracs.  The fractions need not be normalized.
+func FromAtomFractions(fracs map[Nuc]float64, mass Mass) Material {
+	m := Material{}
+	for n, frac := range fracs {
+		// mass in grams of frac moles of n
+		m[n] = Mass(frac * float64(n.A()))
+	}
+	return m.Normalize().Scale(float64(mass))
+}
+
+// Filter returns the nuclides of m for which keep returns true.
+func (m Material) Filter(keep func(Nuc) bool) Material {
+	kept := Material{}
+	for n, qty := range m {
+		if keep(n) {
+			kept[n] = qty
+		}
+	}
+	return kept
+}
+
+// Elements returns a Filter predicate matching the nuclides of the elements
+// with the given Z numbers.
+func Elements(zs ...int) func(Nuc) bool {
+	return func(n Nuc) bool {
+		for _, z := range zs {
+			if n.Z() == z {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Nucs returns a Filter predicate matching the given nuclides.
+func Nucs(nucs ...Nuc) func(Nuc) bool {
+	return func(n Nuc) bool {
+		for _, other := range nucs {
+			if n == other {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Diff returns m minus other for each nuclide whose quantities in m and other
+// differ by more than abs + rel*max(|m[n]|, |other[n]|).  An empty result
+// means the materials are equal within the tolerances.
+func (m Material) Diff(other Material, abs, rel float64) Material {
+	diff := Material{}
+	for n, d := range m.Sub(other) {
+		tol := abs + rel*math.Max(math.Abs(float64(m[n])), math.Abs(float64(other[n])))
+		if math.Abs(float64(d)) > tol {
+			diff[n] = d
+		}
+	}
+	return diff
+}
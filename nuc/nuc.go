@@ -1,48 +1,18 @@
 package nuc
 
-/*
-#include <stdlib.h>
-#include "cnucname.h"
-*/
-import "C"
 import (
 	"bytes"
 	"fmt"
-	"unsafe"
+	"sort"
 )
 
 var NucDataPath = ""
 
 type Nuc int
 
-func Id(nuc string) (Nuc, error) {
-	cs := C.CString(nuc)
-	defer C.free(unsafe.Pointer(cs))
-	n := Nuc(C.id_str(cs))
-	if n < 0 {
-		return 0, fmt.Errorf("'%v' is not a valid nuclide", nuc)
-	}
-	return n, nil
-}
-
-func IdFromInt(nuc int) (Nuc, error) {
-	n := Nuc(C.id_int(C.int(nuc)))
-	if n < 0 {
-		return 0, fmt.Errorf("'%v' is not a valid nuclide", nuc)
-	}
-	return n, nil
-}
-
 func (n Nuc) Z() int { return int(n) / 10000000 }
 func (n Nuc) A() int { return (int(n) / 10000) % 1000 }
 
-func (n Nuc) Name() string {
-	cname := C.name(C.int(n))
-	name := C.GoString(cname)
-	C.free(unsafe.Pointer(cname))
-	return name
-}
-
 const (
 	Kg = 1
 	g  = 1e-3 * Kg
@@ -52,6 +22,13 @@ const (
 	Joule = 1
 	MeV   = 1.602177e-13 * Joule
 	MWh   = 3.6e9
+	MWd   = 24 * MWh
+	GWd   = 1000 * MWd
+)
+
+const (
+	Second = 1
+	Year   = 365.25 * 24 * 3600 * Second
 )
 
 const (
@@ -70,11 +47,18 @@ func Atoms(n Nuc, m Mass) float64 {
 
 type Material map[Nuc]Mass
 
+// String returns m's mass and composition listed in order of nuclide id.
 func (m Material) String() string {
+	nucs := make([]int, 0, len(m))
+	for nuc := range m {
+		nucs = append(nucs, int(nuc))
+	}
+	sort.Ints(nucs)
+
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "# Material mass=%v. Composition:\n", m.Mass())
-	for nuc, qty := range m {
-		fmt.Fprintf(&buf, "    %v    %v\n", nuc, qty)
+	for _, nuc := range nucs {
+		fmt.Fprintf(&buf, "    %v    %v\n", nuc, m[Nuc(nuc)])
 	}
 	return buf.String()
 }
@@ -103,10 +87,8 @@ func (m Material) EltMass(anum int) (tot Mass) {
 }
 
 // FPE returns the amount of fission potential energy in Joules for the
-// material described by m.
-func FPE(m Material) (energy float64) {
-	for nuc, e := range FissFertE {
-		energy += e * Atoms(nuc, m[nuc])
-	}
-	return energy
+// material described by m using the recoverable energy per fission of
+// DefaultFissionEnergy.
+func FPE(m Material) float64 {
+	return DefaultFissionEnergy.FPE(m, Recoverable)
 }
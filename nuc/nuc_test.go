@@ -3,6 +3,7 @@ package nuc
 import (
 	"fmt"
 	"math"
+	"strings"
 	"testing"
 )
 
@@ -27,10 +28,17 @@ func TestFPE(t *testing.T) {
 		922350000: 1,
 	}
 
-	fpe := FPE(m)
+	fpe := DefaultFissionEnergy.FPE(m, Flat)
 	nmols := 1000.0 / 235.0
 	expected := nmols * Mol * 200 * MeV
 
+	if math.Abs(fpe-expected) > 1e-6 {
+		t.Errorf("flat fpe: expected %v J, got %v J", expected, fpe)
+	}
+
+	fpe = FPE(m)
+	expected = nmols * Mol * (193.7 + 8.8) * MeV
+
 	if math.Abs(fpe-expected) > 1e-6 {
 		t.Errorf("fpe: expected %v J, got %v J", expected, fpe)
 	}
@@ -78,3 +86,355 @@ func TestFPE(t *testing.T) {
 	fmt.Printf("fpe spent u fuel: %v\n", fpe2)
 	fmt.Printf("fpe fresh mox fuel: %v\n", fpe3)
 }
+
+func TestEnrich(t *testing.T) {
+	e, err := Enrich(1, 0.045, NaturalAssay, DefaultTailsAssay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		Name      string
+		Got, Want float64
+	}{
+		{"feed", e.Feed, 10.218978},
+		{"tails", e.Tails, 9.218978},
+		{"swu", e.SWU, 6.230585},
+	}
+	for _, test := range tests {
+		if math.Abs(test.Got-test.Want) > 1e-6 {
+			t.Errorf("%v: want %v, got %v", test.Name, test.Want, test.Got)
+		}
+	}
+
+	m := Material{U235: 4.5, U238: 95.5, 80160000: 13.5}
+	em, err := EnrichMat(m, NaturalAssay, DefaultTailsAssay)
+	if err != nil {
+		t.Fatal(err)
+	} else if math.Abs(em.SWU-100*e.SWU) > 1e-6 || em.ProductAssay != Assay(m) {
+		t.Errorf("material: want %v kg-SWU at assay 0.045, got %+v", 100*e.SWU, em)
+	}
+
+	if e, err := Enrich(1, NaturalAssay, NaturalAssay, DefaultTailsAssay); err != nil || e.SWU != 0 || e.Feed != 1 {
+		t.Errorf("natural product: want no enrichment, got %+v (err=%v)", e, err)
+	}
+	if _, err := Enrich(1, 0.045, 0.002, DefaultTailsAssay); err == nil {
+		t.Errorf("tails assay above feed assay: want error, got nil")
+	}
+}
+
+// testDecay is decay data for Cs137 with everything else stable.
+type testDecay struct{}
+
+func (testDecay) DecayConst(n Nuc) float64 {
+	if n == 551370000 {
+		return 7.302030826339803e-10
+	}
+	return 0
+}
+
+func (testDecay) DecayEnergy(n Nuc) float64 { return 0.5 * MeV }
+
+func (testDecay) Branches(n Nuc) map[Nuc]float64 {
+	if n == 551370000 {
+		return map[Nuc]float64{561370000: 1}
+	}
+	return nil
+}
+
+func TestActivity(t *testing.T) {
+	m := Material{551370000: 1, U238: 10}
+
+	act := Activity(m, testDecay{})
+	want := 7.302030826339803e-10 * 1000 / 137 * Mol
+	if len(act) != 1 || math.Abs(act[551370000]-want) > 1e-9*want {
+		t.Errorf("activity: want Cs137 at %v Bq, got %v", want, act)
+	}
+
+	heat := DecayHeat(m, testDecay{})
+	if got := Total(heat); math.Abs(got-want*0.5*MeV) > 1e-9*got {
+		t.Errorf("decay heat: want %v W, got %v W", want*0.5*MeV, got)
+	}
+}
+
+func (testDecay) DoseCoeff(n Nuc, p Pathway) float64 {
+	if p == Inhalation {
+		return 4.6e-9
+	}
+	return 1.3e-8
+}
+
+func TestRadiotox(t *testing.T) {
+	m := Material{551370000: 1, U238: 10}
+	act := Total(Activity(m, testDecay{}))
+
+	if got, want := Total(Radiotox(m, testDecay{}, Ingestion)), act*1.3e-8; math.Abs(got-want) > 1e-9*want {
+		t.Errorf("ingestion: want %v Sv, got %v Sv", want, got)
+	}
+
+	halflife := math.Ln2 / 7.302030826339803e-10
+	decayed := Decay(m, testDecay{}, 2*halflife)
+	if got, want := Total(Radiotox(decayed, testDecay{}, Inhalation)), act*4.6e-9/4; math.Abs(got-want) > 1e-9*want {
+		t.Errorf("inhalation after 2 half lives: want %v Sv, got %v Sv", want, got)
+	} else if decayed[U238] != 10 {
+		t.Errorf("stable U238: want 10 kg, got %v kg", decayed[U238])
+	} else if math.Abs(float64(decayed[561370000])-0.75) > 1e-9 {
+		t.Errorf("Ba137 ingrowth: want 0.75 kg, got %v kg", decayed[561370000])
+	}
+}
+
+// chainDecay is decay data with the chain Pu241 -> Am241 -> Np237, U238 with
+// the same decay constant as Pu241 decaying to Th234 and Cm244 branching to
+// stable Pu240 and Cm240.
+type chainDecay struct{}
+
+func (chainDecay) DecayConst(n Nuc) float64 {
+	switch n {
+	case Pu241, U238:
+		return 2e-9
+	case 952410000:
+		return 5e-10
+	case 962440000:
+		return 1e-9
+	}
+	return 0
+}
+
+func (chainDecay) DecayEnergy(n Nuc) float64 { return 0 }
+
+func (chainDecay) Branches(n Nuc) map[Nuc]float64 {
+	switch n {
+	case Pu241:
+		return map[Nuc]float64{952410000: 1}
+	case 952410000:
+		return map[Nuc]float64{932370000: 1}
+	case U238:
+		return map[Nuc]float64{Pu241: 1}
+	case 962440000:
+		return map[Nuc]float64{Pu240: 0.25, 962400000: 0.75}
+	}
+	return nil
+}
+
+func TestDecay(t *testing.T) {
+	const l1, l2, dt = 2e-9, 5e-10, 1e9
+	e1, e2 := math.Exp(-l1*dt), math.Exp(-l2*dt)
+
+	got := Decay(Material{Pu241: 241}, chainDecay{}, dt)
+	want := Material{
+		Pu241:     241 * Mass(e1),
+		952410000: 241 * Mass(l1/(l2-l1)*(e1-e2)),
+		932370000: 237 * Mass(1-(l2*e1-l1*e2)/(l2-l1)),
+	}
+	for n, w := range want {
+		if math.Abs(float64(got[n]-w)) > 1e-9*float64(w) {
+			t.Errorf("Pu241 chain %v: want %v kg, got %v kg", n, w, got[n])
+		}
+	}
+
+	// U238 decays into Pu241 (in this data) with the same decay constant
+	got = Decay(Material{U238: 238}, chainDecay{}, dt)
+	if w := 241 * l1 * dt * e1; math.Abs(float64(got[Pu241])-w) > 1e-4*w {
+		t.Errorf("equal decay constants: want %v kg Pu241, got %v kg", w, got[Pu241])
+	}
+
+	got = Decay(Material{962440000: 244}, chainDecay{}, 1e12)
+	if math.Abs(float64(got[Pu240])-60) > 1e-9 || math.Abs(float64(got[962400000])-180) > 1e-9 {
+		t.Errorf("branching: want 60 kg Pu240 and 180 kg Cm240, got %v", got)
+	}
+}
+
+func TestMaterialArith(t *testing.T) {
+	m1 := Material{922350000: 1, 922380000: 3}
+	m2 := Material{922380000: 1, 942390000: 2}
+
+	tests := []struct {
+		name      string
+		got, want Material
+	}{
+		{"add", m1.Add(m2), Material{922350000: 1, 922380000: 4, 942390000: 2}},
+		{"sub", m1.Sub(m2), Material{922350000: 1, 922380000: 2, 942390000: -2}},
+		{"scale", m1.Scale(2), Material{922350000: 2, 922380000: 6}},
+		{"normalize", m1.Normalize(), Material{922350000: .25, 922380000: .75}},
+		{"normalize empty", Material{}.Normalize(), Material{}},
+	}
+
+	for _, test := range tests {
+		if d := test.got.Diff(test.want, 1e-12, 0); len(d) > 0 || len(test.got) != len(test.want) {
+			t.Errorf("%v: want %v, got %v", test.name, test.want, test.got)
+		}
+	}
+
+	if m1[922380000] != 3 || len(m1) != 2 {
+		t.Errorf("operations modified their receiver: %v", m1)
+	}
+}
+
+func TestFractions(t *testing.T) {
+	m := Material{10010000: 2, 80160000: 16}
+	mf := m.MassFractions()
+	if math.Abs(mf[10010000]-1.0/9) > 1e-12 || math.Abs(mf[80160000]-8.0/9) > 1e-12 {
+		t.Errorf("mass fractions: want 1/9 and 8/9, got %v", mf)
+	}
+
+	af := m.AtomFractions()
+	if math.Abs(af[10010000]-2.0/3) > 1e-12 || math.Abs(af[80160000]-1.0/3) > 1e-12 {
+		t.Errorf("atom fractions: want 2/3 and 1/3, got %v", af)
+	}
+
+	got := FromAtomFractions(map[Nuc]float64{10010000: 2, 80160000: 1}, 18)
+	if d := got.Diff(m, 1e-12, 0); len(d) > 0 {
+		t.Errorf("from atom fractions: want %v, got %v", m, got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	m := Material{922350000: 1, 922380000: 2, 942390000: 3, 551370000: 4}
+
+	got := m.Filter(Elements(92, 94))
+	want := Material{922350000: 1, 922380000: 2, 942390000: 3}
+	if len(got.Diff(want, 0, 0)) > 0 || len(got) != len(want) {
+		t.Errorf("elements: want %v, got %v", want, got)
+	}
+
+	got = m.Filter(Nucs(551370000, 922350000))
+	want = Material{922350000: 1, 551370000: 4}
+	if len(got.Diff(want, 0, 0)) > 0 || len(got) != len(want) {
+		t.Errorf("nucs: want %v, got %v", want, got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	m1 := Material{922350000: 1, 922380000: 100}
+	m2 := Material{922350000: 1.001, 922380000: 100.5, 942390000: 0.01}
+
+	tests := []struct {
+		abs, rel float64
+		want     []Nuc
+	}{
+		{0, 0, []Nuc{922350000, 922380000, 942390000}},
+		{0.002, 0, []Nuc{922380000, 942390000}},
+		{0.02, 0, []Nuc{922380000}},
+		{0, 0.01, []Nuc{942390000}},
+		{0.02, 0.01, nil},
+	}
+
+	for _, test := range tests {
+		d := m1.Diff(m2, test.abs, test.rel)
+		if len(d) != len(test.want) {
+			t.Errorf("abs=%v, rel=%v: want diffs in %v, got %v", test.abs, test.rel, test.want, d)
+			continue
+		}
+		for _, n := range test.want {
+			if want := m1[n] - m2[n]; d[n] != want {
+				t.Errorf("abs=%v, rel=%v: want %v diff %v, got %v", test.abs, test.rel, n, want, d[n])
+			}
+		}
+	}
+}
+
+func TestMaterialString(t *testing.T) {
+	m := Material{942390000: 2, 922350000: 1, 922380000: 3}
+	want := "# Material mass=6. Composition:\n" +
+		"    922350000    1\n" +
+		"    922380000    3\n" +
+		"    942390000    2\n"
+	for i := 0; i < 10; i++ {
+		if got := m.String(); got != want {
+			t.Fatalf("want:\n%v\ngot:\n%v", want, got)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	m := Material{
+		922350000: 1,
+		942390000: 2,
+		932370000: 3,
+		952410000: 4,
+		551370000: 5,
+		I129:      6,
+		541350000: 7,
+		601440000: 8,
+	}
+
+	tests := []struct {
+		group string
+		want  Mass
+	}{
+		{"U", 1},
+		{"pu", 2},
+		{"TRU", 2 + 3 + 4},
+		{"MA", 3 + 4},
+		{"ACT", 1 + 2 + 3 + 4},
+		{"LAN", 8},
+		{"FP", 5 + 6 + 7 + 8},
+		{"NG", 7},
+		{"LLFP", 6},
+	}
+
+	for _, test := range tests {
+		g, ok := LookupGroup(test.group)
+		if !ok {
+			t.Errorf("group %v not found", test.group)
+			continue
+		}
+		if got := m.Filter(g.Contains).Mass(); got != test.want {
+			t.Errorf("group %v: want mass %v, got %v", test.group, test.want, got)
+		}
+	}
+
+	AddGroup(Group{Name: "fissile", Nucs: FissNuc})
+	defer delete(groups, "FISSILE")
+	g, ok := LookupGroup("Fissile")
+	if !ok {
+		t.Fatal("added group not found")
+	} else if got := m.Filter(g.Contains).Mass(); got != 1+2 {
+		t.Errorf("added group: want mass 3, got %v", got)
+	}
+}
+
+func TestFissionEnergy(t *testing.T) {
+	data := `
+# nuclide  fission  capture (MeV)
+U235   190    10
+Pu239  200  # no capture data
+`
+	fe, err := LoadFissionEnergy(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		n     Nuc
+		model EnergyModel
+		want  float64
+	}{
+		{U235, Recoverable, 200 * MeV},
+		{U235, FissionOnly, 190 * MeV},
+		{U235, Flat, 200 * MeV},
+		{Pu239, Recoverable, 200 * MeV},
+		{U238, Recoverable, 0},
+		{U238, Flat, 200 * MeV},
+	}
+	for _, test := range tests {
+		if got := fe.PerFission(test.n, test.model); math.Abs(got-test.want) > 1e-20 {
+			t.Errorf("%v energy of %v: want %v J, got %v J", test.model, test.n.Name(), test.want, got)
+		}
+	}
+
+	bad := []string{"U235", "U235 1 2 3", "Xx235 200", "U235 two"}
+	for _, data := range bad {
+		if _, err := LoadFissionEnergy(strings.NewReader(data)); err == nil {
+			t.Errorf("no error loading %q", data)
+		}
+	}
+
+	for _, name := range []string{"recoverable", "fission", "flat"} {
+		if model, err := ParseEnergyModel(name); err != nil || model.String() != name {
+			t.Errorf("ParseEnergyModel(%v): got %v, %v", name, model, err)
+		}
+	}
+	if _, err := ParseEnergyModel("bogus"); err == nil {
+		t.Error("no error parsing a bogus energy model")
+	}
+}
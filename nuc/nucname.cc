@@ -1,3 +1,6 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
 // This contents of this file were extracted from the pyne project - license
 // below:
 /*
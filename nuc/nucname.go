@@ -0,0 +1,346 @@
+package nuc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file is a pure Go port of pyne's nucname conversions (see nucname.cc).
+// Nuclide ids are of the form ZZZAAASSSS where SSSS is the metastable state.
+
+const (
+	digits   = "0123456789"
+	alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// elements holds the chemical symbol of each element indexed by Z.
+var elements = [...]string{"",
+	"H", "He", "Li", "Be", "B", "C", "N", "O", "F", "Ne",
+	"Na", "Mg", "Al", "Si", "P", "S", "Cl", "Ar", "K", "Ca",
+	"Sc", "Ti", "V", "Cr", "Mn", "Fe", "Co", "Ni", "Cu", "Zn",
+	"Ga", "Ge", "As", "Se", "Br", "Kr", "Rb", "Sr", "Y", "Zr",
+	"Nb", "Mo", "Tc", "Ru", "Rh", "Pd", "Ag", "Cd", "In", "Sn",
+	"Sb", "Te", "I", "Xe", "Cs", "Ba", "La", "Ce", "Pr", "Nd",
+	"Pm", "Sm", "Eu", "Gd", "Tb", "Dy", "Ho", "Er", "Tm", "Yb",
+	"Lu", "Hf", "Ta", "W", "Re", "Os", "Ir", "Pt", "Au", "Hg",
+	"Tl", "Pb", "Bi", "Po", "At", "Rn", "Fr", "Ra", "Ac", "Th",
+	"Pa", "U", "Np", "Pu", "Am", "Cm", "Bk", "Cf", "Es", "Fm",
+	"Md", "No", "Lr", "Rf", "Db", "Sg", "Bh", "Hs", "Mt", "Ds",
+	"Rg", "Cn", "", "Fl", "", "Lv",
+}
+
+// zs maps chemical symbols to Z.
+var zs = map[string]int{}
+
+func init() {
+	for z, sym := range elements {
+		if sym != "" {
+			zs[sym] = z
+		}
+	}
+}
+
+func isElement(z int) bool { return 0 < z && z < len(elements) && elements[z] != "" }
+
+// valid returns true if id is a nuclide or natural element in id form.
+func valid(id int) bool {
+	z, a := id/10000000, id/10000%1000
+	return isElement(z) && (id%10000000 == 0 || z <= a && a <= 7*z)
+}
+
+func notNuclide(nuc interface{}) error {
+	return fmt.Errorf("'%v' is not a valid nuclide", nuc)
+}
+
+// parseIdInt converts nuc in id, zzaaam, cinder (aaazzzm), MCNP or Z form to
+// an id the way pyne's nucname::id(int) does.
+func parseIdInt(nuc int) (int, bool) {
+	if nuc < 0 {
+		return 0, false
+	}
+
+	zzz := nuc / 10000000
+	aaassss := nuc % 10000000
+	aaa := aaassss / 10000
+	if 0 < zzz && zzz <= aaa && aaa <= zzz*7 {
+		return nuc, true
+	} else if aaassss == 0 && isElement(zzz) {
+		return nuc, true
+	} else if nuc < 1000 && isElement(nuc) {
+		return nuc * 10000000, true
+	}
+
+	// zzaaam or cinder form
+	zzz = nuc / 10000
+	aaa = nuc % 10000 / 10
+	if zzz <= aaa && aaa <= zzz*7 {
+		return zzz*10000000 + aaa*10000 + nuc%10, true
+	} else if aaa <= zzz && zzz <= aaa*7 && isElement(aaa) {
+		return aaa*10000000 + zzz*10000 + nuc%10, true
+	} else if nuc%10000 == 0 && isElement(zzz) {
+		return zzz * 10000000, true
+	}
+
+	if nuc >= 1000000 {
+		return 0, false
+	} else if id, ok := mcnpToId(nuc); ok {
+		return id, true
+	} else if isElement(nuc) {
+		return nuc * 10000000, true
+	}
+	return 0, false
+}
+
+// mcnpToId converts nuc in MCNP (zzaaa) form to an id.  Metastable states are
+// encoded by adding 300+100*state to aaa except that 95242 is Am242m and
+// 95642 is the Am242 ground state.
+func mcnpToId(nuc int) (int, bool) {
+	zzz := nuc / 1000
+	aaa := nuc % 1000
+	if zzz > aaa {
+		if aaa == 0 && isElement(zzz) {
+			return zzz * 10000000, true
+		}
+		return 0, false
+	} else if aaa < 400 {
+		if nuc == 95242 {
+			return nuc*10000 + 1, true
+		}
+		return nuc * 10000, true
+	} else if nuc == 95642 {
+		return 95242 * 10000, true
+	}
+
+	// peel off the 100s of aaa until A is physically reasonable
+	id := (nuc-400)*10000 + 1
+	for 3.0 < float32((id/10000)%1000)/float32(id/10000000) {
+		id -= 999999
+	}
+	return id, id > 0
+}
+
+// parseId converts nuc in name (e.g. U235, U235m, U), ZZ-LL-AAAM, NIST (e.g.
+// 235U) or any of the integer forms of parseIdInt to an id the way pyne's
+// nucname::id(std::string) does - except that the ZZ-LL-nat form of natural
+// elements, which pyne rejects, is accepted.
+func parseId(nuc string) (int, bool) {
+	if dash1 := strings.Index(nuc, "-"); len(nuc) >= 5 && dash1 >= 0 {
+		if dash2 := strings.Index(nuc[dash1+1:], "-"); dash2 >= 0 {
+			// ZZ-LL-AAAM form - ZZ and LL must agree
+			ll, ok := parseId(nuc[dash1+1 : dash1+1+dash2])
+			if !ok || ll/10000000 != atoi(nuc[:dash1]) {
+				return 0, false
+			}
+			return symbolToId(strings.ToUpper(nuc)[2:], true)
+		}
+	}
+
+	s := strings.Replace(strings.ToUpper(nuc), "-", "", -1)
+	if s == "" {
+		return 0, false
+	}
+	switch first := s[0]; {
+	case isDigit(first) && isDigit(s[len(s)-1]):
+		return parseIdInt(atoi(s))
+	case isDigit(first):
+		return nistToId(s)
+	case 'A' <= first && first <= 'Z':
+		return symbolToId(s, false)
+	}
+	return 0, false
+}
+
+// symbolToId converts the upper case name form nuc (e.g. U235 or U235M) with
+// any dashes to an id.  If nat is true, an A of NAT (e.g. UNAT) denotes the
+// natural element.
+func symbolToId(nuc string, nat bool) (int, bool) {
+	nuc = strings.Replace(nuc, "-", "", -1)
+	if nuc == "" {
+		return 0, false
+	}
+
+	anum := removeChars(nuc, alphabet)
+	if anum == "" || nat && strings.Contains(nuc, "NAT") {
+		sym := nuc
+		if nat {
+			sym = strings.Replace(sym, "NAT", "", -1)
+		}
+		if z, ok := zs[capitalize(sym)]; ok {
+			return z * 10000000, true
+		}
+	}
+
+	id := atoi(anum) * 10000
+	if last := nuc[len(nuc)-1]; last == 'M' {
+		id++
+	} else if !isDigit(last) {
+		return 0, false
+	}
+
+	z, ok := zs[capitalize(removeChars(nuc[:len(nuc)-1], digits))]
+	if !ok {
+		return 0, false
+	}
+	return z*10000000 + id, true
+}
+
+// nistToId converts the upper case NIST form nuc (e.g. 235U) to an id.
+func nistToId(nuc string) (int, bool) {
+	z, ok := zs[capitalize(removeChars(nuc, digits))]
+	if !ok {
+		return 0, false
+	}
+	return z*10000000 + atoi(removeChars(nuc, alphabet))*10000, true
+}
+
+// parts splits n into its Z, A and metastable state returning false if n's Z
+// is not a known element.
+func (n Nuc) parts() (z, a, s int, ok bool) {
+	id := int(n)
+	return id / 10000000, id / 10000 % 1000, id % 10000, isElement(id / 10000000)
+}
+
+// nucName returns the name form of n (e.g. U235, Am242M or U).
+func nucName(n Nuc) string {
+	z, a, s, ok := n.parts()
+	if !ok {
+		return strconv.Itoa(int(n))
+	}
+	name := elements[z]
+	if a > 0 {
+		name += strconv.Itoa(a)
+	}
+	if s > 0 {
+		name += "M"
+	}
+	return name
+}
+
+// Zzaaam returns n in zzaaam form (e.g. 922351 for U235m).  Metastable states
+// above 9 are truncated to 9.
+func (n Nuc) Zzaaam() int {
+	s := int(n) % 10000
+	if s > 9 {
+		s = 9
+	}
+	return int(n)/10000*10 + s
+}
+
+// IdFromZzaaam returns the nuclide for nuc in zzaaam form.
+func IdFromZzaaam(nuc int) (Nuc, error) {
+	id := nuc/10*10000 + nuc%10
+	if nuc < 0 || !valid(id) {
+		return 0, notNuclide(nuc)
+	}
+	return Nuc(id), nil
+}
+
+// MCNP returns n in MCNP (zzaaa) form.  Metastable states are encoded by
+// adding 300+100*state to aaa with Am242m and Am242 swapped (i.e. 95242 is
+// Am242m and 95642 is Am242).
+func (n Nuc) MCNP() int {
+	mcnp := int(n) / 10000
+	s := int(n) % 10000
+	if mcnp == 95242 && s < 2 {
+		s = (s + 1) % 2
+	}
+	if 0 < s && s < 10 {
+		mcnp += 300 + s*100
+	}
+	return mcnp
+}
+
+// IdFromMCNP returns the nuclide for nuc in MCNP (zzaaa) form.
+func IdFromMCNP(nuc int) (Nuc, error) {
+	id, ok := mcnpToId(nuc)
+	if nuc < 1000 || !ok || !valid(id) {
+		return 0, notNuclide(nuc)
+	}
+	return Nuc(id), nil
+}
+
+// Serpent returns n in Serpent form (e.g. U-235, Am-242m or U-nat).
+func (n Nuc) Serpent() string {
+	z, a, s, ok := n.parts()
+	if !ok {
+		return strconv.Itoa(int(n))
+	}
+	name := elements[z] + "-"
+	if int(n)%10000000 == 0 {
+		name += "nat"
+	} else {
+		name += strconv.Itoa(a)
+	}
+	if s > 0 {
+		name += "m"
+	}
+	return name
+}
+
+// IdFromSerpent returns the nuclide for nuc in Serpent form.
+func IdFromSerpent(nuc string) (Nuc, error) {
+	id, ok := symbolToId(strings.ToUpper(nuc), true)
+	if !ok {
+		return 0, notNuclide(nuc)
+	}
+	return Nuc(id), nil
+}
+
+// NIST returns n in NIST form (e.g. 235U or U).  The NIST form has no
+// metastable states, so they are dropped.
+func (n Nuc) NIST() string {
+	z, a, _, ok := n.parts()
+	if !ok {
+		return strconv.Itoa(int(n))
+	} else if int(n)%10000000 == 0 {
+		return elements[z]
+	}
+	return strconv.Itoa(a) + elements[z]
+}
+
+// IdFromNIST returns the nuclide for nuc in NIST form.
+func IdFromNIST(nuc string) (Nuc, error) {
+	id, ok := nistToId(strings.ToUpper(nuc))
+	if !ok {
+		return 0, notNuclide(nuc)
+	}
+	return Nuc(id), nil
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// atoi converts the leading integer of s like C's atoi - returning zero if
+// there is none.
+func atoi(s string) int {
+	s = strings.TrimLeft(s, " \t\n\v\f\r")
+	neg := false
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	v := 0
+	for i := 0; i < len(s) && isDigit(s[i]); i++ {
+		v = v*10 + int(s[i]-'0')
+	}
+	if neg {
+		return -v
+	}
+	return v
+}
+
+func removeChars(s, chars string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(chars, r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + strings.ToLower(s[1:])
+}
@@ -0,0 +1,40 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package nuc
+
+/*
+#include <stdlib.h>
+#include "cnucname.h"
+*/
+import "C"
+import "unsafe"
+
+// Id returns the nuclide for nuc in name (e.g. U235, U235m), ZZ-LL-AAAM,
+// NIST or any of the integer forms accepted by IdFromInt.
+func Id(nuc string) (Nuc, error) {
+	cs := C.CString(nuc)
+	defer C.free(unsafe.Pointer(cs))
+	n := Nuc(C.id_str(cs))
+	if n < 0 {
+		return 0, notNuclide(nuc)
+	}
+	return n, nil
+}
+
+// IdFromInt returns the nuclide for nuc in id, zzaaam, MCNP or Z form.
+func IdFromInt(nuc int) (Nuc, error) {
+	n := Nuc(C.id_int(C.int(nuc)))
+	if n < 0 {
+		return 0, notNuclide(nuc)
+	}
+	return n, nil
+}
+
+// Name returns n in name form (e.g. U235, Am242M or U).
+func (n Nuc) Name() string {
+	cname := C.name(C.int(n))
+	name := C.GoString(cname)
+	C.free(unsafe.Pointer(cname))
+	return name
+}
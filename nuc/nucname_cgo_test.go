@@ -0,0 +1,52 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package nuc
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestPureGoNames checks the pure Go nuclide naming against pyne's over
+// every element, A and the first few metastable states.
+func TestPureGoNames(t *testing.T) {
+	for z := 1; z < len(elements); z++ {
+		if !isElement(z) {
+			continue
+		}
+		for a := 0; a <= 7*z && a < 300; a++ {
+			for s := 0; s < 3; s++ {
+				if a == 0 && s > 0 || a > 0 && a < z {
+					continue
+				}
+				n := Nuc(z*10000000 + a*10000 + s)
+				if want, got := n.Name(), nucName(n); got != want {
+					t.Errorf("name of %v: want %v, got %v", int(n), want, got)
+				}
+
+				ints := []int{int(n), n.Zzaaam()}
+				for _, v := range ints {
+					want, werr := IdFromInt(v)
+					got, ok := parseIdInt(v)
+					if werr == nil != ok || Nuc(got) != want {
+						t.Errorf("id of %v: want %v (%v), got %v (%v)", v, want, werr, got, ok)
+					}
+				}
+
+				strs := []string{n.Name(), strings.ToLower(n.Name()), n.Serpent(), n.NIST()}
+				if a > 0 {
+					strs = append(strs, strconv.Itoa(z)+"-"+n.Serpent())
+				}
+				for _, v := range strs {
+					want, werr := Id(v)
+					got, ok := parseId(v)
+					if werr == nil != ok || Nuc(got) != want {
+						t.Errorf("id of %q: want %v (%v), got %v (%v)", v, want, werr, got, ok)
+					}
+				}
+			}
+		}
+	}
+}
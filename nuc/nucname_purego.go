@@ -0,0 +1,26 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package nuc
+
+// Id returns the nuclide for nuc in name (e.g. U235, U235m), ZZ-LL-AAAM,
+// NIST or any of the integer forms accepted by IdFromInt.
+func Id(nuc string) (Nuc, error) {
+	id, ok := parseId(nuc)
+	if !ok {
+		return 0, notNuclide(nuc)
+	}
+	return Nuc(id), nil
+}
+
+// IdFromInt returns the nuclide for nuc in id, zzaaam, MCNP or Z form.
+func IdFromInt(nuc int) (Nuc, error) {
+	id, ok := parseIdInt(nuc)
+	if !ok {
+		return 0, notNuclide(nuc)
+	}
+	return Nuc(id), nil
+}
+
+// Name returns n in name form (e.g. U235, Am242M or U).
+func (n Nuc) Name() string { return nucName(n) }
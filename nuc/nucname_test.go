@@ -0,0 +1,166 @@
+package nuc
+
+import "testing"
+
+// The expected values in these tables were generated with pyne's nucname.cc.
+
+func TestIdForms(t *testing.T) {
+	tests := []struct {
+		nuc  string
+		want Nuc
+		ok   bool
+	}{
+		{"U235", 922350000, true},
+		{"u235", 922350000, true},
+		{"U-235", 922350000, true},
+		{"U235m", 922350001, true},
+		{"Am242M", 952420001, true},
+		{"U", 920000000, true},
+		{"pu", 940000000, true},
+		{"92235", 922350000, true},
+		{"922350000", 922350000, true},
+		{"922351", 922350001, true},
+		{"92-U-235", 922350000, true},
+		{"94-Pu-239m", 942390001, true},
+		{"8-O-16", 80160000, true},
+		{"92-Pu-239", 0, false},
+		{"235U", 922350000, true},
+		{"242Am", 952420000, true},
+		{"Unat", 0, false},
+		{"H1", 10010000, true},
+		{"Tc99m", 430990001, true},
+		{"Lv293", 1162930000, true},
+		{"2350920", 922350000, true},
+		{"X235", 0, false},
+		{"U235x", 0, false},
+		{"-", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := Id(test.nuc)
+		if test.ok && err != nil {
+			t.Errorf("Id(%q): unexpected error: %v", test.nuc, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("Id(%q): want error, got %v", test.nuc, got)
+		} else if got != test.want {
+			t.Errorf("Id(%q): want %v, got %v", test.nuc, test.want, got)
+		}
+	}
+}
+
+func TestIdIntForms(t *testing.T) {
+	tests := []struct {
+		nuc  int
+		want Nuc
+		ok   bool
+	}{
+		{922350000, 922350000, true},
+		{922350001, 922350001, true},
+		{920000000, 920000000, true},
+		{92235, 922350000, true},
+		{922351, 922350001, true},
+		{92, 920000000, true},
+		{920000, 920000000, true},
+		{95242, 952420001, true},
+		{95642, 952420000, true},
+		{92635, 922350001, true},
+		{94739, 942390002, true},
+		{2350920, 922350000, true},
+		{10010, 10010000, true},
+		{1001, 10010000, true},
+		{1234567, 1234560007, true},
+		{-5, 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := IdFromInt(test.nuc)
+		if test.ok && err != nil {
+			t.Errorf("IdFromInt(%v): unexpected error: %v", test.nuc, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("IdFromInt(%v): want error, got %v", test.nuc, got)
+		} else if got != test.want {
+			t.Errorf("IdFromInt(%v): want %v, got %v", test.nuc, test.want, got)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	tests := []struct {
+		n                   Nuc
+		name, serpent, nist string
+		zzaaam, mcnp        int
+	}{
+		{922350000, "U235", "U-235", "235U", 922350, 92235},
+		{922350001, "U235M", "U-235m", "235U", 922351, 92635},
+		{922350002, "U235M", "U-235m", "235U", 922352, 92735},
+		{922350012, "U235M", "U-235m", "235U", 922359, 92235},
+		{920000000, "U", "U-nat", "U", 920000, 92000},
+		{952420000, "Am242", "Am-242", "242Am", 952420, 95642},
+		{952420001, "Am242M", "Am-242m", "242Am", 952421, 95242},
+		{10010000, "H1", "H-1", "1H", 10010, 1001},
+	}
+
+	for _, test := range tests {
+		if got := test.n.Name(); got != test.name {
+			t.Errorf("%v.Name(): want %v, got %v", int(test.n), test.name, got)
+		}
+		if got := test.n.Serpent(); got != test.serpent {
+			t.Errorf("%v.Serpent(): want %v, got %v", int(test.n), test.serpent, got)
+		}
+		if got := test.n.NIST(); got != test.nist {
+			t.Errorf("%v.NIST(): want %v, got %v", int(test.n), test.nist, got)
+		}
+		if got := test.n.Zzaaam(); got != test.zzaaam {
+			t.Errorf("%v.Zzaaam(): want %v, got %v", int(test.n), test.zzaaam, got)
+		}
+		if got := test.n.MCNP(); got != test.mcnp {
+			t.Errorf("%v.MCNP(): want %v, got %v", int(test.n), test.mcnp, got)
+		}
+	}
+}
+
+func TestFromForms(t *testing.T) {
+	tests := []struct {
+		form string
+		from func() (Nuc, error)
+		want Nuc
+		ok   bool
+	}{
+		{"zzaaam 922350", func() (Nuc, error) { return IdFromZzaaam(922350) }, 922350000, true},
+		{"zzaaam 922351", func() (Nuc, error) { return IdFromZzaaam(922351) }, 922350001, true},
+		{"zzaaam 920000", func() (Nuc, error) { return IdFromZzaaam(920000) }, 920000000, true},
+		{"mcnp 92235", func() (Nuc, error) { return IdFromMCNP(92235) }, 922350000, true},
+		{"mcnp 92635", func() (Nuc, error) { return IdFromMCNP(92635) }, 922350001, true},
+		{"mcnp 95242", func() (Nuc, error) { return IdFromMCNP(95242) }, 952420001, true},
+		{"mcnp 95642", func() (Nuc, error) { return IdFromMCNP(95642) }, 952420000, true},
+		{"mcnp 94739", func() (Nuc, error) { return IdFromMCNP(94739) }, 942390002, true},
+		{"mcnp 43499", func() (Nuc, error) { return IdFromMCNP(43499) }, 430990001, true},
+		{"mcnp 92000", func() (Nuc, error) { return IdFromMCNP(92000) }, 920000000, true},
+		{"mcnp 1001", func() (Nuc, error) { return IdFromMCNP(1001) }, 10010000, true},
+		{"mcnp 999", func() (Nuc, error) { return IdFromMCNP(999) }, 0, false},
+		{"serpent U-235", func() (Nuc, error) { return IdFromSerpent("U-235") }, 922350000, true},
+		{"serpent U-235m", func() (Nuc, error) { return IdFromSerpent("U-235m") }, 922350001, true},
+		{"serpent u-nat", func() (Nuc, error) { return IdFromSerpent("u-nat") }, 920000000, true},
+		{"serpent Am-242m", func() (Nuc, error) { return IdFromSerpent("Am-242m") }, 952420001, true},
+		{"serpent H-1", func() (Nuc, error) { return IdFromSerpent("H-1") }, 10010000, true},
+		{"serpent U-", func() (Nuc, error) { return IdFromSerpent("U-") }, 920000000, true},
+		{"serpent Xx-1", func() (Nuc, error) { return IdFromSerpent("Xx-1") }, 0, false},
+		{"nist 235U", func() (Nuc, error) { return IdFromNIST("235U") }, 922350000, true},
+		{"nist U", func() (Nuc, error) { return IdFromNIST("U") }, 920000000, true},
+		{"nist 242am", func() (Nuc, error) { return IdFromNIST("242am") }, 952420000, true},
+		{"nist 1H", func() (Nuc, error) { return IdFromNIST("1H") }, 10010000, true},
+		{"nist 235Xx", func() (Nuc, error) { return IdFromNIST("235Xx") }, 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := test.from()
+		if test.ok && err != nil {
+			t.Errorf("%v: unexpected error: %v", test.form, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%v: want error, got %v", test.form, got)
+		} else if got != test.want {
+			t.Errorf("%v: want %v, got %v", test.form, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package plot
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+)
+
+// svgCanvas accumulates SVG elements.
+type svgCanvas struct {
+	buf bytes.Buffer
+}
+
+// svgFontSize is the pixel size of the monospace font of SVG text.
+const svgFontSize = 13
+
+func newSVGCanvas(w, h int) *svgCanvas {
+	c := &svgCanvas{}
+	fmt.Fprintf(&c.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" viewBox="0 0 %v %v">`+"\n", w, h, w, h)
+	return c
+}
+
+func (c *svgCanvas) encode(w io.Writer) error {
+	c.buf.WriteString("</svg>\n")
+	_, err := c.buf.WriteTo(w)
+	return err
+}
+
+func svgColor(c color.RGBA) string { return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B) }
+
+func svgPoints(pts []point) string {
+	var buf bytes.Buffer
+	for i, p := range pts {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&buf, "%.1f,%.1f", p.X, p.Y)
+	}
+	return buf.String()
+}
+
+func (c *svgCanvas) polyline(pts []point, col color.RGBA, width float64) {
+	fmt.Fprintf(&c.buf, `<polyline points="%v" fill="none" stroke="%v" stroke-width="%v" stroke-linejoin="round"/>`+"\n", svgPoints(pts), svgColor(col), width)
+}
+
+func (c *svgCanvas) polygon(pts []point, col color.RGBA) {
+	fmt.Fprintf(&c.buf, `<polygon points="%v" fill="%v"/>`+"\n", svgPoints(pts), svgColor(col))
+}
+
+func (c *svgCanvas) text(p point, s string, align int, vertical bool, col color.RGBA) {
+	if s == "" {
+		return
+	}
+	anchor := [...]string{"end", "middle", "start"}[1-align]
+	transform := ""
+	if vertical {
+		transform = fmt.Sprintf(` transform="rotate(-90 %.1f %.1f)"`, p.X, p.Y)
+	}
+	fmt.Fprintf(&c.buf, `<text x="%.1f" y="%.1f" font-family="monospace" font-size="%v" text-anchor="%v" dominant-baseline="central" fill="%v"%v>`,
+		p.X, p.Y, svgFontSize, anchor, svgColor(col), transform)
+	xml.EscapeText(&c.buf, []byte(s))
+	c.buf.WriteString("</text>\n")
+}
+
+func (c *svgCanvas) charSize() (w, h float64) { return 0.6 * svgFontSize, svgFontSize }
+
+// rasterCanvas draws on an image using the built in bitmap font.
+type rasterCanvas struct {
+	img *image.RGBA
+}
+
+// fontScale is the number of pixels per bitmap font pixel.
+const fontScale = 2
+
+func newRasterCanvas(w, h int) *rasterCanvas {
+	return &rasterCanvas{img: image.NewRGBA(image.Rect(0, 0, w, h))}
+}
+
+func (c *rasterCanvas) encode(w io.Writer) error { return png.Encode(w, c.img) }
+
+// square fills the size by size square centered at (x, y).
+func (c *rasterCanvas) square(x, y, size float64, col color.RGBA) {
+	x0, y0 := int(math.Floor(x-size/2+0.5)), int(math.Floor(y-size/2+0.5))
+	n := int(math.Max(1, math.Floor(size+0.5)))
+	for i := x0; i < x0+n; i++ {
+		for j := y0; j < y0+n; j++ {
+			c.img.SetRGBA(i, j, col)
+		}
+	}
+}
+
+func (c *rasterCanvas) polyline(pts []point, col color.RGBA, width float64) {
+	if len(pts) == 1 {
+		c.square(pts[0].X, pts[0].Y, width, col)
+	}
+	for i := 1; i < len(pts); i++ {
+		a, b := pts[i-1], pts[i]
+		n := math.Ceil(2 * math.Max(math.Abs(b.X-a.X), math.Abs(b.Y-a.Y)))
+		for k := 0.0; k <= n; k++ {
+			t := 0.0
+			if n > 0 {
+				t = k / n
+			}
+			c.square(a.X+t*(b.X-a.X), a.Y+t*(b.Y-a.Y), width, col)
+		}
+	}
+}
+
+// polygon fills pixels whose centers are inside pts using the even-odd rule.
+func (c *rasterCanvas) polygon(pts []point, col color.RGBA) {
+	bounds := c.img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cy := float64(y) + 0.5
+		var xs []float64
+		for i := range pts {
+			a, b := pts[i], pts[(i+1)%len(pts)]
+			if (a.Y <= cy) != (b.Y <= cy) {
+				xs = append(xs, a.X+(cy-a.Y)/(b.Y-a.Y)*(b.X-a.X))
+			}
+		}
+		sort.Float64s(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			for x := int(math.Ceil(xs[i] - 0.5)); float64(x)+0.5 <= xs[i+1]; x++ {
+				c.img.SetRGBA(x, y, col)
+			}
+		}
+	}
+}
+
+func (c *rasterCanvas) text(p point, s string, align int, vertical bool, col color.RGBA) {
+	cw, ch := c.charSize()
+	length := cw * float64(len([]rune(s)))
+	// start is the offset of the beginning of the text from p along the
+	// reading direction
+	start := -length / 2
+	if align < 0 {
+		start = 0
+	} else if align > 0 {
+		start = -length
+	}
+
+	for i, r := range []rune(s) {
+		glyph := glyphs[0]
+		if r >= ' ' && int(r-' ') < len(glyphs) {
+			glyph = glyphs[r-' ']
+		}
+		for gx, bits := range glyph {
+			for gy := 0; gy < 7; gy++ {
+				if bits&(1<<uint(gy)) == 0 {
+					continue
+				}
+				// u runs along the text and v down across it
+				u := start + float64(i)*cw + float64(gx*fontScale)
+				v := -ch/2 + float64(gy*fontScale)
+				x, y := p.X+u, p.Y+v
+				if vertical {
+					x, y = p.X+v, p.Y-u-fontScale
+				}
+				for dx := 0; dx < fontScale; dx++ {
+					for dy := 0; dy < fontScale; dy++ {
+						c.img.SetRGBA(int(x)+dx, int(y)+dy, col)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (c *rasterCanvas) charSize() (w, h float64) { return 6 * fontScale, 7 * fontScale }
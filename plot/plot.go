@@ -0,0 +1,453 @@
+// Package plot renders simple charts of data series to SVG and PNG images
+// without any external tools.
+package plot
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Style selects how the series of a plot are drawn.
+type Style string
+
+const (
+	// Line connects the points of each series with straight lines.
+	Line Style = "line"
+	// Step holds each value until the next point of the series.
+	Step Style = "step"
+	// Impulse draws a vertical line from zero to every point.
+	Impulse Style = "impulse"
+	// StackedArea stacks the series on top of each other and fills the
+	// area under each one.
+	StackedArea Style = "stacked"
+)
+
+// Styles lists all valid styles.
+var Styles = []Style{Line, Step, Impulse, StackedArea}
+
+// ParseStyle returns the style named s.
+func ParseStyle(s string) (Style, error) {
+	for _, st := range Styles {
+		if string(st) == s {
+			return st, nil
+		}
+	}
+	return "", fmt.Errorf("invalid plot style '%v'", s)
+}
+
+// Series is a single named data series.  NaN values are not drawn.
+type Series struct {
+	Label string
+	X, Y  []float64
+}
+
+// Default image dimensions in pixels.
+const (
+	DefaultWidth  = 800
+	DefaultHeight = 500
+)
+
+// Plot describes a chart of one or more series sharing the same axes.
+type Plot struct {
+	Title  string
+	XLabel string
+	YLabel string
+	Style  Style
+	Series []Series
+	// XTime indicates X values are Unix times (in seconds) that are labeled
+	// with calendar dates.
+	XTime bool
+	// Width and Height are the image dimensions in pixels (DefaultWidth
+	// and DefaultHeight if zero).
+	Width, Height int
+}
+
+// Save renders p to the named file in the format given by its extension
+// (.svg or .png).
+func (p *Plot) Save(path string) error {
+	var render func(io.Writer) error
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".svg":
+		render = p.SVG
+	case ".png":
+		render = p.PNG
+	default:
+		return fmt.Errorf("unsupported plot file type '%v' (must be .svg or .png)", ext)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := render(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SVG renders p as an SVG image.
+func (p *Plot) SVG(w io.Writer) error {
+	width, height := p.size()
+	c := newSVGCanvas(width, height)
+	p.draw(c, float64(width), float64(height))
+	return c.encode(w)
+}
+
+// PNG renders p as a PNG image.
+func (p *Plot) PNG(w io.Writer) error {
+	width, height := p.size()
+	c := newRasterCanvas(width, height)
+	p.draw(c, float64(width), float64(height))
+	return c.encode(w)
+}
+
+func (p *Plot) size() (w, h int) {
+	w, h = p.Width, p.Height
+	if w <= 0 {
+		w = DefaultWidth
+	}
+	if h <= 0 {
+		h = DefaultHeight
+	}
+	return w, h
+}
+
+// point is a position in image coordinates (y increases downward).
+type point struct{ X, Y float64 }
+
+// canvas is the drawing surface an image format renders plots to.
+type canvas interface {
+	// polyline strokes the line segments connecting pts.
+	polyline(pts []point, c color.RGBA, width float64)
+	// polygon fills the closed polygon pts.
+	polygon(pts []point, c color.RGBA)
+	// text draws s vertically centered on p.  align is -1, 0 or 1 to place
+	// p at the left end, center or right end of the text.  Vertical text
+	// reads from bottom to top with p at its horizontal center.
+	text(p point, s string, align int, vertical bool, c color.RGBA)
+	// charSize returns the size of a single character of text.
+	charSize() (w, h float64)
+}
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	white = color.RGBA{255, 255, 255, 255}
+	grid  = color.RGBA{220, 220, 220, 255}
+)
+
+// palette holds the colors used for successive series.
+var palette = []color.RGBA{
+	{31, 119, 180, 255},
+	{255, 127, 14, 255},
+	{44, 160, 44, 255},
+	{214, 39, 40, 255},
+	{148, 103, 189, 255},
+	{140, 86, 75, 255},
+	{227, 119, 194, 255},
+	{127, 127, 127, 255},
+	{188, 189, 34, 255},
+	{23, 190, 207, 255},
+}
+
+func seriesColor(i int) color.RGBA { return palette[i%len(palette)] }
+
+// lighten mixes c with white for filled areas.
+func lighten(c color.RGBA) color.RGBA {
+	mix := func(v uint8) uint8 { return uint8(255 - (255-int(v))*6/10) }
+	return color.RGBA{mix(c.R), mix(c.G), mix(c.B), 255}
+}
+
+// draw lays out and draws the complete plot on c.
+func (p *Plot) draw(c canvas, width, height float64) {
+	cw, ch := c.charSize()
+	series := p.Series
+	if p.Style == StackedArea {
+		series = stack(series)
+	}
+
+	xmin, xmax, ymin, ymax := bounds(series)
+	if p.Style != Line {
+		// bars and areas start at zero
+		ymin, ymax = math.Min(ymin, 0), math.Max(ymax, 0)
+	}
+	xmin, xmax = widen(xmin, xmax)
+	ymin, ymax = widen(ymin, ymax)
+
+	yticks := ticks(ymin, ymax)
+	ymin, ymax = math.Min(ymin, yticks[0].V), math.Max(ymax, yticks[len(yticks)-1].V)
+	var xticks []tick
+	if p.XTime {
+		xticks = timeTicks(xmin, xmax)
+	} else {
+		xticks = ticks(xmin, xmax)
+		xmin, xmax = math.Min(xmin, xticks[0].V), math.Max(xmax, xticks[len(xticks)-1].V)
+	}
+
+	labelw := 0.0
+	for _, t := range yticks {
+		labelw = math.Max(labelw, cw*float64(len(t.Label)))
+	}
+	left := labelw + 2.5*ch + 12
+	right := 2 * cw
+	top := 2.5 * ch
+	bottom := 4.5 * ch
+	x0, x1, y0, y1 := left, width-right, height-bottom, top
+
+	sx := func(x float64) float64 { return x0 + (x-xmin)/(xmax-xmin)*(x1-x0) }
+	sy := func(y float64) float64 { return y0 + (y-ymin)/(ymax-ymin)*(y1-y0) }
+
+	c.polygon([]point{{0, 0}, {width, 0}, {width, height}, {0, height}}, white)
+	for _, t := range yticks {
+		y := sy(t.V)
+		c.polyline([]point{{x0, y}, {x1, y}}, grid, 1)
+		c.text(point{x0 - 6, y}, t.Label, 1, false, black)
+	}
+	for _, t := range xticks {
+		if t.V < xmin || t.V > xmax {
+			continue
+		}
+		x := sx(t.V)
+		c.polyline([]point{{x, y0}, {x, y1}}, grid, 1)
+		c.text(point{x, y0 + 1.3*ch}, t.Label, 0, false, black)
+	}
+
+	for i := len(series) - 1; i >= 0 && p.Style == StackedArea; i-- {
+		// fill from the top down so each area covers the ones stacked on it
+		s := series[i]
+		if len(s.X) == 0 {
+			continue
+		}
+		pts := []point{{sx(s.X[0]), sy(0)}}
+		for j := range s.X {
+			pts = append(pts, point{sx(s.X[j]), sy(s.Y[j])})
+		}
+		pts = append(pts, point{sx(s.X[len(s.X)-1]), sy(0)})
+		c.polygon(pts, lighten(seriesColor(i)))
+	}
+	for i, s := range series {
+		col := seriesColor(i)
+		switch p.Style {
+		case Impulse:
+			// offset series slightly so overlapping impulses stay visible
+			off := (float64(i) - float64(len(series)-1)/2) * 3
+			for j := range s.X {
+				if !math.IsNaN(s.Y[j]) {
+					x := sx(s.X[j]) + off
+					c.polyline([]point{{x, sy(0)}, {x, sy(s.Y[j])}}, col, 2)
+				}
+			}
+		case Step:
+			for _, seg := range segments(s) {
+				var pts []point
+				for j, pt := range seg {
+					if j > 0 {
+						pts = append(pts, point{sx(pt.X), pts[len(pts)-1].Y})
+					}
+					pts = append(pts, point{sx(pt.X), sy(pt.Y)})
+				}
+				c.polyline(pts, col, 2)
+			}
+		default:
+			for _, seg := range segments(s) {
+				pts := make([]point, len(seg))
+				for j, pt := range seg {
+					pts[j] = point{sx(pt.X), sy(pt.Y)}
+				}
+				c.polyline(pts, col, 2)
+			}
+		}
+	}
+
+	c.polyline([]point{{x0, y1}, {x1, y1}, {x1, y0}, {x0, y0}, {x0, y1}}, black, 1)
+	c.text(point{(x0 + x1) / 2, top / 2}, p.Title, 0, false, black)
+	c.text(point{(x0 + x1) / 2, height - 1.5*ch}, p.XLabel, 0, false, black)
+	c.text(point{ch, (y0 + y1) / 2}, p.YLabel, 0, true, black)
+
+	if len(series) > 1 {
+		p.legend(c, series, x0+cw, y1+cw)
+	}
+}
+
+// legend draws a box labeling each series with its color at the top left
+// corner (x, y).
+func (p *Plot) legend(c canvas, series []Series, x, y float64) {
+	cw, ch := c.charSize()
+	w := 0.0
+	for _, s := range series {
+		w = math.Max(w, cw*float64(len(s.Label)))
+	}
+	w += 4 * cw
+	h := 1.5*ch*float64(len(series)) + ch/2
+	box := []point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}, {x, y}}
+	c.polygon(box, white)
+	c.polyline(box, black, 1)
+
+	for i, s := range series {
+		ly := y + ch + 1.5*ch*float64(i)
+		c.polyline([]point{{x + cw/2, ly}, {x + 2*cw, ly}}, seriesColor(i), 3)
+		c.text(point{x + 3*cw, ly}, s.Label, -1, false, black)
+	}
+}
+
+// segments splits s into runs of points without NaN values.
+func segments(s Series) [][]point {
+	var segs [][]point
+	var seg []point
+	for i := range s.X {
+		if math.IsNaN(s.Y[i]) || math.IsNaN(s.X[i]) {
+			if len(seg) > 0 {
+				segs = append(segs, seg)
+			}
+			seg = nil
+			continue
+		}
+		seg = append(seg, point{s.X[i], s.Y[i]})
+	}
+	if len(seg) > 0 {
+		segs = append(segs, seg)
+	}
+	return segs
+}
+
+// stack returns series holding the cumulative sums of the given series at
+// every X value of any of them.  Missing and NaN values count as zero.
+func stack(series []Series) []Series {
+	xs := map[float64]bool{}
+	for _, s := range series {
+		for _, x := range s.X {
+			if !math.IsNaN(x) {
+				xs[x] = true
+			}
+		}
+	}
+	var x []float64
+	for v := range xs {
+		x = append(x, v)
+	}
+	sort.Float64s(x)
+
+	total := make([]float64, len(x))
+	stacked := make([]Series, len(series))
+	for i, s := range series {
+		vals := map[float64]float64{}
+		for j := range s.X {
+			if !math.IsNaN(s.Y[j]) {
+				vals[s.X[j]] += s.Y[j]
+			}
+		}
+		stacked[i] = Series{Label: s.Label, X: x, Y: make([]float64, len(x))}
+		for j, v := range x {
+			total[j] += vals[v]
+			stacked[i].Y[j] = total[j]
+		}
+	}
+	return stacked
+}
+
+// bounds returns the range of all non-NaN values of series.
+func bounds(series []Series) (xmin, xmax, ymin, ymax float64) {
+	xmin, ymin = math.Inf(1), math.Inf(1)
+	xmax, ymax = math.Inf(-1), math.Inf(-1)
+	for _, s := range series {
+		for i := range s.X {
+			if math.IsNaN(s.X[i]) || math.IsNaN(s.Y[i]) {
+				continue
+			}
+			xmin, xmax = math.Min(xmin, s.X[i]), math.Max(xmax, s.X[i])
+			ymin, ymax = math.Min(ymin, s.Y[i]), math.Max(ymax, s.Y[i])
+		}
+	}
+	if math.IsInf(xmin, 1) {
+		return 0, 1, 0, 1
+	}
+	return xmin, xmax, ymin, ymax
+}
+
+// widen returns a non-empty range containing min and max.
+func widen(min, max float64) (float64, float64) {
+	if max > min {
+		return min, max
+	} else if d := math.Abs(min) / 10; d > 0 {
+		return min - d, max + d
+	}
+	return min - 1, max + 1
+}
+
+// tick is a labeled axis position.
+type tick struct {
+	V     float64
+	Label string
+}
+
+// ticks returns evenly spaced ticks at round numbers covering min to max.
+func ticks(min, max float64) []tick {
+	step := niceStep((max - min) / 6)
+	prec := 0
+	if step < 1 {
+		prec = int(math.Ceil(-math.Log10(step)))
+	}
+	var ts []tick
+	for i := math.Floor(min / step); ; i++ {
+		v := i * step
+		label := strconv.FormatFloat(v, 'f', prec, 64)
+		if math.Abs(v) >= 1e6 {
+			label = strconv.FormatFloat(v, 'g', 4, 64)
+		}
+		ts = append(ts, tick{v, label})
+		if v >= max {
+			return ts
+		}
+	}
+}
+
+// niceStep rounds a positive step up to 1, 2 or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+// timeTicks returns ticks at the start of whole years - or months for
+// ranges shorter than a few years - between the Unix times min and max.
+func timeTicks(min, max float64) []tick {
+	start, end := time.Unix(int64(min), 0).UTC(), time.Unix(int64(max), 0).UTC()
+	months := (end.Year()-start.Year())*12 + int(end.Month()-start.Month())
+
+	step, format := 1, "2006-01"
+	for _, n := range []int{1, 2, 3, 6} {
+		step = n
+		if months/n <= 8 {
+			break
+		}
+	}
+	if months > 8*6 {
+		step = 12 * int(niceStep(float64(months)/12/8))
+		format = "2006"
+	}
+
+	t := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if step >= 12 {
+		t = time.Date(start.Year()-start.Year()%(step/12), 1, 1, 0, 0, 0, 0, time.UTC)
+	} else {
+		t = t.AddDate(0, -(int(t.Month())-1)%step, 0)
+	}
+
+	var ts []tick
+	for ; !t.After(end); t = t.AddDate(0, step, 0) {
+		ts = append(ts, tick{float64(t.Unix()), t.Format(format)})
+	}
+	return ts
+}
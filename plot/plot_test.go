@@ -0,0 +1,150 @@
+package plot
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPlot(style Style) *Plot {
+	return &Plot{
+		Title:  "Inventory",
+		XLabel: "Time (Steps)",
+		YLabel: "Inventory (kg)",
+		Style:  style,
+		Width:  300,
+		Height: 200,
+		Series: []Series{
+			{"a & b", []float64{0, 1, 2, 3}, []float64{1, 2, math.NaN(), 4}},
+			{"c", []float64{1, 2, 4}, []float64{3, 2, 1}},
+		},
+	}
+}
+
+func TestPlot_SVG(t *testing.T) {
+	for _, style := range Styles {
+		var buf bytes.Buffer
+		if err := testPlot(style).SVG(&buf); err != nil {
+			t.Fatalf("[%v] %v", style, err)
+		}
+
+		dec := xml.NewDecoder(&buf)
+		var text []string
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("[%v] invalid SVG: %v", style, err)
+			}
+			if cd, ok := tok.(xml.CharData); ok && strings.TrimSpace(string(cd)) != "" {
+				text = append(text, string(cd))
+			}
+		}
+		for _, want := range []string{"Inventory", "Time (Steps)", "Inventory (kg)", "a & b", "c"} {
+			found := false
+			for _, s := range text {
+				found = found || s == want
+			}
+			if !found {
+				t.Errorf("[%v] missing text %q in %q", style, want, text)
+			}
+		}
+	}
+}
+
+func TestPlot_PNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testPlot(StackedArea).PNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Size(); got.X != 300 || got.Y != 200 {
+		t.Errorf("got %v image, want 300x200", got)
+	}
+}
+
+func TestPlot_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cyan-plot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"out.svg", "out.PNG"} {
+		path := filepath.Join(dir, name)
+		if err := testPlot(Line).Save(path); err != nil {
+			t.Errorf("%v: %v", name, err)
+		} else if fi, err := os.Stat(path); err != nil || fi.Size() == 0 {
+			t.Errorf("%v: no plot written", name)
+		}
+	}
+	if err := testPlot(Line).Save(filepath.Join(dir, "out.pdf")); err == nil {
+		t.Error("got nil error for unsupported file type")
+	}
+}
+
+func TestStack(t *testing.T) {
+	got := stack(testPlot(StackedArea).Series)
+	x := []float64{0, 1, 2, 3, 4}
+	want := []Series{
+		{"a & b", x, []float64{1, 2, 0, 4, 0}},
+		{"c", x, []float64{1, 5, 2, 4, 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTicks(t *testing.T) {
+	var tests = []struct {
+		Min, Max float64
+		Want     []string
+	}{
+		{0, 10, []string{"0", "2", "4", "6", "8", "10"}},
+		{0.1, 0.35, []string{"0.10", "0.15", "0.20", "0.25", "0.30", "0.35"}},
+		{-3, 7, []string{"-4", "-2", "0", "2", "4", "6", "8"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, tk := range ticks(test.Min, test.Max) {
+			got = append(got, tk.Label)
+		}
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("ticks(%v, %v): got %v, want %v", test.Min, test.Max, got, test.Want)
+		}
+	}
+}
+
+func TestTimeTicks(t *testing.T) {
+	unix := func(y, m int) float64 { return float64(time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.UTC).Unix()) }
+	var tests = []struct {
+		Min, Max float64
+		Want     []string
+	}{
+		{unix(2030, 2), unix(2030, 8), []string{"2030-02", "2030-03", "2030-04", "2030-05", "2030-06", "2030-07", "2030-08"}},
+		{unix(2030, 1), unix(2032, 1), []string{"2030-01", "2030-04", "2030-07", "2030-10", "2031-01", "2031-04", "2031-07", "2031-10", "2032-01"}},
+		{unix(2021, 3), unix(2060, 1), []string{"2020", "2025", "2030", "2035", "2040", "2045", "2050", "2055", "2060"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, tk := range timeTicks(test.Min, test.Max) {
+			got = append(got, tk.Label)
+		}
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("timeTicks: got %v, want %v", got, test.Want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package post
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/rwcarlsen/cyan/query"
+)
+
+// DefaultDt is the cyclus default time step duration in seconds - one
+// twelfth of an average Gregorian year.
+const DefaultDt = query.DefaultDt
+
+// Calendar start used for simulations whose Info table doesn't record one.
+const (
+	DefaultYear  = 2000
+	DefaultMonth = 1
+)
+
+// calendar maps the time steps of a simulation to dates.
+type calendar struct {
+	Year  int
+	Month int
+	// Dt is the time step duration in seconds.
+	Dt int
+}
+
+// loadCalendar reads the start date and time step duration of simid from
+// the Info table.  Older cyclus databases lack some of the columns, in
+// which case cyclus defaults are used.
+func loadCalendar(tx *sql.Tx, simid []byte) (calendar, error) {
+	cal := calendar{Year: DefaultYear, Month: DefaultMonth, Dt: DefaultDt}
+	info, err := query.InfoColumns(tx, simid)
+	if err != nil {
+		return cal, tableErr("Info", "query", err)
+	}
+	if y, ok := info["InitialYear"]; ok {
+		cal.Year = int(y)
+	}
+	if m, ok := info["InitialMonth"]; ok {
+		cal.Month = int(m)
+	}
+	cal.Dt = int(info.Dt())
+	return cal, nil
+}
+
+// monthly returns true if time steps are a whole number of cyclus months.
+// Such time steps advance by calendar months so that every step starts on
+// the first of a month.
+func (c calendar) monthly() bool { return c.Dt%DefaultDt == 0 }
+
+// Date returns the date at the start of time step t.
+func (c calendar) Date(t int) time.Time {
+	start := time.Date(c.Year, time.Month(c.Month), 1, 0, 0, 0, 0, time.UTC)
+	if c.monthly() {
+		return start.AddDate(0, t*(c.Dt/DefaultDt), 0)
+	}
+	secs := int64(t) * int64(c.Dt)
+	return start.AddDate(0, 0, int(secs/86400)).Add(time.Duration(secs%86400) * time.Second)
+}
+
+// DecYear returns the date at the start of time step t as a decimal year
+// (e.g. 2030.5 for July 1st 2030 with monthly time steps).
+func (c calendar) DecYear(t int) float64 {
+	d := c.Date(t)
+	if c.monthly() {
+		return float64(d.Year()) + float64(d.Month()-1)/12
+	}
+	jan := time.Date(d.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	next := jan.AddDate(1, 0, 0)
+	return float64(d.Year()) + d.Sub(jan).Seconds()/next.Sub(jan).Seconds()
+}
+
+// Months returns the number of (average) months elapsed from the start of
+// the simulation to the start of time step t.
+func (c calendar) Months(t int) float64 {
+	return float64(t) * float64(c.Dt) / DefaultDt
+}
@@ -0,0 +1,87 @@
+package post
+
+import (
+	"errors"
+	"fmt"
+)
+
+type AlreadyPostErr []byte
+
+func (s AlreadyPostErr) Error() string {
+	return fmt.Sprintf("SimId %x is already post processed", []byte(s))
+}
+
+func IsAlreadyPostErr(err error) bool {
+	var e AlreadyPostErr
+	return errors.As(err, &e)
+}
+
+// MissingTableErr is returned when a table required for post processing
+// does not exist in the database.
+type MissingTableErr string
+
+func (s MissingTableErr) Error() string {
+	return fmt.Sprintf("required table %v does not exist", string(s))
+}
+
+func IsMissingTableErr(err error) bool {
+	var e MissingTableErr
+	return errors.As(err, &e)
+}
+
+// SchemaErr is returned when a table exists but its columns don't match
+// what post processing expects.
+type SchemaErr struct {
+	Table string
+	Err   error
+}
+
+func (e *SchemaErr) Error() string {
+	return fmt.Sprintf("unexpected schema for table %v: %v", e.Table, e.Err)
+}
+
+func (e *SchemaErr) Unwrap() error { return e.Err }
+
+func IsSchemaErr(err error) bool {
+	var e *SchemaErr
+	return errors.As(err, &e)
+}
+
+// TableErr is returned when reading from or writing to a table fails for a
+// reason other than a missing table or schema mismatch.
+type TableErr struct {
+	Table string
+	// Op is the failed operation (e.g. "insert", "query", "index").
+	Op  string
+	Err error
+}
+
+func (e *TableErr) Error() string {
+	return fmt.Sprintf("%v on table %v failed: %v", e.Op, e.Table, e.Err)
+}
+
+func (e *TableErr) Unwrap() error { return e.Err }
+
+// SimErr identifies the simulation that a post processing error occurred
+// for.  Any partial output for the simulation has been removed.
+type SimErr struct {
+	Simid []byte
+	Err   error
+}
+
+func (e *SimErr) Error() string {
+	return fmt.Sprintf("post processing SimId %x: %v", e.Simid, e.Err)
+}
+
+func (e *SimErr) Unwrap() error { return e.Err }
+
+// tableErr wraps a database error that occurred during op on table in a
+// *TableErr.  It returns nil if err is nil.  Missing tables and columns are
+// detected before any table is used (see Prepare and checkSchema) rather
+// than from the driver's error messages.
+func tableErr(table, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TableErr{Table: table, Op: op, Err: err}
+}
@@ -0,0 +1,217 @@
+package post
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+)
+
+const (
+	resGraphSql   = "SELECT ResourceId,TimeCreated,Parent1,Parent2,QualId,Quantity FROM Resources WHERE SimId = ?;"
+	creatorsSql   = "SELECT ResourceId,AgentId FROM ResCreators WHERE SimId = ?;"
+	ownerTransSql = "SELECT TransactionId,ResourceId,ReceiverId,Time FROM Transactions WHERE SimId = ?;"
+	// The new* queries load the data added since an earlier walk.
+	newResSql      = "SELECT ResourceId,TimeCreated,Parent1,Parent2,QualId,Quantity FROM Resources WHERE SimId = ? AND ResourceId > ?;"
+	newCreatorsSql = "SELECT ResourceId,AgentId FROM ResCreators WHERE SimId = ? AND ResourceId > ?;"
+	newTransSql    = "SELECT TransactionId,ResourceId,ReceiverId,Time FROM Transactions WHERE SimId = ? AND (TransactionId > ? OR ResourceId > ?);"
+)
+
+// errRewalk is returned when extending an earlier walk if the new data
+// changes resources that the earlier walk closed.
+var errRewalk = errors.New("new data changes resources closed by the previous walk")
+
+// ownerChange records a resource moving into a new agent's inventory.
+type ownerChange struct {
+	TransId int
+	Owner   int
+	Time    int
+}
+
+// resource is a single resource object in a resGraph.
+type resource struct {
+	Id     int
+	Time   int
+	QualId int
+	Qty    float64
+	// Kids holds graph indices of the resource's children: those with the
+	// resource as Parent1 followed by those with it as Parent2.
+	Kids []int32
+	// Owners holds the resource's transactions ordered by time.
+	Owners []ownerChange
+}
+
+// openRes is a resource that an earlier walk left open (i.e. without
+// children).  Its Time is the start of its open inventory interval.
+type openRes struct {
+	resource
+	// Owner holds the resource when the interval starts.
+	Owner int
+	// Inherited is the owner the resource inherited from its parent or
+	// creator.  Transactions to it are ignored just as in a full walk.
+	Inherited int
+}
+
+// resGraph is an in-memory parent/child graph of all resources in a
+// simulation.
+type resGraph struct {
+	Res []resource
+	// Roots holds the graph indices and creating agent id of every resource
+	// created from scratch by an agent.  When extending an earlier walk, the
+	// open resources precede them.
+	Roots []root
+	index map[int]int32
+	// inherited maps the graph indices of open resources to the owner they
+	// inherited.
+	inherited map[int32]int
+	// MaxResId, MaxTransId and MaxTime are the highest resource id,
+	// transaction id and time step found while loading the graph.
+	MaxResId, MaxTransId, MaxTime int
+	// MaxSettledId is the highest resource id below any loaded resource with
+	// neither parents nor a creator - i.e. one whose ResCreators row cyclus
+	// has yet to write.  It is MaxResId if there is no such resource.
+	MaxSettledId int
+}
+
+type root struct {
+	Index   int32
+	AgentId int
+}
+
+// loadGraph bulk-loads the Resources, ResCreators and Transactions tables
+// for simid and builds the resource graph in memory.  If since is not nil,
+// only the resources and transactions added after the walk that recorded
+// since are loaded, with the resources that walk left open added as roots
+// ahead of the new ones.  errRewalk is returned if the new data refers to
+// resources that are neither new nor open.
+func loadGraph(db *sql.DB, simid []byte, since *postState, open []openRes) (g *resGraph, err error) {
+	g = &resGraph{index: map[int]int32{}, inherited: map[int32]int{}}
+	resSql, cSql, trSql := resGraphSql, creatorsSql, ownerTransSql
+	resArgs, trArgs := []interface{}{simid}, []interface{}{simid}
+	if since != nil {
+		resSql, cSql, trSql = newResSql, newCreatorsSql, newTransSql
+		resArgs = append(resArgs, since.MaxResourceId)
+		trArgs = append(trArgs, since.MaxTransactionId, since.MaxResourceId)
+		g.MaxResId, g.MaxTransId, g.MaxTime = since.MaxResourceId, since.MaxTransactionId, since.MaxTime
+	}
+	for _, o := range open {
+		i := int32(len(g.Res))
+		g.index[o.Id] = i
+		g.Res = append(g.Res, o.resource)
+		g.Roots = append(g.Roots, root{Index: i, AgentId: o.Owner})
+		g.inherited[i] = o.Inherited
+	}
+	nopen := len(g.Res)
+
+	// parent ids for each resource are resolved once all resources are known
+	var parents [][2]int
+	rows, err := db.Query(resSql, resArgs...)
+	if err != nil {
+		return nil, tableErr("Resources", "query", err)
+	}
+	for rows.Next() {
+		var r resource
+		var p [2]int
+		if err := rows.Scan(&r.Id, &r.Time, &p[0], &p[1], &r.QualId, &r.Qty); err != nil {
+			rows.Close()
+			return nil, tableErr("Resources", "query", err)
+		}
+		g.index[r.Id] = int32(len(g.Res))
+		g.Res = append(g.Res, r)
+		parents = append(parents, p)
+		if r.Id > g.MaxResId {
+			g.MaxResId = r.Id
+		}
+		if r.Time > g.MaxTime {
+			g.MaxTime = r.Time
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, tableErr("Resources", "query", err)
+	}
+
+	for k := 0; k < 2; k++ {
+		for i, p := range parents {
+			if p[k] == 0 || k == 1 && p[1] == p[0] {
+				continue
+			} else if j, ok := g.index[p[k]]; ok {
+				g.Res[j].Kids = append(g.Res[j].Kids, int32(nopen+i))
+			} else if since != nil && p[k] <= since.MaxResourceId {
+				return nil, errRewalk
+			}
+		}
+	}
+
+	rooted := make([]bool, len(parents))
+	rows, err = db.Query(cSql, resArgs...)
+	if err != nil {
+		return nil, tableErr("ResCreators", "query", err)
+	}
+	for rows.Next() {
+		var resid, agent int
+		if err := rows.Scan(&resid, &agent); err != nil {
+			rows.Close()
+			return nil, tableErr("ResCreators", "query", err)
+		}
+		if i, ok := g.index[resid]; ok && int(i) >= nopen {
+			g.Roots = append(g.Roots, root{Index: i, AgentId: agent})
+			rooted[int(i)-nopen] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, tableErr("ResCreators", "query", err)
+	}
+
+	g.MaxSettledId = g.MaxResId
+	for i, p := range parents {
+		if id := g.Res[nopen+i].Id; p == [2]int{} && !rooted[i] && id <= g.MaxSettledId {
+			g.MaxSettledId = id - 1
+		}
+	}
+
+	rows, err = db.Query(trSql, trArgs...)
+	if err != nil {
+		return nil, tableErr("Transactions", "query", err)
+	}
+	for rows.Next() {
+		var resid int
+		var oc ownerChange
+		if err := rows.Scan(&oc.TransId, &resid, &oc.Owner, &oc.Time); err != nil {
+			rows.Close()
+			return nil, tableErr("Transactions", "query", err)
+		}
+		i, ok := g.index[resid]
+		if since != nil && oc.TransId <= since.MaxTransactionId && (!ok || int(i) < nopen) {
+			// seen by the earlier walk
+			continue
+		} else if since != nil && !ok && resid <= since.MaxResourceId {
+			rows.Close()
+			return nil, errRewalk
+		}
+
+		if oc.TransId > g.MaxTransId {
+			g.MaxTransId = oc.TransId
+		}
+		if oc.Time > g.MaxTime {
+			g.MaxTime = oc.Time
+		}
+		if ok {
+			g.Res[i].Owners = append(g.Res[i].Owners, oc)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, tableErr("Transactions", "query", err)
+	}
+
+	for i := range g.Res {
+		if owners := g.Res[i].Owners; len(owners) > 1 {
+			sort.Stable(byTime(owners))
+		}
+	}
+	return g, nil
+}
+
+type byTime []ownerChange
+
+func (o byTime) Len() int           { return len(o) }
+func (o byTime) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o byTime) Less(i, j int) bool { return o[i].Time < o[j].Time }
@@ -0,0 +1,149 @@
+package post
+
+import (
+	"database/sql"
+	"math"
+)
+
+const (
+	stateSql       = "SELECT MaxResourceId,MaxTransactionId,MaxTime FROM CyanPostState WHERE SimId = ?;"
+	insertStateSql = "INSERT INTO CyanPostState VALUES (?,?,?,?);"
+	openSql        = `SELECT o.ResourceId,o.OwnerId,i.AgentId,i.StartTime,i.QualId,i.Quantity
+				FROM CyanPostOpen AS o
+				INNER JOIN Inventories AS i ON i.SimId = o.SimId AND i.ResourceId = o.ResourceId
+				WHERE o.SimId = ? AND i.EndTime = ? ORDER BY o.rowid;`
+	insertOpenSql = "INSERT INTO CyanPostOpen VALUES (?,?,?);"
+)
+
+// postState records how much of a simulation's raw data has been post
+// processed so that later incremental walks can extend the output with only
+// new data.  Every resource up to MaxResourceId and transaction up to
+// MaxTransactionId has been walked.
+type postState struct {
+	MaxResourceId    int
+	MaxTransactionId int
+	MaxTime          int
+}
+
+func loadState(db *sql.DB, simid []byte) (st postState, err error) {
+	err = db.QueryRow(stateSql, simid).Scan(&st.MaxResourceId, &st.MaxTransactionId, &st.MaxTime)
+	if err == sql.ErrNoRows {
+		return st, nil
+	}
+	return st, tableErr("CyanPostState", "query", err)
+}
+
+func saveState(tx *sql.Tx, simid []byte, st postState) error {
+	if _, err := tx.Exec("DELETE FROM CyanPostState WHERE SimId = ?;", simid); err != nil {
+		return tableErr("CyanPostState", "delete", err)
+	}
+	_, err := tx.Exec(insertStateSql, simid, st.MaxResourceId, st.MaxTransactionId, st.MaxTime)
+	return tableErr("CyanPostState", "insert", err)
+}
+
+// idleState tracks the raw data a Processor's incremental walks of a
+// simulation have seen.
+type idleState struct {
+	Seen postState
+	// Walks is the number of consecutive walks that found no new data.
+	Walks int
+}
+
+// simFinished returns true if cyclus has finished writing the context's
+// simulation as indicated by the Finish table.  Cyclus only creates the
+// Finish table at the end of a run, so a database without one is treated as
+// finished unless walking incrementally.
+func (c *Context) simFinished() (bool, error) {
+	sidecar, err := IsSidecar(c.DB)
+	if err != nil {
+		return false, err
+	}
+	ok, err := tableExists(c.DB, sidecar, "Finish")
+	if err != nil {
+		return false, err
+	} else if !ok {
+		return !c.Incremental, nil
+	}
+
+	n := 0
+	err = c.QueryRow("SELECT COUNT(*) FROM Finish WHERE SimId = ?;", c.Simid).Scan(&n)
+	return n > 0, tableErr("Finish", "query", err)
+}
+
+// checkIdle counts the consecutive walks of an unfinished simulation that
+// found no new data in the loaded graph.  The simulation is treated as
+// finished once the count reaches the context's idle limit.
+func (c *Context) checkIdle() {
+	if c.idle == nil || c.finished {
+		return
+	}
+	seen := postState{c.graph.MaxResId, c.graph.MaxTransId, c.graph.MaxTime}
+	if seen == c.idle.Seen {
+		c.idle.Walks++
+	} else {
+		c.idle.Seen, c.idle.Walks = seen, 0
+	}
+	if c.idleLimit > 0 && c.idle.Walks >= c.idleLimit {
+		c.Log.Printf("No new data in %v walks - treating the simulation as finished\n", c.idle.Walks)
+		c.finished = true
+	}
+}
+
+// loadNew loads the graph of the resources the walk that recorded st left
+// open and of the resources and transactions added since.  errRewalk is
+// returned if the new data changes resources that walk closed (e.g. because
+// cyclus wrote the children or transactions of a resource in separate
+// batches), in which case the simulation must be walked from scratch.
+func loadNew(db *sql.DB, simid []byte, st postState) (*resGraph, error) {
+	// resources above MaxResourceId have been walked if it was held back for
+	// a resource waiting for its creator
+	n := 0
+	err := db.QueryRow("SELECT COUNT(*) FROM Inventories WHERE SimId = ? AND ResourceId > ?;", simid, st.MaxResourceId).Scan(&n)
+	if err != nil {
+		return nil, tableErr("Inventories", "query", err)
+	} else if n > 0 {
+		return nil, errRewalk
+	}
+
+	var open []openRes
+	rows, err := db.Query(openSql, simid, math.MaxInt32)
+	if err != nil {
+		return nil, tableErr("CyanPostOpen", "query", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o openRes
+		if err := rows.Scan(&o.Id, &o.Inherited, &o.Owner, &o.Time, &o.QualId, &o.Qty); err != nil {
+			return nil, tableErr("CyanPostOpen", "query", err)
+		}
+		open = append(open, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, tableErr("CyanPostOpen", "query", err)
+	}
+	rows.Close()
+	return loadGraph(db, simid, &st, open)
+}
+
+// saveOpen replaces the context's open resources recorded in the
+// CyanPostOpen table with those of the current walk if the simulation is
+// unfinished.
+func (c *Context) saveOpen(tx *sql.Tx) error {
+	if _, err := tx.Exec("DELETE FROM CyanPostOpen WHERE SimId = ?;", c.Simid); err != nil {
+		return tableErr("CyanPostOpen", "delete", err)
+	} else if c.finished {
+		return nil
+	}
+
+	stmt, err := tx.Prepare(insertOpenSql)
+	if err != nil {
+		return tableErr("CyanPostOpen", "insert", err)
+	}
+	defer stmt.Close()
+	for _, o := range c.open {
+		if _, err := stmt.Exec(c.Simid, o.Id, o.Inherited); err != nil {
+			return tableErr("CyanPostOpen", "insert", err)
+		}
+	}
+	return nil
+}
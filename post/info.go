@@ -0,0 +1,76 @@
+package post
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SchemaVersion identifies the layout and semantics of the tables written by
+// post processing.  It must be incremented whenever they change so that
+// databases processed by older versions of cyan are re-processed
+// automatically.
+const SchemaVersion = 3
+
+// Version is the cyan version recorded alongside post processed data.  It
+// can be set at build time with:
+//
+//	go build -ldflags "-X github.com/rwcarlsen/cyan/post.Version=<version>"
+var Version = "dev"
+
+// Post processing status values stored in the CyanPostInfo table.
+const (
+	StatusRunning  = "running"
+	StatusComplete = "complete"
+	// StatusPartial marks the output of a walk made while cyclus was still
+	// writing the simulation.
+	StatusPartial = "partial"
+)
+
+const (
+	infoSql       = "SELECT SchemaVersion,CyanVersion,Status,Time FROM CyanPostInfo WHERE SimId = ?;"
+	insertInfoSql = "INSERT INTO CyanPostInfo VALUES (?,?,?,?,?);"
+	statusSql     = "UPDATE CyanPostInfo SET Status = ?, Time = ? WHERE SimId = ?;"
+)
+
+// PostInfo describes the post processing state of a single simulation.
+type PostInfo struct {
+	Simid         []byte
+	SchemaVersion int
+	CyanVersion   string
+	Status        string
+	Time          time.Time
+}
+
+// Current returns true if the simulation's post processing completed with
+// a schema version no older than SchemaVersion.
+func (pi *PostInfo) Current() bool {
+	return pi.Status == StatusComplete && pi.SchemaVersion >= SchemaVersion
+}
+
+// Info returns the post processing state recorded for simid in db or nil if
+// simid has never been post processed.  Simulations processed before the
+// CyanPostInfo table existed are reported as complete with schema version 0.
+func Info(db *sql.DB, simid []byte) (*PostInfo, error) {
+	pi := &PostInfo{Simid: simid}
+	var t string
+	err := db.QueryRow(infoSql, simid).Scan(&pi.SchemaVersion, &pi.CyanVersion, &pi.Status, &t)
+	if err == nil {
+		pi.Time, _ = time.Parse(time.RFC3339, t)
+		return pi, nil
+	} else if err != sql.ErrNoRows {
+		return nil, tableErr("CyanPostInfo", "query", err)
+	}
+
+	// check for output from cyan versions that predate CyanPostInfo
+	dummy := 0
+	err = db.QueryRow("SELECT AgentId FROM Agents WHERE SimId = ? LIMIT 1", simid).Scan(&dummy)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, tableErr("Agents", "query", err)
+	}
+	pi.Status = StatusComplete
+	return pi, nil
+}
+
+func now() string { return time.Now().UTC().Format(time.RFC3339) }
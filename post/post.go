@@ -1,10 +1,13 @@
 package post
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"math"
+	"strings"
+	"sync"
 
 	"github.com/rwcarlsen/cyan/query"
 )
@@ -12,97 +15,321 @@ import (
 // The number of sql commands to buffer before dumping to the output database.
 const DumpFreq = 100000
 
+// The number of resources walked between checks for cancellation.
+const cancelFreq = 1000
+
 var (
-	preExecStmts = []string{
+	pragmas = []string{
 		"PRAGMA synchronous = OFF;",
-		"PRAGMA journal_mode = OFF;",
-		"CREATE TABLE IF NOT EXISTS TimeSeriesPower (SimId BLOB,AgentId INTEGER,Time INTEGER, Value REAL);",
-		"CREATE TABLE IF NOT EXISTS AgentExit (SimId BLOB,AgentId INTEGER,ExitTime INTEGER);",
-		"CREATE TABLE IF NOT EXISTS Compositions (SimId BLOB,QualId INTEGER,NucId INTEGER, MassFrac REAL);",
-		"CREATE TABLE IF NOT EXISTS Products (SimId BLOB,QualId INTEGER,Quality TEXT);",
-		"CREATE TABLE IF NOT EXISTS Resources (SimId INTEGER,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
-		"CREATE TABLE IF NOT EXISTS ResCreators (SimId INTEGER,ResourceId INTEGER,AgentId INTEGER);",
-		"CREATE TABLE IF NOT EXISTS Agents (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER,ExitTime INTEGER);",
-		"CREATE TABLE IF NOT EXISTS Inventories (SimId BLOB,ResourceId INTEGER,AgentId INTEGER,StartTime INTEGER,EndTime INTEGER,QualId INTEGER,Quantity REAL);",
-		"CREATE TABLE IF NOT EXISTS TimeList (SimId BLOB, Time INTEGER);",
-		"CREATE TABLE IF NOT EXISTS Transactions (SimId BLOB, TransactionId INTEGER, SenderId INTEGER, ReceiverId INTEGER, ResourceId INTEGER, Commodity TEXT, Time INTEGER);",
-		query.Index("TimeSeriesPower", "SimId", "AgentId", "Time", "Value"),
-		query.Index("TimeList", "Time"),
-		query.Index("TimeList", "SimId", "Time"),
-		query.Index("Resources", "SimId", "ResourceId", "QualId"),
-		query.Index("Compositions", "SimId", "QualId", "NucId"),
-		query.Index("Transactions", "SimId", "ResourceId"),
-		query.Index("Transactions", "TransactionId"),
-		query.Index("ResCreators", "SimId", "ResourceId"),
-	}
-	postExecStmts = []string{
-		query.Index("Agents", "SimId", "Prototype"),
-		query.Index("Agents", "SimId", "AgentId", "Prototype"),
-		query.Index("Inventories", "SimId", "AgentId", "StartTime", "EndTime", "Quantity"),
-		query.Index("Inventories", "SimId", "ResourceId", "StartTime"),
-		query.Index("Inventories", "SimId", "StartTime", "EndTime", "ResourceId", "Quantity"),
-		"ANALYZE;",
-	}
-	dumpSql    = "INSERT INTO Inventories VALUES (?,?,?,?,?,?,?);"
-	resSqlHead = "SELECT ResourceId,TimeCreated,QualId,Quantity FROM "
-	resSqlTail = " WHERE Parent1 = ? OR Parent2 = ?;"
-
-	ownerSql = `SELECT tr.ReceiverId, tr.Time FROM Transactions AS tr
-				  WHERE tr.ResourceId = ? AND tr.SimId = ?
-				  ORDER BY tr.Time ASC;`
-	rootsSql = `SELECT res.ResourceId,res.TimeCreated,rc.AgentId,res.QualId,Quantity FROM Resources AS res
-				  INNER JOIN ResCreators AS rc ON res.ResourceId = rc.ResourceId
-				  WHERE res.SimId = ? AND rc.SimId = ?;`
+		"PRAGMA main.journal_mode = OFF;",
+	}
+	// requiredTables must exist in the raw cyclus database for post
+	// processing to be possible.
+	requiredTables = []string{"Info", "AgentEntry"}
+	// postTables holds creation statements for the tables built by post
+	// processing.
+	postTables = map[string]string{
+		"Agents":        "CREATE TABLE IF NOT EXISTS Agents (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER,ExitTime INTEGER);",
+		"Inventories":   "CREATE TABLE IF NOT EXISTS Inventories (SimId BLOB,ResourceId INTEGER,AgentId INTEGER,StartTime INTEGER,EndTime INTEGER,QualId INTEGER,Quantity REAL);",
+		"TimeList":      "CREATE TABLE IF NOT EXISTS TimeList (SimId BLOB,Time INTEGER,Year INTEGER,Month INTEGER,Date TEXT,DecYear REAL,Months REAL);",
+		"CyanPostInfo":  "CREATE TABLE IF NOT EXISTS CyanPostInfo (SimId BLOB,SchemaVersion INTEGER,CyanVersion TEXT,Status TEXT,Time TEXT);",
+		"CyanPostState": "CREATE TABLE IF NOT EXISTS CyanPostState (SimId BLOB,MaxResourceId INTEGER,MaxTransactionId INTEGER,MaxTime INTEGER);",
+		"CyanPostOpen":  "CREATE TABLE IF NOT EXISTS CyanPostOpen (SimId BLOB,ResourceId INTEGER,OwnerId INTEGER);",
+	}
+	// changedTables maps post tables whose layout changed to a column added
+	// by the change.  Prepare drops (and recreates) tables without the
+	// column - the SchemaVersion bump that accompanies such changes causes
+	// every simulation they held to be re-processed.
+	changedTables = map[string]string{
+		"TimeList": "Date",
+	}
+	// rawTables holds creation statements for cyclus tables that are
+	// required by post processing but that may be missing from the raw
+	// database if they received no data.
+	rawTables = map[string]string{
+		"TimeSeriesPower": "CREATE TABLE TimeSeriesPower (SimId BLOB,AgentId INTEGER,Time INTEGER, Value REAL);",
+		"AgentExit":       "CREATE TABLE AgentExit (SimId BLOB,AgentId INTEGER,ExitTime INTEGER);",
+		"Compositions":    "CREATE TABLE Compositions (SimId BLOB,QualId INTEGER,NucId INTEGER, MassFrac REAL);",
+		"Products":        "CREATE TABLE Products (SimId BLOB,QualId INTEGER,Quality TEXT);",
+		"Resources":       "CREATE TABLE Resources (SimId INTEGER,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
+		"ResCreators":     "CREATE TABLE ResCreators (SimId INTEGER,ResourceId INTEGER,AgentId INTEGER);",
+		"Transactions":    "CREATE TABLE Transactions (SimId BLOB, TransactionId INTEGER, SenderId INTEGER, ReceiverId INTEGER, ResourceId INTEGER, Commodity TEXT, Time INTEGER);",
+	}
+	// rawColumns lists the columns post processing reads from each raw
+	// cyclus table.
+	rawColumns = map[string][]string{
+		"Info":         {"SimId", "Duration"},
+		"AgentEntry":   {"SimId", "AgentId", "Kind", "Spec", "Prototype", "ParentId", "Lifetime", "EnterTime"},
+		"AgentExit":    {"SimId", "AgentId", "ExitTime"},
+		"Resources":    {"SimId", "ResourceId", "TimeCreated", "Parent1", "Parent2", "QualId", "Quantity"},
+		"ResCreators":  {"SimId", "ResourceId", "AgentId"},
+		"Transactions": {"SimId", "TransactionId", "ResourceId", "ReceiverId", "Time"},
+	}
+	// Indexes are given as a table name followed by the indexed columns.
+	preIndexes = [][]string{
+		{"TimeList", "Time"},
+		{"TimeList", "SimId", "Time"},
+	}
+	// rawIndexes index raw cyclus tables.  They are skipped for sidecar
+	// connections where the raw database is read-only.
+	rawIndexes = [][]string{
+		{"TimeSeriesPower", "SimId", "AgentId", "Time", "Value"},
+		{"Resources", "SimId", "ResourceId", "QualId"},
+		{"Compositions", "SimId", "QualId", "NucId"},
+		{"Products", "SimId", "QualId", "Quality"},
+		{"Transactions", "SimId", "ResourceId"},
+		{"Transactions", "TransactionId"},
+		{"ResCreators", "SimId", "ResourceId"},
+	}
+	postIndexes = [][]string{
+		{"Agents", "SimId", "Prototype"},
+		{"Agents", "SimId", "AgentId", "Prototype"},
+		{"Inventories", "SimId", "AgentId", "StartTime", "EndTime", "Quantity"},
+		{"Inventories", "SimId", "ResourceId", "StartTime"},
+		{"Inventories", "SimId", "StartTime", "EndTime", "ResourceId", "Quantity"},
+	}
+	dumpSql = "INSERT INTO Inventories VALUES (?,?,?,?,?,?,?);"
 )
 
-func Process(db *sql.DB) (simids [][]byte, err error) {
-	err = Prepare(db)
+// Process post processes every simulation in db one at a time.  It is
+// equivalent to running a Processor with Jobs set to 1.
+func Process(ctx context.Context, db *sql.DB) (simids [][]byte, err error) {
+	p := &Processor{DB: db, Jobs: 1}
+	return p.Run(ctx)
+}
+
+// Processor post processes all the simulations in a cyclus database.
+type Processor struct {
+	DB *sql.DB
+	// Jobs is the maximum number of simulations walked concurrently.  Reads
+	// happen in parallel while all database writes are serialized through a
+	// single writer goroutine.  Values less than 1 are treated as 1.
+	Jobs int
+	// Log receives progress messages from every walk.  Nil discards them.
+	Log *log.Logger
+	// Force causes every simulation to be re-processed even if up-to-date
+	// post processing output already exists for it.
+	Force bool
+	// Progress, if not nil, receives progress reports from every walk.  It
+	// may be called concurrently when Jobs is greater than 1.
+	Progress func(Progress)
+	// Incremental causes simulations that are still running to be extended
+	// with new data rather than re-processed from scratch (see
+	// Context.Incremental).
+	Incremental bool
+	// IdleLimit, if positive, makes incremental runs treat a simulation as
+	// finished once IdleLimit consecutive runs of the processor found no new
+	// data for it.  Databases without a record of cyclus finishing (e.g.
+	// written by cyclus versions without the Finish table or by runs that
+	// died) otherwise stay partial forever.
+	IdleLimit int
+	idle      map[string]*idleState
+}
+
+// Run prepares the database, walks every simulation id that does not
+// already have up-to-date post processing output and finishes the database
+// if any walks occurred.  Simulations that fail are left unprocessed; the
+// first such failure is returned as a *SimErr after all other simulations
+// have been walked.  If ctx is cancelled, no further simulations are
+// started, walks in progress are aborted and their partial output removed.
+func (p *Processor) Run(ctx context.Context) (simids [][]byte, err error) {
+	err = Prepare(p.DB)
 	if err != nil {
 		return nil, err
 	}
 
-	simids, err = GetSimIds(db)
+	simids, err = GetSimIds(p.DB)
 	if err != nil {
 		return nil, err
 	}
 
-	nprocessed := 0
+	if p.Incremental && p.idle == nil {
+		p.idle = map[string]*idleState{}
+	}
 	for _, id := range simids {
-		ctx := NewContext(db, id)
-		if err2 := ctx.WalkAll(); err2 != nil {
-			if IsAlreadyPostErr(err2) {
-			} else {
-				err = err2
+		if p.Incremental && p.idle[string(id)] == nil {
+			p.idle[string(id)] = &idleState{}
+		}
+	}
+
+	njobs := p.Jobs
+	if njobs < 1 {
+		njobs = 1
+	}
+	var wr *writer
+	if njobs > 1 {
+		wr = newWriter(p.DB)
+		defer wr.Close()
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	nprocessed := 0
+	ids := make(chan []byte)
+	for i := 0; i < njobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				c := NewContext(p.DB, id)
+				c.wr = wr
+				c.Force = p.Force
+				c.Incremental = p.Incremental
+				c.Progress = p.Progress
+				c.idle, c.idleLimit = p.idle[string(id)], p.IdleLimit
+				if p.Log != nil {
+					c.Log = p.Log
+				}
+
+				err2 := c.WalkAll(ctx)
+				mu.Lock()
+				if err2 == nil {
+					nprocessed++
+				} else if !IsAlreadyPostErr(err2) && err == nil {
+					err = err2
+				}
+				mu.Unlock()
 			}
-		} else {
-			nprocessed++
+		}()
+	}
+dispatch:
+	for _, id := range simids {
+		select {
+		case ids <- id:
+		case <-ctx.Done():
+			break dispatch
 		}
 	}
+	close(ids)
+	wg.Wait()
+	if ctx.Err() != nil && err == nil {
+		err = ctx.Err()
+	}
+
 	if nprocessed > 0 {
-		Finish(db)
+		if err2 := Finish(p.DB); err2 != nil && err == nil {
+			err = err2
+		}
 	}
-	return simids, nil
+	return simids, err
 }
 
 // Prepare creates necessary indexes and tables required for efficient
 // calculation of cyclus simulation inventory information.  Should be called
-// once before walking begins.
+// once before walking begins.  If db is a sidecar connection (see
+// IsSidecar), all tables are created in the sidecar and the raw database is
+// left untouched.  A MissingTableErr is returned if the database lacks
+// tables without which post processing is impossible.
 func Prepare(db *sql.DB) (err error) {
-	for _, s := range preExecStmts {
+	sidecar, err := IsSidecar(db)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range requiredTables {
+		if ok, err := tableExists(db, sidecar, name); err != nil {
+			return err
+		} else if !ok {
+			return MissingTableErr(name)
+		}
+	}
+
+	for _, s := range pragmas {
 		if _, err := db.Exec(s); err != nil {
-			log.Println("    ", err)
+			return err
 		}
 	}
-	return nil
+	for name, col := range changedTables {
+		if err := dropChanged(db, name, col); err != nil {
+			return err
+		}
+	}
+	for name, s := range postTables {
+		if _, err := db.Exec(s); err != nil {
+			return tableErr(name, "create", err)
+		}
+	}
+	for name, s := range rawTables {
+		if ok, err := tableExists(db, sidecar, name); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+		if _, err := db.Exec(s); err != nil {
+			return tableErr(name, "create", err)
+		}
+	}
+
+	indexes := preIndexes
+	if !sidecar {
+		indexes = append(indexes, rawIndexes...)
+	}
+	return createIndexes(db, indexes)
 }
 
 // Finish should be called for a cyclus database after all walkers have
 // completed processing inventory data. It creates final indexes and other
 // finishing tasks.
 func Finish(db *sql.DB) (err error) {
-	for _, s := range postExecStmts {
-		if _, err := db.Exec(s); err != nil {
-			return err
+	if err := createIndexes(db, postIndexes); err != nil {
+		return err
+	}
+	_, err = db.Exec("ANALYZE main;")
+	return err
+}
+
+// dropChanged drops the post table name from the main database if it
+// exists without column col.
+func dropChanged(db *sql.DB, name, col string) error {
+	n := 0
+	err := db.QueryRow("SELECT COUNT(*) FROM main.sqlite_master WHERE type='table' AND name=?;", name).Scan(&n)
+	if err != nil {
+		return tableErr(name, "query", err)
+	} else if n == 0 {
+		return nil
+	}
+
+	rows, err := db.Query("SELECT * FROM main." + name + " LIMIT 0;")
+	if err != nil {
+		return tableErr(name, "query", err)
+	}
+	cols, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return tableErr(name, "query", err)
+	}
+	for _, c := range cols {
+		if c == col {
+			return nil
+		}
+	}
+	_, err = db.Exec("DROP TABLE main." + name + ";")
+	return tableErr(name, "drop", err)
+}
+
+// checkSchema returns a MissingTableErr or *SchemaErr if a raw cyclus table
+// that post processing reads is missing or lacks one of rawColumns.
+func checkSchema(db *sql.DB) error {
+	for name, want := range rawColumns {
+		have, err := columns(db, name)
+		if err != nil {
+			return tableErr(name, "query", err)
+		} else if len(have) == 0 {
+			return MissingTableErr(name)
+		}
+		for _, col := range want {
+			if !have[strings.ToLower(col)] {
+				return &SchemaErr{Table: name, Err: fmt.Errorf("no column named %v", col)}
+			}
+		}
+	}
+	return nil
+}
+
+func createIndexes(db *sql.DB, indexes [][]string) error {
+	for _, idx := range indexes {
+		if _, err := db.Exec(query.Index(idx[0], idx[1:]...)); err != nil {
+			return tableErr(idx[0], "index", err)
 		}
 	}
 	return nil
@@ -123,15 +350,52 @@ type Context struct {
 	*sql.DB
 	// Simid is the cyclus simulation id targeted by this context.  Must be
 	// set.
-	Simid       []byte
-	Log         *log.Logger
-	mappednodes map[int32]struct{}
-	tmpResTbl   string
-	tmpResStmt  *sql.Stmt
-	dumpStmt    *sql.Stmt
-	ownerStmt   *sql.Stmt
-	resCount    int
-	nodes       []*Node
+	Simid []byte
+	Log   *log.Logger
+	// Timer accumulates time spent loading, walking and dumping resources.
+	Timer *Timer
+	// Force causes the simulation to be re-processed even if up-to-date post
+	// processing output already exists for it.
+	Force bool
+	// Progress, if not nil, is called after each root resource is walked and
+	// after each batch of inventory rows is written.
+	Progress func(Progress)
+	// Incremental enables walking a simulation that cyclus is still writing.
+	// Output for an unfinished simulation is recorded with StatusPartial and
+	// later incremental walks extend it with only the data added since: the
+	// resources and transactions with higher ids than any walked before and
+	// the resources left open (i.e. without children) by the last walk.
+	Incremental bool
+	graph       *resGraph
+	mapped      []bool
+	// open holds the resources left open by the walk in walk order.
+	open      []openRes
+	finished  bool
+	idle      *idleState
+	idleLimit int
+	dumpStmt  *sql.Stmt
+	// wr serializes writes when walking concurrently with other contexts.
+	// Nil means writes are made directly.
+	wr        *writer
+	resCount  int
+	rowCount  int
+	rootCount int
+	nodes     []*Node
+}
+
+// Progress reports how far the walk of a single simulation has advanced.
+type Progress struct {
+	Simid []byte
+	// Roots is the number of root resources (those created from scratch by
+	// an agent) walked so far out of TotalRoots.
+	Roots, TotalRoots int
+	// Resources is the number of resources walked so far out of
+	// TotalResources.  Resources unreachable from any root are never walked.
+	Resources, TotalResources int
+	// Rows is the number of inventory rows written so far.
+	Rows int
+	// Done is true for the final report of a successful walk.
+	Done bool
 }
 
 func NewContext(db *sql.DB, simid []byte) *Context {
@@ -139,246 +403,394 @@ func NewContext(db *sql.DB, simid []byte) *Context {
 		DB:    db,
 		Simid: simid,
 		Log:   log.New(NullWriter{}, "", 0),
+		Timer: NewTimer(),
 	}
 }
 
-type AlreadyPostErr []byte
-
-func (s AlreadyPostErr) Error() string {
-	return fmt.Sprintf("SimId %x is already post processed", []byte(s))
+// read runs fn, which may only read from the database.  When walking
+// concurrently with other contexts it is prevented from overlapping with
+// writes.
+func (c *Context) read(fn func() error) error {
+	if c.wr != nil {
+		c.wr.mu.RLock()
+		defer c.wr.mu.RUnlock()
+	}
+	return fn()
 }
 
-func IsAlreadyPostErr(err error) bool {
-	_, ok := err.(AlreadyPostErr)
-	return ok
+// write runs fn inside a database transaction - serialized with the writes
+// of all other contexts when walking concurrently.
+func (c *Context) write(fn func(tx *sql.Tx) error) error {
+	if c.wr != nil {
+		return c.wr.Write(fn)
+	}
+	return execTx(c.DB, fn)
 }
 
-func (c *Context) init() {
-	// skip if the post processing already exists for this simid in the db
-	dummy := 0
-	err := c.QueryRow("SELECT AgentId FROM Agents WHERE SimId = ? LIMIT 1", c.Simid).Scan(&dummy)
-	if err == nil {
-		panic(AlreadyPostErr(c.Simid))
-	} else if err != sql.ErrNoRows {
-		panicif(err)
-	}
-
-	tx, err := c.Begin()
-	panicif(err)
-
-	// build Agents table
-	sql := `INSERT INTO Agents
-				SELECT n.SimId,n.AgentId,n.Kind,n.Spec,n.Prototype,n.ParentId,n.Lifetime,n.EnterTime,x.ExitTime
-				FROM
-					AgentEntry AS n
-					LEFT JOIN AgentExit AS x ON n.AgentId = x.AgentId AND n.SimId = x.SimId
-					WHERE n.SimId = ?;`
-	_, err = tx.Exec(sql, c.Simid)
-	panicif(err)
+// init checks whether simid has already been post processed and loads the
+// resource graph.  It then either removes any existing output for simid or,
+// when extending a partial walk incrementally, the open inventory intervals
+// that will be rewritten.  Finally it (re)builds the TimeList table.
+func (c *Context) init() error {
+	// skip if up-to-date post processing already exists for this simid in
+	// the db
+	var info *PostInfo
+	var state postState
+	err := c.read(func() (err error) {
+		if err = checkSchema(c.DB); err != nil {
+			return err
+		} else if info, err = Info(c.DB, c.Simid); err != nil {
+			return err
+		} else if state, err = loadState(c.DB, c.Simid); err != nil {
+			return err
+		}
+		c.finished, err = c.simFinished()
+		return err
+	})
+	if err != nil {
+		return err
+	} else if info != nil && info.Current() && !c.Force {
+		return AlreadyPostErr(c.Simid)
+	}
+	extend := c.Incremental && !c.Force && info != nil &&
+		info.Status == StatusPartial && info.SchemaVersion == SchemaVersion
+	if extend {
+		c.Log.Printf("Extending partial output (processed through time %v)\n", state.MaxTime)
+	} else if info != nil {
+		c.Log.Printf("Re-processing (schema version %v, status %v)\n", info.SchemaVersion, info.Status)
+	}
 
 	c.nodes = make([]*Node, 0, 10000)
-	c.mappednodes = map[int32]struct{}{}
 
-	// build TimeList table
-	sql = "SELECT Duration FROM Info WHERE SimId = ?;"
-	rows, err := tx.Query(sql, c.Simid)
-	panicif(err)
-	defer rows.Close()
-	for rows.Next() {
-		var dur int
-		panicif(rows.Scan(&dur))
-		for i := 0; i < dur; i++ {
-			_, err := tx.Exec("INSERT INTO TimeList VALUES (?, ?);", c.Simid, i)
-			panicif(err)
+	c.Log.Println("Loading resource graph...")
+	c.Timer.Start("load")
+	err = c.read(func() (err error) {
+		if extend {
+			c.graph, err = loadNew(c.DB, c.Simid, state)
+			if err != errRewalk {
+				return err
+			}
+			c.Log.Println("Re-processing (new data changes resources closed by the last walk)")
+			extend = false
 		}
+		c.graph, err = loadGraph(c.DB, c.Simid, nil, nil)
+		return err
+	})
+	c.Timer.Stop("load")
+	if err != nil {
+		return err
 	}
-	panicif(rows.Err())
-
-	// create temp res table without simid
-	c.Log.Println("Creating temporary resource table...")
-	c.tmpResTbl = "tmp_restbl_" + fmt.Sprintf("%x", c.Simid)
-	_, err = tx.Exec("DROP TABLE IF EXISTS " + c.tmpResTbl)
-	panicif(err)
-
-	sql = "CREATE TABLE " + c.tmpResTbl + " AS SELECT ResourceId,TimeCreated,Parent1,Parent2,QualId,Quantity FROM Resources WHERE SimId = ?;"
-	_, err = tx.Exec(sql, c.Simid)
-	panicif(err)
-
-	c.Log.Println("Indexing temporary resource table...")
-	_, err = tx.Exec(query.Index(c.tmpResTbl, "Parent1"))
-	panicif(err)
-
-	_, err = tx.Exec(query.Index(c.tmpResTbl, "Parent2"))
-	panicif(err)
+	c.mapped = make([]bool, len(c.graph.Res))
+	c.checkIdle()
+
+	err = c.write(func(tx *sql.Tx) error {
+		if extend {
+			// the open intervals are rewritten by the walk
+			_, err := tx.Exec("DELETE FROM Inventories WHERE SimId = ? AND EndTime = ?;", c.Simid, math.MaxInt32)
+			if err != nil {
+				return tableErr("Inventories", "delete", err)
+			}
+			_, err = tx.Exec(statusSql, StatusRunning, now(), c.Simid)
+			return tableErr("CyanPostInfo", "update", err)
+		}
 
-	tx.Commit()
+		if err := c.clear(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec(insertInfoSql, c.Simid, SchemaVersion, Version, StatusRunning, now())
+		return tableErr("CyanPostInfo", "insert", err)
+	})
+	if err != nil {
+		return err
+	}
+	err = c.write(c.buildTimeList)
+	if err != nil {
+		return err
+	}
 
 	// create prepared statements
-	c.tmpResStmt, err = c.Prepare(resSqlHead + c.tmpResTbl + resSqlTail)
-	panicif(err)
-
 	c.dumpStmt, err = c.Prepare(dumpSql)
-	panicif(err)
+	return tableErr("Inventories", "insert", err)
+}
+
+// buildTimeList extends the TimeList table to cover the simulation duration,
+// or only the time steps with data so far if the simulation is unfinished.
+func (c *Context) buildTimeList(tx *sql.Tx) error {
+	var dur int
+	err := tx.QueryRow("SELECT Duration FROM Info WHERE SimId = ?;", c.Simid).Scan(&dur)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return tableErr("Info", "query", err)
+	}
+	if !c.finished && c.graph.MaxTime+1 < dur {
+		dur = c.graph.MaxTime + 1
+	}
+	cal, err := loadCalendar(tx, c.Simid)
+	if err != nil {
+		return err
+	}
 
-	c.ownerStmt, err = c.Prepare(ownerSql)
-	panicif(err)
+	start := 0
+	err = tx.QueryRow("SELECT COALESCE(MAX(Time)+1, 0) FROM TimeList WHERE SimId = ?;", c.Simid).Scan(&start)
+	if err != nil {
+		return tableErr("TimeList", "query", err)
+	}
+	for i := start; i < dur; i++ {
+		date := cal.Date(i)
+		_, err := tx.Exec("INSERT INTO TimeList VALUES (?,?,?,?,?,?,?);", c.Simid, i,
+			date.Year(), int(date.Month()), date.Format("2006-01-02"), cal.DecYear(i), cal.Months(i))
+		if err != nil {
+			return tableErr("TimeList", "insert", err)
+		}
+	}
+	return nil
+}
+
+// clear removes all post processing output for the context's simid.
+func (c *Context) clear(tx *sql.Tx) error {
+	for _, tbl := range []string{"Inventories", "TimeList", "Agents", "CyanPostInfo", "CyanPostState", "CyanPostOpen"} {
+		if _, err := tx.Exec("DELETE FROM "+tbl+" WHERE SimId = ?;", c.Simid); err != nil {
+			return tableErr(tbl, "delete", err)
+		}
+	}
+	return nil
 }
 
 // WalkAll constructs the inventories table in the cyclus database alongside
 // other tables. Creates several indexes in the process.  Finish should be
 // called on the database connection after all simulation id's have been
-// walked.
-func (c *Context) WalkAll() (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if er, ok := r.(error); ok {
-				err = er
-			} else {
-				err = fmt.Errorf("%v", r)
-			}
-		}
-	}()
-
+// walked.  An AlreadyPostErr is returned if the simid's CyanPostInfo entry
+// shows a completed walk at the current SchemaVersion and Force is not set;
+// otherwise any existing output for the simid is replaced.  The Agents table
+// is populated and the entry marked complete in the same transaction as the
+// final inventories, so a simid only appears processed once its walk has
+// fully completed.  If the walk fails or ctx is cancelled, its partial
+// output is removed and the error is returned as a *SimErr.
+func (c *Context) WalkAll(ctx context.Context) (err error) {
 	c.Log.Printf("--- Building inventories for simid %x ---\n", c.Simid)
-	c.init()
-
-	c.Log.Println("Retrieving root resource nodes...")
-	roots := c.getRoots()
+	if err := c.init(); IsAlreadyPostErr(err) {
+		return err
+	} else if err != nil {
+		return c.fail(err)
+	}
+	defer c.dumpStmt.Close()
 
+	roots := c.graph.Roots
 	c.Log.Printf("Found %v root nodes\n", len(roots))
-	for i, n := range roots {
+	c.Timer.Start("walk")
+	for i, r := range roots {
+		if err := ctx.Err(); err != nil {
+			c.Timer.Stop("walk")
+			return c.fail(err)
+		}
 		c.Log.Printf("    Processing root %d...\n", i)
-		c.walkDown(n)
+		err := c.walkDown(ctx, &Node{
+			ResId:     c.graph.Res[r.Index].Id,
+			OwnerId:   r.AgentId,
+			StartTime: c.graph.Res[r.Index].Time,
+			EndTime:   math.MaxInt32,
+			QualId:    c.graph.Res[r.Index].QualId,
+			Quantity:  c.graph.Res[r.Index].Qty,
+		})
+		if err != nil {
+			c.Timer.Stop("walk")
+			return c.fail(err)
+		}
+		c.rootCount++
+		c.report(false)
 	}
+	c.Timer.Stop("walk")
 
-	c.Log.Println("Dropping temporary resource table...")
-	_, err = c.Exec("DROP TABLE " + c.tmpResTbl)
-	panicif(err)
+	err = c.write(func(tx *sql.Tx) error {
+		if err := c.dumpNodesTx(tx); err != nil {
+			return err
+		}
 
-	c.dumpNodes()
+		// build Agents table
+		if _, err := tx.Exec("DELETE FROM Agents WHERE SimId = ?;", c.Simid); err != nil {
+			return tableErr("Agents", "delete", err)
+		}
+		sql := `INSERT INTO Agents
+					SELECT n.SimId,n.AgentId,n.Kind,n.Spec,n.Prototype,n.ParentId,n.Lifetime,n.EnterTime,x.ExitTime
+					FROM
+						AgentEntry AS n
+						LEFT JOIN AgentExit AS x ON n.AgentId = x.AgentId AND n.SimId = x.SimId
+						WHERE n.SimId = ?;`
+		if _, err := tx.Exec(sql, c.Simid); err != nil {
+			return tableErr("Agents", "insert", err)
+		}
+		status := StatusComplete
+		if !c.finished {
+			status = StatusPartial
+		}
+		if _, err := tx.Exec(statusSql, status, now(), c.Simid); err != nil {
+			return tableErr("CyanPostInfo", "update", err)
+		} else if err := c.saveOpen(tx); err != nil {
+			return err
+		}
+		return saveState(tx, c.Simid, postState{
+			MaxResourceId:    c.graph.MaxSettledId,
+			MaxTransactionId: c.graph.MaxTransId,
+			MaxTime:          c.graph.MaxTime,
+		})
+	})
+	if err != nil {
+		return c.fail(err)
+	}
+	c.report(true)
+	c.graph = nil
+	c.mapped = nil
+	c.open = nil
 
+	c.Log.Printf("Timing: load=%v walk=%v dump=%v\n", c.Timer.Totals["load"], c.Timer.Totals["walk"], c.Timer.Totals["dump"])
 	return nil
 }
 
-func (c *Context) getRoots() (roots []*Node) {
-	sql := "SELECT COUNT(*) FROM ResCreators WHERE SimId = ?"
-	row := c.QueryRow(sql, c.Simid)
-
-	n := 0
-	err := row.Scan(&n)
-	panicif(err)
-
-	roots = make([]*Node, 0, n)
-	rows, err := c.Query(rootsSql, c.Simid, c.Simid)
-	panicif(err)
-	defer rows.Close()
-	for rows.Next() {
-		node := &Node{EndTime: math.MaxInt32}
-		err := rows.Scan(&node.ResId, &node.StartTime, &node.OwnerId, &node.QualId, &node.Quantity)
-		panicif(err)
-
-		roots = append(roots, node)
-	}
-	panicif(rows.Err())
-	return roots
+// report sends a progress report if the context has a Progress callback.
+func (c *Context) report(done bool) {
+	if c.Progress == nil {
+		return
+	}
+	c.Progress(Progress{
+		Simid:          c.Simid,
+		Roots:          c.rootCount,
+		TotalRoots:     len(c.graph.Roots),
+		Resources:      c.resCount,
+		TotalResources: len(c.graph.Res),
+		Rows:           c.rowCount,
+		Done:           done,
+	})
 }
 
-func (c *Context) walkDown(node *Node) {
-	if _, ok := c.mappednodes[int32(node.ResId)]; ok {
-		return
+// fail removes any partial output for the context's simid and returns err
+// wrapped in a *SimErr.
+func (c *Context) fail(err error) error {
+	if err2 := c.write(c.clear); err2 != nil {
+		c.Log.Printf("failed to remove partial output for simid %x: %v\n", c.Simid, err2)
 	}
-	c.mappednodes[int32(node.ResId)] = struct{}{}
-
-	// dump if necessary
-	c.resCount++
-	if c.resCount%DumpFreq == 0 {
-		c.dumpNodes()
-	}
-
-	// find resource's children
-	kids := make([]*Node, 0, 2)
-	func() { // this helps keep the stack size reasonable despite heavy recursion
-		rows, err := c.tmpResStmt.Query(node.ResId, node.ResId)
-		panicif(err)
-		defer rows.Close()
-
-		for rows.Next() {
-			child := &Node{EndTime: math.MaxInt32}
-			err := rows.Scan(&child.ResId, &child.StartTime, &child.QualId, &child.Quantity)
-			panicif(err)
-			node.EndTime = child.StartTime
-			kids = append(kids, child)
-		}
-		panicif(rows.Err())
-	}()
-
-	// find resources owner changes (that occurred before children)
-	owners, times := c.getNewOwners(node.OwnerId, node.ResId)
-
-	childOwner := node.OwnerId
-	if len(owners) > 0 {
-		node.EndTime = times[0]
-		childOwner = owners[len(owners)-1]
-
-		lastend := math.MaxInt32
-		if len(kids) > 0 {
-			lastend = kids[0].StartTime
-		}
-		times = append(times, lastend)
-		for i := range owners {
-			n := &Node{ResId: node.ResId,
-				OwnerId:   owners[i],
-				StartTime: times[i],
-				EndTime:   times[i+1],
-				QualId:    node.QualId,
-				Quantity:  node.Quantity,
+	c.graph = nil
+	c.mapped = nil
+	c.open = nil
+	return &SimErr{Simid: c.Simid, Err: err}
+}
+
+// walkDown computes inventory intervals for the resource node and all its
+// descendants not yet walked.  Descendants are visited depth-first using an
+// explicit stack in the same order a recursive walk would visit them.
+func (c *Context) walkDown(ctx context.Context, root *Node) error {
+	stack := []*Node{root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		i := c.graph.index[node.ResId]
+		if c.mapped[i] {
+			continue
+		}
+		c.mapped[i] = true
+		res := &c.graph.Res[i]
+
+		// dump if necessary
+		c.resCount++
+		if c.resCount%cancelFreq == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
 			}
-			c.nodes = append(c.nodes, n)
 		}
-	}
+		if c.resCount%DumpFreq == 0 {
+			if err := c.dumpNodes(); err != nil {
+				return err
+			}
+			c.report(false)
+		}
 
-	c.nodes = append(c.nodes, node)
+		// find resource's children
+		kids := make([]*Node, len(res.Kids))
+		for k, j := range res.Kids {
+			kid := &c.graph.Res[j]
+			kids[k] = &Node{
+				ResId:     kid.Id,
+				StartTime: kid.Time,
+				EndTime:   math.MaxInt32,
+				QualId:    kid.QualId,
+				Quantity:  kid.Qty,
+			}
+			node.EndTime = kid.Time
+		}
 
-	// walk down resource's children
-	for _, child := range kids {
-		child.OwnerId = childOwner
-		c.walkDown(child)
-	}
-}
+		// find resources owner changes (that occurred before children) -
+		// ignoring those to the owner it inherited
+		inherited := node.OwnerId
+		if owner, ok := c.graph.inherited[i]; ok {
+			inherited = owner
+		}
+		if len(kids) == 0 && !c.finished {
+			c.open = append(c.open, openRes{resource: resource{Id: res.Id}, Inherited: inherited})
+		}
+		var owners, times []int
+		for _, oc := range res.Owners {
+			if oc.Owner != inherited {
+				owners = append(owners, oc.Owner)
+				times = append(times, oc.Time)
+			}
+		}
 
-func (c *Context) getNewOwners(currowner, id int) (owners, times []int) {
-	var owner, t int
-	rows, err := c.ownerStmt.Query(id, c.Simid)
-	panicif(err)
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&owner, &t)
-		panicif(err)
+		childOwner := node.OwnerId
+		if len(owners) > 0 {
+			node.EndTime = times[0]
+			childOwner = owners[len(owners)-1]
 
-		if currowner == owner {
-			continue
+			lastend := math.MaxInt32
+			if len(kids) > 0 {
+				lastend = kids[0].StartTime
+			}
+			times = append(times, lastend)
+			for i := range owners {
+				n := &Node{ResId: node.ResId,
+					OwnerId:   owners[i],
+					StartTime: times[i],
+					EndTime:   times[i+1],
+					QualId:    node.QualId,
+					Quantity:  node.Quantity,
+				}
+				c.nodes = append(c.nodes, n)
+			}
+		}
+
+		c.nodes = append(c.nodes, node)
+
+		// queue resource's children - in reverse so the first child is walked
+		// first
+		for k := len(kids) - 1; k >= 0; k-- {
+			kids[k].OwnerId = childOwner
+			stack = append(stack, kids[k])
 		}
-		owners = append(owners, owner)
-		times = append(times, t)
 	}
-	panicif(rows.Err())
-	return owners, times
+	return nil
+}
+
+func (c *Context) dumpNodes() error {
+	return c.write(c.dumpNodesTx)
 }
 
-func (c *Context) dumpNodes() {
+// dumpNodesTx writes all buffered inventory nodes to the database as part of
+// tx.
+func (c *Context) dumpNodesTx(tx *sql.Tx) error {
+	c.Timer.Start("dump")
+	defer c.Timer.Stop("dump")
+
 	c.Log.Printf("    Dumping inventories (%d resources done)...\n", c.resCount)
-	tx, err := c.Begin()
-	panicif(err)
 	stmt := tx.Stmt(c.dumpStmt)
-
 	for _, n := range c.nodes {
 		if n.EndTime > n.StartTime {
-			_, err = stmt.Exec(c.Simid, n.ResId, n.OwnerId, n.StartTime, n.EndTime, n.QualId, n.Quantity)
-			panicif(err)
+			_, err := stmt.Exec(c.Simid, n.ResId, n.OwnerId, n.StartTime, n.EndTime, n.QualId, n.Quantity)
+			if err != nil {
+				return tableErr("Inventories", "insert", err)
+			}
+			c.rowCount++
 		}
 	}
-
-	err = tx.Commit()
-	panicif(err)
 	c.nodes = c.nodes[:0]
+	return nil
 }
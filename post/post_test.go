@@ -0,0 +1,1277 @@
+package post
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/query"
+	"github.com/rwcarlsen/go-sqlite3"
+)
+
+type resRow struct {
+	Id, Time int
+	Qty      float64
+	Qual     int
+	P1, P2   int
+}
+
+type transRow struct {
+	Id, Sender, Receiver, Res, Time int
+}
+
+type creatorRow struct {
+	Res, Agent int
+}
+
+type invRow struct {
+	ResId, AgentId, Start, End, QualId int
+	Qty                                float64
+}
+
+// fixture holds the raw cyclus table content of a single simulation.
+type fixture struct {
+	Duration  int
+	Agents    []int
+	Resources []resRow
+	Creators  []creatorRow
+	Trans     []transRow
+}
+
+var fixtureTables = []string{
+	"CREATE TABLE IF NOT EXISTS Info (SimId BLOB,Duration INTEGER);",
+	"CREATE TABLE IF NOT EXISTS AgentEntry (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER);",
+	"CREATE TABLE IF NOT EXISTS AgentExit (SimId BLOB,AgentId INTEGER,ExitTime INTEGER);",
+	"CREATE TABLE IF NOT EXISTS Resources (SimId BLOB,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
+	"CREATE TABLE IF NOT EXISTS ResCreators (SimId BLOB,ResourceId INTEGER,AgentId INTEGER);",
+	"CREATE TABLE IF NOT EXISTS Transactions (SimId BLOB,TransactionId INTEGER,SenderId INTEGER,ReceiverId INTEGER,ResourceId INTEGER,Commodity TEXT,Time INTEGER);",
+}
+
+func (f *fixture) build(db *sql.DB, simid []byte) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range fixtureTables {
+		if _, err := tx.Exec(s); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO Info VALUES (?,?);", simid, f.Duration); err != nil {
+		return err
+	}
+	for _, id := range f.Agents {
+		proto := fmt.Sprintf("proto%v", id)
+		_, err := tx.Exec("INSERT INTO AgentEntry VALUES (?,?,'Facility',':agents:Sink',?,-1,-1,0);", simid, id, proto)
+		if err != nil {
+			return err
+		}
+	}
+	if err := f.insertRows(tx, simid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertRows inserts the fixture's resources, creators and transactions.
+func (f *fixture) insertRows(tx *sql.Tx, simid []byte) error {
+	for _, r := range f.Resources {
+		_, err := tx.Exec("INSERT INTO Resources VALUES (?,?,?,'Material',?,?,'kg',?,?,?);",
+			simid, r.Id, r.Id, r.Time, r.Qty, r.Qual, r.P1, r.P2)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range f.Creators {
+		if _, err := tx.Exec("INSERT INTO ResCreators VALUES (?,?,?);", simid, c.Res, c.Agent); err != nil {
+			return err
+		}
+	}
+	for _, tr := range f.Trans {
+		_, err := tx.Exec("INSERT INTO Transactions VALUES (?,?,?,?,?,'commod',?);",
+			simid, tr.Id, tr.Sender, tr.Receiver, tr.Res, tr.Time)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// until returns the part of the fixture cyclus would have written by the
+// time the given time steps were reached for resources, resource creators
+// and transactions.  Differing times emulate the independent buffering of
+// each table.
+func (f *fixture) until(tres, tcreate, ttrans int) *fixture {
+	sub := &fixture{Duration: f.Duration, Agents: f.Agents}
+	times := map[int]int{}
+	for _, r := range f.Resources {
+		times[r.Id] = r.Time
+		if r.Time <= tres {
+			sub.Resources = append(sub.Resources, r)
+		}
+	}
+	for _, c := range f.Creators {
+		if times[c.Res] <= tcreate {
+			sub.Creators = append(sub.Creators, c)
+		}
+	}
+	for _, tr := range f.Trans {
+		if tr.Time <= ttrans {
+			sub.Trans = append(sub.Trans, tr)
+		}
+	}
+	return sub
+}
+
+// minus returns the rows of f not present in prev.
+func (f *fixture) minus(prev *fixture) *fixture {
+	res, creators, trans := map[int]bool{}, map[int]bool{}, map[int]bool{}
+	for _, r := range prev.Resources {
+		res[r.Id] = true
+	}
+	for _, c := range prev.Creators {
+		creators[c.Res] = true
+	}
+	for _, tr := range prev.Trans {
+		trans[tr.Id] = true
+	}
+
+	diff := &fixture{Duration: f.Duration, Agents: f.Agents}
+	for _, r := range f.Resources {
+		if !res[r.Id] {
+			diff.Resources = append(diff.Resources, r)
+		}
+	}
+	for _, c := range f.Creators {
+		if !creators[c.Res] {
+			diff.Creators = append(diff.Creators, c)
+		}
+	}
+	for _, tr := range f.Trans {
+		if !trans[tr.Id] {
+			diff.Trans = append(diff.Trans, tr)
+		}
+	}
+	return diff
+}
+
+// randFixture generates a cyclus-like resource graph with random creates,
+// transfers, splits, transmutes and combines.
+func randFixture(seed int64, nagents, dur int) *fixture {
+	r := rand.New(rand.NewSource(seed))
+	f := &fixture{Duration: dur}
+	for i := 1; i <= nagents; i++ {
+		f.Agents = append(f.Agents, i)
+	}
+
+	type leaf struct {
+		id, owner int
+		qty       float64
+	}
+	var leaves []leaf
+	nextres, nexttrans := 1, 1
+	newres := func(t int, qty float64, p1, p2 int) int {
+		id := nextres
+		nextres++
+		f.Resources = append(f.Resources, resRow{Id: id, Time: t, Qty: qty, Qual: r.Intn(5) + 1, P1: p1, P2: p2})
+		return id
+	}
+
+	for t := 0; t < dur; t++ {
+		for n := r.Intn(3); n > 0; n-- {
+			owner := r.Intn(nagents) + 1
+			qty := float64(r.Intn(100) + 1)
+			id := newres(t, qty, 0, 0)
+			f.Creators = append(f.Creators, creatorRow{Res: id, Agent: owner})
+			leaves = append(leaves, leaf{id, owner, qty})
+		}
+
+		for round := 0; round < 2; round++ {
+			curr := leaves
+			leaves = nil
+			used := make([]bool, len(curr))
+			for i, l := range curr {
+				if used[i] {
+					continue
+				}
+				used[i] = true
+
+				switch r.Intn(8) {
+				case 0, 1: // transfer
+					to := r.Intn(nagents) + 1
+					f.Trans = append(f.Trans, transRow{Id: nexttrans, Sender: l.owner, Receiver: to, Res: l.id, Time: t})
+					nexttrans++
+					l.owner = to
+					leaves = append(leaves, l)
+				case 2: // split
+					q1 := l.qty * r.Float64()
+					id1 := newres(t, q1, l.id, 0)
+					id2 := newres(t, l.qty-q1, l.id, 0)
+					leaves = append(leaves, leaf{id1, l.owner, q1}, leaf{id2, l.owner, l.qty - q1})
+				case 3: // transmute
+					leaves = append(leaves, leaf{newres(t, l.qty, l.id, 0), l.owner, l.qty})
+				case 4: // combine with another leaf in the same agent
+					j := i + 1
+					for ; j < len(curr); j++ {
+						if !used[j] && curr[j].owner == l.owner {
+							break
+						}
+					}
+					if j == len(curr) {
+						leaves = append(leaves, l)
+						continue
+					}
+					used[j] = true
+					qty := l.qty + curr[j].qty
+					leaves = append(leaves, leaf{newres(t, qty, l.id, curr[j].id), l.owner, qty})
+				default:
+					leaves = append(leaves, l)
+				}
+			}
+		}
+	}
+	return f
+}
+
+// smallFixture exercises splits, transmutes, combines and multiple owner
+// changes for a handful of resources.
+var smallFixture = &fixture{
+	Duration: 10,
+	Agents:   []int{1, 2, 3, 4},
+	Resources: []resRow{
+		{Id: 1, Time: 0, Qty: 100, Qual: 1},
+		{Id: 2, Time: 1, Qty: 90, Qual: 1, P1: 1},
+		{Id: 3, Time: 1, Qty: 10, Qual: 1, P1: 1},
+		{Id: 4, Time: 2, Qty: 10, Qual: 2},
+		{Id: 5, Time: 2, Qty: 90, Qual: 3, P1: 2},
+		{Id: 6, Time: 5, Qty: 10, Qual: 3, P1: 4},
+		{Id: 7, Time: 7, Qty: 20, Qual: 3, P1: 6, P2: 8},
+		{Id: 8, Time: 6, Qty: 10, Qual: 3},
+	},
+	Creators: []creatorRow{{1, 1}, {4, 2}, {8, 3}},
+	Trans: []transRow{
+		{Id: 1, Sender: 1, Receiver: 2, Res: 2, Time: 1},
+		{Id: 2, Sender: 2, Receiver: 3, Res: 4, Time: 3},
+		{Id: 3, Sender: 3, Receiver: 4, Res: 6, Time: 6},
+		{Id: 4, Sender: 3, Receiver: 4, Res: 8, Time: 7},
+		{Id: 5, Sender: 2, Receiver: 1, Res: 5, Time: 4},
+		{Id: 6, Sender: 1, Receiver: 2, Res: 5, Time: 8},
+	},
+}
+
+func opendb(t *testing.T) (db *sql.DB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "cyan-post-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func inventories(db *sql.DB, simid []byte) (invs []invRow, err error) {
+	rows, err := db.Query("SELECT ResourceId,AgentId,StartTime,EndTime,QualId,Quantity FROM Inventories WHERE SimId = ? ORDER BY rowid;", simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := invRow{}
+		if err := rows.Scan(&r.ResId, &r.AgentId, &r.Start, &r.End, &r.QualId, &r.Qty); err != nil {
+			return nil, err
+		}
+		invs = append(invs, r)
+	}
+	return invs, rows.Err()
+}
+
+// refInventories computes inventory rows using the original recursive,
+// query-per-resource walker.  It serves as the reference the in-memory
+// walker must reproduce exactly.
+func refInventories(db *sql.DB, simid []byte) (invs []invRow, err error) {
+	tbl := fmt.Sprintf("ref_restbl_%x", simid)
+	if _, err := db.Exec("DROP TABLE IF EXISTS " + tbl); err != nil {
+		return nil, err
+	}
+	s := "CREATE TABLE " + tbl + " AS SELECT ResourceId,TimeCreated,Parent1,Parent2,QualId,Quantity FROM Resources WHERE SimId = ?;"
+	if _, err := db.Exec(s, simid); err != nil {
+		return nil, err
+	}
+	for _, s := range []string{query.Index(tbl, "Parent1"), query.Index(tbl, "Parent2")} {
+		if _, err := db.Exec(s); err != nil {
+			return nil, err
+		}
+	}
+	defer db.Exec("DROP TABLE " + tbl)
+
+	kidStmt, err := db.Prepare("SELECT ResourceId,TimeCreated,QualId,Quantity FROM " + tbl + " WHERE Parent1 = ? OR Parent2 = ?;")
+	if err != nil {
+		return nil, err
+	}
+	defer kidStmt.Close()
+	ownerStmt, err := db.Prepare("SELECT tr.ReceiverId, tr.Time FROM Transactions AS tr WHERE tr.ResourceId = ? AND tr.SimId = ? ORDER BY tr.Time ASC;")
+	if err != nil {
+		return nil, err
+	}
+	defer ownerStmt.Close()
+
+	var roots []*Node
+	rows, err := db.Query(`SELECT res.ResourceId,res.TimeCreated,rc.AgentId,res.QualId,Quantity FROM Resources AS res
+				  INNER JOIN ResCreators AS rc ON res.ResourceId = rc.ResourceId
+				  WHERE res.SimId = ? AND rc.SimId = ?;`, simid, simid)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		n := &Node{EndTime: math.MaxInt32}
+		if err := rows.Scan(&n.ResId, &n.StartTime, &n.OwnerId, &n.QualId, &n.Quantity); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		roots = append(roots, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var nodes []*Node
+	mapped := map[int]bool{}
+	var walk func(node *Node) error
+	walk = func(node *Node) error {
+		if mapped[node.ResId] {
+			return nil
+		}
+		mapped[node.ResId] = true
+
+		var kids []*Node
+		rows, err := kidStmt.Query(node.ResId, node.ResId)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			child := &Node{EndTime: math.MaxInt32}
+			if err := rows.Scan(&child.ResId, &child.StartTime, &child.QualId, &child.Quantity); err != nil {
+				rows.Close()
+				return err
+			}
+			node.EndTime = child.StartTime
+			kids = append(kids, child)
+		}
+		rows.Close()
+
+		var owners, times []int
+		rows, err = ownerStmt.Query(node.ResId, simid)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var owner, t int
+			if err := rows.Scan(&owner, &t); err != nil {
+				rows.Close()
+				return err
+			}
+			if owner != node.OwnerId {
+				owners = append(owners, owner)
+				times = append(times, t)
+			}
+		}
+		rows.Close()
+
+		childOwner := node.OwnerId
+		if len(owners) > 0 {
+			node.EndTime = times[0]
+			childOwner = owners[len(owners)-1]
+			lastend := math.MaxInt32
+			if len(kids) > 0 {
+				lastend = kids[0].StartTime
+			}
+			times = append(times, lastend)
+			for i := range owners {
+				nodes = append(nodes, &Node{ResId: node.ResId, OwnerId: owners[i], StartTime: times[i],
+					EndTime: times[i+1], QualId: node.QualId, Quantity: node.Quantity})
+			}
+		}
+		nodes = append(nodes, node)
+
+		for _, child := range kids {
+			child.OwnerId = childOwner
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, n := range roots {
+		if err := walk(n); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, n := range nodes {
+		if n.EndTime > n.StartTime {
+			invs = append(invs, invRow{n.ResId, n.OwnerId, n.StartTime, n.EndTime, n.QualId, n.Quantity})
+		}
+	}
+	return invs, nil
+}
+
+var walkcases = []struct {
+	Descrip string
+	Fix     *fixture
+}{
+	{"hand-built graph", smallFixture},
+	{"random graph, few agents", randFixture(1, 3, 20)},
+	{"random graph, many agents", randFixture(2, 10, 50)},
+	{"random graph, long sim", randFixture(3, 5, 150)},
+}
+
+func TestWalkAll(t *testing.T) {
+	for i, test := range walkcases {
+		simid := []byte(fmt.Sprintf("simid-%015d", i))
+		db, cleanup := opendb(t)
+
+		if err := test.Fix.build(db, simid); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		if err := Prepare(db); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		want, err := refInventories(db, simid)
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+
+		c := NewContext(db, simid)
+		if err := c.WalkAll(context.Background()); err != nil {
+			cleanup()
+			t.Fatalf("[%v] %v", test.Descrip, err)
+		}
+		got, err := inventories(db, simid)
+		cleanup()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != len(want) {
+			t.Errorf("[%v] got %v inventory rows, want %v", test.Descrip, len(got), len(want))
+			continue
+		}
+		for j := range want {
+			if got[j] != want[j] {
+				t.Errorf("[%v] row %v: got %+v, want %+v", test.Descrip, j, got[j], want[j])
+				break
+			}
+		}
+		t.Logf("[%v] %v inventory rows match", test.Descrip, len(got))
+	}
+}
+
+func TestWalkAll_AlreadyPost(t *testing.T) {
+	db, cleanup := opendb(t)
+	defer cleanup()
+
+	simid := []byte("simid-already-post")
+	if err := smallFixture.build(db, simid); err != nil {
+		t.Fatal(err)
+	}
+	if err := Prepare(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewContext(db, simid).WalkAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewContext(db, simid).WalkAll(context.Background()); !IsAlreadyPostErr(err) {
+		t.Errorf("want AlreadyPostErr on second walk, got %v", err)
+	}
+}
+
+func TestProcessor_Jobs(t *testing.T) {
+	db, cleanup := opendb(t)
+	defer cleanup()
+
+	var simids [][]byte
+	for i := 0; i < 6; i++ {
+		simid := []byte(fmt.Sprintf("simid-jobs-%06d", i))
+		if err := randFixture(int64(10+i), 4, 40).build(db, simid); err != nil {
+			t.Fatal(err)
+		}
+		simids = append(simids, simid)
+	}
+	if err := Prepare(db); err != nil {
+		t.Fatal(err)
+	}
+
+	wants := map[string][]invRow{}
+	for _, simid := range simids {
+		want, err := refInventories(db, simid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wants[string(simid)] = want
+	}
+
+	p := &Processor{DB: db, Jobs: 4}
+	if _, err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, simid := range simids {
+		got, err := inventories(db, simid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := wants[string(simid)]
+		if len(got) != len(want) {
+			t.Errorf("simid %s: got %v inventory rows, want %v", simid, len(got), len(want))
+			continue
+		}
+		for j := range want {
+			if got[j] != want[j] {
+				t.Errorf("simid %s row %v: got %+v, want %+v", simid, j, got[j], want[j])
+				break
+			}
+		}
+	}
+}
+
+func TestPrepare_MissingTable(t *testing.T) {
+	db, cleanup := opendb(t)
+	defer cleanup()
+
+	if _, err := db.Exec(fixtureTables[0]); err != nil {
+		t.Fatal(err)
+	}
+	err := Prepare(db)
+	if !IsMissingTableErr(err) {
+		t.Fatalf("want MissingTableErr, got %v", err)
+	} else if name := err.(MissingTableErr); name != "AgentEntry" {
+		t.Errorf("want missing table AgentEntry, got %v", name)
+	}
+}
+
+func TestWalkAll_SchemaErr(t *testing.T) {
+	db, cleanup := opendb(t)
+	defer cleanup()
+
+	simid := []byte("simid-bad-schema")
+	if err := smallFixture.build(db, simid); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec("DROP TABLE Transactions; CREATE TABLE Transactions (SimId BLOB,TransactionId INTEGER,ResourceId INTEGER);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Prepare(db); err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewContext(db, simid).WalkAll(context.Background())
+	if !IsSchemaErr(err) {
+		t.Fatalf("want SchemaErr, got %v", err)
+	} else if _, ok := err.(*SimErr); !ok {
+		t.Errorf("want error wrapped in *SimErr, got %T", err)
+	}
+	var se *SchemaErr
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &se) {
+		t.Errorf("SchemaErr not found through a wrapped error")
+	} else if se.Table != "Transactions" {
+		t.Errorf("got SchemaErr for table %v, want Transactions", se.Table)
+	}
+
+	checkNoOutput(t, db, simid)
+}
+
+// checkNoOutput verifies that no partial output is left behind for simid
+// after a failed walk.
+func checkNoOutput(t *testing.T, db *sql.DB, simid []byte) {
+	for _, tbl := range []string{"Agents", "TimeList", "Inventories", "CyanPostInfo"} {
+		n := 0
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+tbl+" WHERE SimId = ?", simid).Scan(&n); err != nil {
+			t.Fatal(err)
+		} else if n != 0 {
+			t.Errorf("%v rows left in %v after failed walk", n, tbl)
+		}
+	}
+}
+
+func TestWalkAll_Reprocess(t *testing.T) {
+	var tests = []struct {
+		Descrip string
+		// Stale modifies the db after an initial complete walk.
+		Stale string
+		Force bool
+	}{
+		{"legacy output without post info", "DELETE FROM CyanPostInfo;", false},
+		{"older schema version", "UPDATE CyanPostInfo SET SchemaVersion = 0;", false},
+		{"interrupted walk", "UPDATE CyanPostInfo SET Status = 'running';", false},
+		{"forced", "", true},
+	}
+
+	for _, test := range tests {
+		db, cleanup := opendb(t)
+		simid := []byte("simid-reprocess")
+		if err := smallFixture.build(db, simid); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		if err := Prepare(db); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		if err := NewContext(db, simid).WalkAll(context.Background()); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		want, err := inventories(db, simid)
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+
+		if _, err := db.Exec(test.Stale); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		c := NewContext(db, simid)
+		c.Force = test.Force
+		if err := c.WalkAll(context.Background()); err != nil {
+			t.Errorf("[%v] want re-processing, got %v", test.Descrip, err)
+		}
+
+		got, err := inventories(db, simid)
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("[%v] got %v inventory rows, want %v", test.Descrip, len(got), len(want))
+		}
+
+		info, err := Info(db, simid)
+		cleanup()
+		if err != nil {
+			t.Fatal(err)
+		} else if info == nil || !info.Current() || info.CyanVersion != Version {
+			t.Errorf("[%v] want complete post info at current version, got %+v", test.Descrip, info)
+		}
+	}
+}
+
+func TestWalkAll_Cancel(t *testing.T) {
+	db, cleanup := opendb(t)
+	defer cleanup()
+
+	simid := []byte("simid-cancel")
+	if err := randFixture(4, 5, 50).build(db, simid); err != nil {
+		t.Fatal(err)
+	}
+	if err := Prepare(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// cancel part way through the walk
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewContext(db, simid)
+	c.Progress = func(p Progress) {
+		if p.Roots == 2 {
+			cancel()
+		}
+	}
+
+	err := c.WalkAll(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	checkNoOutput(t, db, simid)
+}
+
+func TestWalkAll_Progress(t *testing.T) {
+	db, cleanup := opendb(t)
+	defer cleanup()
+
+	simid := []byte("simid-progress")
+	if err := randFixture(5, 4, 60).build(db, simid); err != nil {
+		t.Fatal(err)
+	}
+	if err := Prepare(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []Progress
+	c := NewContext(db, simid)
+	c.Progress = func(p Progress) { reports = append(reports, p) }
+	if err := c.WalkAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := inventories(db, simid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reports) == 0 {
+		t.Fatal("no progress reported")
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Roots < reports[i-1].Roots || reports[i].Resources < reports[i-1].Resources {
+			t.Errorf("progress went backwards: %+v after %+v", reports[i], reports[i-1])
+		}
+	}
+	last := reports[len(reports)-1]
+	if !last.Done || last.Roots != last.TotalRoots || last.Rows != len(got) {
+		t.Errorf("final progress %+v does not match %v inventory rows", last, len(got))
+	}
+}
+
+func TestWalkAll_Incremental(t *testing.T) {
+	db, cleanup := opendb(t)
+	defer cleanup()
+
+	simid := []byte("simid-incremental")
+	full := randFixture(6, 5, 60)
+	if err := (&fixture{Duration: full.Duration, Agents: full.Agents}).build(db, simid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE Finish (SimId BLOB,EarlyTerm INTEGER,EndTime INTEGER);"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Prepare(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// transactions and creators lag behind resources as if their buffers
+	// were flushed less often
+	prev := &fixture{}
+	for _, tm := range []int{10, 25, 26, 40, 58, 60} {
+		cur := full.until(tm, tm-3, tm-5)
+		finished := tm == full.Duration
+		if finished {
+			cur = full
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cur.minus(prev).insertRows(tx, simid); err != nil {
+			t.Fatal(err)
+		}
+		if finished {
+			if _, err := tx.Exec("INSERT INTO Finish VALUES (?,0,?);", simid, tm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		prev = cur
+
+		c := NewContext(db, simid)
+		c.Incremental = true
+		if err := c.WalkAll(context.Background()); err != nil {
+			t.Fatalf("t=%v: %v", tm, err)
+		}
+
+		want := StatusPartial
+		if finished {
+			want = StatusComplete
+		}
+		if info, err := Info(db, simid); err != nil {
+			t.Fatal(err)
+		} else if info.Status != want {
+			t.Errorf("t=%v: got status %v, want %v", tm, info.Status, want)
+		}
+	}
+
+	if err := NewContext(db, simid).WalkAll(context.Background()); !IsAlreadyPostErr(err) {
+		t.Errorf("want AlreadyPostErr after sim finished, got %v", err)
+	}
+
+	got, err := inventories(db, simid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := refInventories(db, simid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortInv(got)
+	sortInv(want)
+	if len(got) != len(want) {
+		t.Fatalf("got %v inventory rows, want %v", len(got), len(want))
+	}
+	for j := range want {
+		if got[j] != want[j] {
+			t.Errorf("row %v: got %+v, want %+v", j, got[j], want[j])
+			break
+		}
+	}
+
+	n := 0
+	if err := db.QueryRow("SELECT COUNT(*) FROM TimeList WHERE SimId = ?", simid).Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != full.Duration {
+		t.Errorf("got %v TimeList rows, want %v", n, full.Duration)
+	}
+}
+
+// TestWalkAll_Extend checks that extending a partial walk after each batch
+// of new resources, transactions and time steps gives the same inventories
+// as a fresh full walk of the grown database while loading only the new
+// resources and those left open by the previous walk.
+func TestWalkAll_Extend(t *testing.T) {
+	// Unlike smallFixture, resource and transaction ids grow with time as in
+	// cyclus output.  Resource 1 is closed by a transaction and then by its
+	// children, 2 by a combine and 4 is returned to the owner it inherited.
+	hand := &fixture{
+		Duration: 6,
+		Agents:   []int{1, 2, 3},
+		Resources: []resRow{
+			{Id: 1, Time: 0, Qty: 100, Qual: 1},
+			{Id: 2, Time: 0, Qty: 50, Qual: 2},
+			{Id: 3, Time: 2, Qty: 60, Qual: 1, P1: 1},
+			{Id: 4, Time: 2, Qty: 40, Qual: 1, P1: 1},
+			{Id: 5, Time: 4, Qty: 110, Qual: 3, P1: 3, P2: 2},
+		},
+		Creators: []creatorRow{{1, 1}, {2, 2}},
+		Trans: []transRow{
+			{Id: 1, Sender: 1, Receiver: 2, Res: 1, Time: 1},
+			{Id: 2, Sender: 2, Receiver: 3, Res: 4, Time: 3},
+			{Id: 3, Sender: 3, Receiver: 2, Res: 4, Time: 5},
+			{Id: 4, Sender: 2, Receiver: 1, Res: 5, Time: 5},
+		},
+	}
+
+	var tests = []struct {
+		Descrip string
+		Fix     *fixture
+		Times   []int
+	}{
+		{"hand-built graph", hand, []int{0, 1, 2, 3, 4, 5}},
+		{"random graph", randFixture(7, 5, 60), []int{5, 10, 11, 20, 30, 42, 59}},
+	}
+
+	for i, test := range tests {
+		db, cleanup := opendb(t)
+		simid := []byte(fmt.Sprintf("simid-extend-%v", i))
+		if err := (&fixture{Duration: test.Fix.Duration, Agents: test.Fix.Agents}).build(db, simid); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		if err := Prepare(db); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+
+		prev := &fixture{}
+		for _, tm := range test.Times {
+			cur := test.Fix.until(tm, tm, tm)
+			added := cur.minus(prev)
+			prev = cur
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := added.insertRows(tx, simid); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			nopen := 0
+			err = db.QueryRow("SELECT COUNT(*) FROM Inventories WHERE SimId = ? AND EndTime = ?", simid, math.MaxInt32).Scan(&nopen)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := 0
+			c := NewContext(db, simid)
+			c.Incremental = true
+			c.Progress = func(p Progress) { loaded = p.TotalResources }
+			if err := c.WalkAll(context.Background()); err != nil {
+				t.Fatalf("[%v] t=%v: %v", test.Descrip, tm, err)
+			}
+			if want := nopen + len(added.Resources); loaded != want {
+				t.Errorf("[%v] t=%v: loaded %v resources, want %v open and new ones", test.Descrip, tm, loaded, want)
+			}
+
+			got, err := inventories(db, simid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := refInventories(db, simid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sortInv(got)
+			sortInv(want)
+			if len(got) != len(want) {
+				t.Errorf("[%v] t=%v: got %v inventory rows, want %v", test.Descrip, tm, len(got), len(want))
+				continue
+			}
+			for j := range want {
+				if got[j] != want[j] {
+					t.Errorf("[%v] t=%v: row %v: got %+v, want %+v", test.Descrip, tm, j, got[j], want[j])
+					break
+				}
+			}
+		}
+		cleanup()
+	}
+}
+
+func TestProcessor_IdleLimit(t *testing.T) {
+	db, cleanup := opendb(t)
+	defer cleanup()
+
+	simid := []byte("simid-idle")
+	full := randFixture(8, 3, 20)
+	part := full.until(10, 10, 10)
+	if err := part.build(db, simid); err != nil {
+		t.Fatal(err)
+	}
+
+	status := func() string {
+		info, err := Info(db, simid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Status
+	}
+
+	// without a Finish table the simulation never finishes by itself
+	p := &Processor{DB: db, Incremental: true}
+	for i := 0; i < 3; i++ {
+		if _, err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got := status(); got != StatusPartial {
+			t.Fatalf("run %v without idle limit: got status %v, want %v", i, got, StatusPartial)
+		}
+	}
+
+	var tests = []struct {
+		Add    bool
+		Status string
+	}{
+		{false, StatusPartial},
+		{false, StatusPartial},
+		{true, StatusPartial},
+		{false, StatusPartial},
+		{false, StatusComplete},
+	}
+	p = &Processor{DB: db, Incremental: true, IdleLimit: 2}
+	for i, test := range tests {
+		if test.Add {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := full.minus(part).insertRows(tx, simid); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		} else if got := status(); got != test.Status {
+			t.Errorf("run %v: got status %v, want %v", i, got, test.Status)
+		}
+	}
+}
+
+func sortInv(invs []invRow) {
+	sort.Slice(invs, func(i, j int) bool {
+		a, b := invs[i], invs[j]
+		if a.ResId != b.ResId {
+			return a.ResId < b.ResId
+		} else if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		return a.AgentId < b.AgentId
+	})
+}
+
+func TestWalkAll_Products(t *testing.T) {
+	db, cleanup := opendb(t)
+	defer cleanup()
+
+	// agent 1 sends 10 units of natural_gas to agent 2 at time 2 and keeps 5
+	// units of steam
+	simid := []byte("simid-products")
+	f := &fixture{
+		Duration:  4,
+		Agents:    []int{1, 2},
+		Resources: []resRow{{1, 0, 10, 1, 0, 0}, {2, 1, 5, 2, 0, 0}},
+		Creators:  []creatorRow{{1, 1}, {2, 1}},
+		Trans:     []transRow{{1, 1, 2, 1, 2}},
+	}
+	if err := f.build(db, simid); err != nil {
+		t.Fatal(err)
+	}
+	stmts := []string{
+		"UPDATE Resources SET Type = 'Product', Units = '';",
+		"CREATE TABLE Products (SimId BLOB,QualId INTEGER,Quality TEXT);",
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, q := range []string{"natural_gas", "steam"} {
+		if _, err := db.Exec("INSERT INTO Products VALUES (?,?,?);", simid, i+1, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Process(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := inventories(db, simid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortInv(got)
+	want := []invRow{
+		{1, 1, 0, 2, 1, 10},
+		{1, 2, 2, math.MaxInt32, 1, 10},
+		{2, 1, 1, math.MaxInt32, 2, 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got inventories %+v, want %+v", got, want)
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	var tests = []struct {
+		Cal     calendar
+		T       int
+		Date    string
+		DecYear float64
+		Months  float64
+	}{
+		{calendar{2000, 1, DefaultDt}, 0, "2000-01-01", 2000, 0},
+		{calendar{2000, 1, DefaultDt}, 13, "2001-02-01", 2001 + 1.0/12, 13},
+		{calendar{2030, 11, DefaultDt}, 2, "2031-01-01", 2031, 2},
+		{calendar{2030, 1, 3 * DefaultDt}, 2, "2030-07-01", 2030.5, 6},
+		{calendar{2001, 1, 86400}, 73, "2001-03-15", 2001 + 73.0/365, 73 * 86400.0 / DefaultDt},
+	}
+
+	for _, test := range tests {
+		if got := test.Cal.Date(test.T).Format("2006-01-02"); got != test.Date {
+			t.Errorf("%+v step %v: got date %v, want %v", test.Cal, test.T, got, test.Date)
+		}
+		if got := test.Cal.DecYear(test.T); math.Abs(got-test.DecYear) > 1e-9 {
+			t.Errorf("%+v step %v: got decimal year %v, want %v", test.Cal, test.T, got, test.DecYear)
+		}
+		if got := test.Cal.Months(test.T); math.Abs(got-test.Months) > 1e-9 {
+			t.Errorf("%+v step %v: got %v months, want %v", test.Cal, test.T, got, test.Months)
+		}
+	}
+}
+
+func TestWalkAll_TimeList(t *testing.T) {
+	db, cleanup := opendb(t)
+	defer cleanup()
+
+	simid := []byte("simid-timelist")
+	f := &fixture{Duration: 14, Agents: []int{1}}
+	if err := f.build(db, simid); err != nil {
+		t.Fatal(err)
+	}
+	stmts := []string{
+		"ALTER TABLE Info ADD COLUMN InitialYear INTEGER DEFAULT 2030;",
+		"ALTER TABLE Info ADD COLUMN InitialMonth INTEGER DEFAULT 11;",
+		// TimeList as written by older cyan versions
+		"CREATE TABLE TimeList (SimId BLOB, Time INTEGER);",
+		"INSERT INTO TimeList VALUES (x'00', 0);",
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Process(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		Time        int
+		Year, Month int
+		Date        string
+	}{
+		{0, 2030, 11, "2030-11-01"},
+		{2, 2031, 1, "2031-01-01"},
+		{13, 2031, 12, "2031-12-01"},
+	}
+	for _, test := range tests {
+		var year, month int
+		var date string
+		err := db.QueryRow("SELECT Year,Month,Date FROM TimeList WHERE SimId = ? AND Time = ?", simid, test.Time).Scan(&year, &month, &date)
+		if err != nil {
+			t.Fatalf("time step %v: %v", test.Time, err)
+		}
+		if year != test.Year || month != test.Month || date != test.Date {
+			t.Errorf("time step %v: got %v-%v (%v), want %v-%v (%v)", test.Time, year, month, date, test.Year, test.Month, test.Date)
+		}
+	}
+
+	n := 0
+	if err := db.QueryRow("SELECT COUNT(*) FROM TimeList").Scan(&n); err != nil {
+		t.Fatal(err)
+	} else if n != f.Duration {
+		t.Errorf("got %v TimeList rows, want %v", n, f.Duration)
+	}
+}
+
+// openSidecar opens the sidecar database at postpath with the cyclus
+// database at rawpath attached as RawSchema on every connection.
+func openSidecar(t *testing.T, rawpath, postpath string) *sql.DB {
+	name := "sqlite3-sidecar-" + rawpath
+	registered := false
+	for _, d := range sql.Drivers() {
+		registered = registered || d == name
+	}
+	if !registered {
+		sql.Register(name, &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				_, err := conn.Exec(AttachSql, []driver.Value{RawURI(rawpath)})
+				return err
+			},
+		})
+	}
+	db, err := sql.Open(name, postpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// schema returns the sorted names of the tables and indexes in db's main
+// database.
+func schema(db *sql.DB) (names []string, err error) {
+	rows, err := db.Query("SELECT name FROM main.sqlite_master WHERE type IN ('table','index') ORDER BY name;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func TestProcess_Sidecar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cyan-post-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rawpath := filepath.Join(dir, "cyclus.sqlite")
+	raw, err := sql.Open("sqlite3", rawpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	simid := []byte("simid-sidecar")
+	if err := smallFixture.build(raw, simid); err != nil {
+		t.Fatal(err)
+	}
+	before, err := schema(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mode string
+	if err := raw.QueryRow("PRAGMA journal_mode;").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+
+	db := openSidecar(t, rawpath, SidecarPath(rawpath))
+	defer db.Close()
+	if _, err := Process(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := IsSidecar(db); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Error("IsSidecar is false for the sidecar database")
+	}
+	if ok, err := IsSidecar(raw); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Error("IsSidecar is true for the raw database")
+	}
+
+	after, err := schema(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("raw database schema changed from %v to %v", before, after)
+	}
+	var mode2 string
+	if err := raw.QueryRow("PRAGMA journal_mode;").Scan(&mode2); err != nil {
+		t.Fatal(err)
+	} else if mode2 != mode {
+		t.Errorf("raw database journal mode changed from %v to %v", mode, mode2)
+	}
+
+	out, err := schema(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tbl := range []string{"Inventories", "TimeList"} {
+		found := false
+		for _, name := range out {
+			found = found || name == tbl
+		}
+		if !found {
+			t.Errorf("sidecar database has no %v table (has %v)", tbl, out)
+		}
+	}
+
+	got, err := inventories(db, simid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := refInventories(db, simid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v inventory rows in the sidecar, want %v", len(got), len(want))
+	}
+}
@@ -0,0 +1,110 @@
+package post
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+)
+
+// RawSchema is the schema name the raw cyclus database is attached under
+// when post processing output is written to a separate sidecar database.
+// The sidecar is the main database of such connections, so derived tables
+// shadow any same-named tables in the raw database and unqualified queries
+// transparently fall through to the raw tables.
+const RawSchema = "raw"
+
+// AttachSql attaches the database given as its single argument (see RawURI)
+// under RawSchema.  It must be run on every connection to a sidecar
+// database, typically from a driver connect hook.
+const AttachSql = "ATTACH DATABASE ? AS " + RawSchema + ";"
+
+// SidecarPath returns the default sidecar database path for the cyclus
+// database at dbpath (e.g. "cyclus.sqlite" becomes "cyclus.post.sqlite").
+func SidecarPath(dbpath string) string {
+	ext := filepath.Ext(dbpath)
+	return strings.TrimSuffix(dbpath, ext) + ".post" + ext
+}
+
+// RawURI returns an sqlite URI filename for opening the cyclus database at
+// path read-only.
+func RawURI(path string) string {
+	r := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+	return "file:" + r.Replace(path) + "?mode=ro"
+}
+
+// IsSidecar returns true if db is a connection to a sidecar database - i.e.
+// the raw cyclus database is attached to it under RawSchema.
+func IsSidecar(db *sql.DB) (bool, error) {
+	rows, err := db.Query("PRAGMA database_list;")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		var seq int
+		var name, file sql.NullString
+		if err := rows.Scan(&seq, &name, &file); err != nil {
+			return false, err
+		}
+		if name.String == RawSchema {
+			found = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// tableExists returns true if the named table exists in the main database
+// or (for sidecar connections) in the attached raw database.
+func tableExists(db *sql.DB, sidecar bool, name string) (bool, error) {
+	s := "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=? COLLATE NOCASE"
+	args := []interface{}{name}
+	if sidecar {
+		s = "SELECT (" + s + ") + (SELECT COUNT(*) FROM " + RawSchema + ".sqlite_master WHERE type='table' AND name=? COLLATE NOCASE)"
+		args = append(args, name)
+	}
+
+	n := 0
+	if err := db.QueryRow(s, args...).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// TableExists returns true if the named table exists in db or, for sidecar
+// connections, in the attached raw database.
+func TableExists(db *sql.DB, name string) (bool, error) {
+	sidecar, err := IsSidecar(db)
+	if err != nil {
+		return false, err
+	}
+	return tableExists(db, sidecar, name)
+}
+
+// columns returns the lower case column names of the named table as seen by
+// unqualified queries - i.e. a table in the main database shadows one in the
+// raw database of sidecar connections.  It returns no columns if the table
+// does not exist.
+func columns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ");")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols := map[string]bool{}
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, typ string
+		var dflt interface{}
+		if err := rows.Scan(&cid, &name, &typ, &notnull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		cols[strings.ToLower(name)] = true
+	}
+	return cols, rows.Err()
+}
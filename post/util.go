@@ -28,12 +28,6 @@ func GetSimIds(db *sql.DB) (ids [][]byte, err error) {
 	return ids, nil
 }
 
-func panicif(err error) {
-	if err != nil {
-		panic(err.Error())
-	}
-}
-
 type Timer struct {
 	starts map[string]time.Time
 	Totals map[string]time.Duration
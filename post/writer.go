@@ -0,0 +1,60 @@
+package post
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// writer serializes the write transactions of concurrently walking contexts
+// through a single goroutine.  Reads may proceed in parallel with each other
+// but never while a write transaction is in progress - this avoids sqlite
+// lock contention between connections.
+type writer struct {
+	db   *sql.DB
+	mu   sync.RWMutex
+	jobs chan writeJob
+}
+
+type writeJob struct {
+	fn   func(tx *sql.Tx) error
+	done chan error
+}
+
+func newWriter(db *sql.DB) *writer {
+	w := &writer{db: db, jobs: make(chan writeJob)}
+	go w.run()
+	return w
+}
+
+func (w *writer) run() {
+	for job := range w.jobs {
+		w.mu.Lock()
+		job.done <- execTx(w.db, job.fn)
+		w.mu.Unlock()
+	}
+}
+
+// Write runs fn inside a transaction on the writer goroutine and returns
+// once the transaction has been committed or rolled back.
+func (w *writer) Write(fn func(tx *sql.Tx) error) error {
+	done := make(chan error, 1)
+	w.jobs <- writeJob{fn: fn, done: done}
+	return <-done
+}
+
+// Close stops the writer goroutine.  Write must not be called afterwards.
+func (w *writer) Close() { close(w.jobs) }
+
+// execTx runs fn inside a new transaction on db, committing on success and
+// rolling back if fn fails.
+func execTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,236 @@
+package query
+
+import (
+	"database/sql"
+	"sort"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+// DefaultEfficiency is the typical thermal efficiency of light water
+// reactors used to convert the electric power reactors report to thermal
+// power.
+const DefaultEfficiency = 0.33
+
+// Batch is a batch of fuel discharged from a reactor: all the material a
+// reactor agent sent away at one time step.
+type Batch struct {
+	AgentId int
+	Proto   string
+	// Time is the time step of the discharge.
+	Time int
+	// HM is the initial heavy metal mass of the batch in kg.
+	HM float64
+	// Energy is the thermal energy in Joules produced by the reactor since
+	// its previous discharge.
+	Energy float64
+	// Mat is the discharged material.
+	Mat nuc.Material
+}
+
+// Burnup returns the discharge burnup of b in MWd/kgHM.
+func (b Batch) Burnup() float64 {
+	if b.HM == 0 {
+		return 0
+	}
+	return b.Energy / nuc.MWd / b.HM
+}
+
+// AvgBurnup returns the heavy metal weighted average discharge burnup of bs
+// in MWd/kgHM.
+func AvgBurnup(bs []Batch) float64 {
+	var energy, hm float64
+	for _, b := range bs {
+		energy += b.Energy
+		hm += b.HM
+	}
+	if hm == 0 {
+		return 0
+	}
+	return energy / nuc.MWd / hm
+}
+
+// DischargedMat returns the total material discharged in bs.
+func DischargedMat(bs []Batch) nuc.Material {
+	m := nuc.Material{}
+	for _, b := range bs {
+		m = m.Add(b.Mat)
+	}
+	return m
+}
+
+// Batches returns the fuel batches discharged by the reactors matching the
+// agent fields of f on the commodities and at the times matching f.
+// Reactors are the agents reporting power in TimeSeriesPower, which is
+// converted to thermal power by dividing by the thermal efficiency eff.
+//
+// A batch is credited with the energy its reactor produced since the
+// previous discharge (matching f's commodities), so its burnup is the cycle
+// energy per discharged heavy metal - the average discharge burnup of an
+// equilibrium core.  Its initial heavy metal mass is its discharged mass
+// times the actinide mass fraction of all the fuel its reactor received.
+func Batches(db *sql.DB, simid []byte, f Filter, eff float64) ([]Batch, error) {
+	dt, err := TimeStepDur(db, simid)
+	if err != nil {
+		return nil, err
+	}
+
+	// thermal power of the reactors by agent and time
+	filt, fargs, err := f.Agent().SQL(Cols{Agent: "p.AgentId", Proto: "ag.Prototype", Spec: "ag.Spec"})
+	if err != nil {
+		return nil, err
+	}
+	sql := `SELECT p.AgentId,ag.Prototype,p.Time,TOTAL(p.Value) FROM
+				TimeSeriesPower AS p
+				INNER JOIN Agents AS ag ON ag.AgentId = p.AgentId AND ag.SimId = p.SimId
+			WHERE p.SimId = ?` + filt + `
+			GROUP BY p.AgentId,p.Time;`
+	rows, err := db.Query(sql, append([]interface{}{simid}, fargs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	protos := map[int]string{}
+	power := map[int]map[int]float64{}
+	for rows.Next() {
+		var id, t int
+		var proto string
+		var p float64
+		if err := rows.Scan(&id, &proto, &t, &p); err != nil {
+			return nil, err
+		}
+		if power[id] == nil {
+			power[id] = map[int]float64{}
+		}
+		protos[id] = proto
+		power[id][t] += p * 1e6 / eff
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// discharges at any time are needed to find the start of each cycle
+	df := f
+	df.T0, df.T1 = 0, 0
+	discharged, err := agentFlows(db, simid, df, "tr.SenderId", Cols{Agent: "tr.SenderId", Proto: "snd.Prototype", Spec: "snd.Spec", Commod: "tr.Commodity"})
+	if err != nil {
+		return nil, err
+	}
+	received, err := agentFlows(db, simid, f.Agent(), "tr.ReceiverId", Cols{Agent: "tr.ReceiverId", Proto: "rcv.Prototype", Spec: "rcv.Spec"})
+	if err != nil {
+		return nil, err
+	}
+
+	var bs []Batch
+	for id, byTime := range discharged {
+		if power[id] == nil {
+			continue
+		}
+
+		hmfrac := 1.0
+		var in nuc.Material
+		for _, m := range received[id] {
+			in = in.Add(m)
+		}
+		if in.Mass() > 0 {
+			hmfrac = float64(in.Filter(nuc.Actinides.Contains).Mass() / in.Mass())
+		}
+
+		var times []int
+		for t := range byTime {
+			times = append(times, t)
+		}
+		sort.Ints(times)
+		prev := -1
+		for _, t := range times {
+			b := Batch{AgentId: id, Proto: protos[id], Time: t, Mat: byTime[t]}
+			b.HM = float64(b.Mat.Mass()) * hmfrac
+			for pt, p := range power[id] {
+				if prev < pt && pt <= t {
+					b.Energy += p * dt
+				}
+			}
+			prev = t
+			if t >= f.T0 && (f.T1 <= 0 || t < f.T1) {
+				bs = append(bs, b)
+			}
+		}
+	}
+
+	sort.Slice(bs, func(i, j int) bool {
+		if bs[i].Time != bs[j].Time {
+			return bs[i].Time < bs[j].Time
+		}
+		return bs[i].AgentId < bs[j].AgentId
+	})
+	return bs, nil
+}
+
+// agentFlows returns the material transacted on the commodities and at the
+// times matching f by agent (the agentCol column of transactions) and time.
+// The agent fields of f are applied to the agent columns of c.
+func agentFlows(db *sql.DB, simid []byte, f Filter, agentCol string, c Cols) (map[int]map[int]nuc.Material, error) {
+	c.Time = "tr.Time"
+	filt, fargs, err := f.SQL(c)
+	if err != nil {
+		return nil, err
+	}
+	sql := `SELECT ` + agentCol + `,tr.Time,cmp.NucId,SUM(cmp.MassFrac * res.Quantity) FROM (
+				Resources AS res
+				INNER JOIN Compositions AS cmp ON cmp.QualId = res.QualId
+				INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId
+				INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId
+				INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId
+			) WHERE (
+				res.SimId = ? AND cmp.SimId = res.SimId AND tr.SimId = res.SimId
+				AND snd.SimId = res.SimId AND rcv.SimId = res.SimId` + filt + `
+			) GROUP BY ` + agentCol + `,tr.Time,cmp.NucId;`
+	rows, err := db.Query(sql, append([]interface{}{simid}, fargs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flows := map[int]map[int]nuc.Material{}
+	for rows.Next() {
+		var id, t, n int
+		var qty float64
+		if err := rows.Scan(&id, &t, &n, &qty); err != nil {
+			return nil, err
+		}
+		if flows[id] == nil {
+			flows[id] = map[int]nuc.Material{}
+		}
+		if flows[id][t] == nil {
+			flows[id][t] = nuc.Material{}
+		}
+		flows[id][t][nuc.Nuc(n)] += nuc.Mass(qty)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return flows, nil
+}
+
+// BurnupSeries returns a time series of the average discharge burnup in
+// MWd/kgHM of the batches discharged at each time step matching f (see
+// Batches).  Time steps without discharges have zero burnup.
+func BurnupSeries(db *sql.DB, simid []byte, f Filter, eff float64) (xys []XY, err error) {
+	bs, err := Batches(db, simid, f, eff)
+	if err != nil {
+		return nil, err
+	}
+	times, err := timeSteps(db, simid, f)
+	if err != nil {
+		return nil, err
+	}
+
+	byTime := map[int][]Batch{}
+	for _, b := range bs {
+		byTime[b.Time] = append(byTime[b.Time], b)
+	}
+	for _, t := range times {
+		xys = append(xys, XY{X: t, Y: AvgBurnup(byTime[t])})
+	}
+	return xys, nil
+}
@@ -0,0 +1,164 @@
+package query
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/nuc"
+	_ "github.com/rwcarlsen/go-sqlite3"
+)
+
+var burnupSimid = []byte("simid-burnup")
+
+// burnupDB returns a database with a reactor (agent 2) that receives 20 kg
+// of fuel that is 90% heavy metal at time 0 and discharges 10 kg of it at
+// times 3 and 6.  It produces 100 MWe at times 1-3 and 50 MWe at times 4-6
+// with one day time steps.
+func burnupDB(t *testing.T) (db *sql.DB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "cyan-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	stmts := []string{
+		"CREATE TABLE Info (SimId BLOB,DtTime INTEGER);",
+		"CREATE TABLE TimeList (SimId BLOB,Time INTEGER);",
+		"CREATE TABLE Agents (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER,ExitTime INTEGER);",
+		"CREATE TABLE Transactions (SimId BLOB,TransactionId INTEGER,SenderId INTEGER,ReceiverId INTEGER,ResourceId INTEGER,Commodity TEXT,Time INTEGER);",
+		"CREATE TABLE Resources (SimId BLOB,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
+		"CREATE TABLE Compositions (SimId BLOB,QualId INTEGER,NucId INTEGER,MassFrac REAL);",
+		"CREATE TABLE TimeSeriesPower (SimId BLOB,AgentId INTEGER,Time INTEGER,Value REAL);",
+	}
+	var args [][]interface{}
+	for range stmts {
+		args = append(args, nil)
+	}
+	add := func(s string, vals ...interface{}) {
+		stmts = append(stmts, s)
+		args = append(args, append([]interface{}{burnupSimid}, vals...))
+	}
+
+	add("INSERT INTO Info VALUES (?,86400);")
+	for i := 0; i < 8; i++ {
+		add("INSERT INTO TimeList VALUES (?,?);", i)
+	}
+	add("INSERT INTO Agents VALUES (?,1,'Facility',':agents:Source','src',-1,-1,0,NULL);")
+	add("INSERT INTO Agents VALUES (?,2,'Facility',':cycamore:Reactor','lwr',-1,-1,0,NULL);")
+	add("INSERT INTO Agents VALUES (?,3,'Facility',':agents:Sink','sink',-1,-1,0,NULL);")
+	add("INSERT INTO Resources VALUES (?,1,1,'Material',0,20,'kg',1,0,0);")
+	add("INSERT INTO Resources VALUES (?,2,2,'Material',3,10,'kg',2,0,0);")
+	add("INSERT INTO Resources VALUES (?,3,3,'Material',6,10,'kg',2,0,0);")
+	add("INSERT INTO Compositions VALUES (?,1,922350000,0.05);")
+	add("INSERT INTO Compositions VALUES (?,1,922380000,0.85);")
+	add("INSERT INTO Compositions VALUES (?,1,80160000,0.1);")
+	add("INSERT INTO Compositions VALUES (?,2,922350000,0.01);")
+	add("INSERT INTO Compositions VALUES (?,2,922380000,0.84);")
+	add("INSERT INTO Compositions VALUES (?,2,551370000,0.05);")
+	add("INSERT INTO Compositions VALUES (?,2,80160000,0.1);")
+	add("INSERT INTO Transactions VALUES (?,1,1,2,1,'fuel',0);")
+	add("INSERT INTO Transactions VALUES (?,2,2,3,2,'spent',3);")
+	add("INSERT INTO Transactions VALUES (?,3,2,3,3,'spent',6);")
+	for t := 1; t <= 6; t++ {
+		p := 100.0
+		if t > 3 {
+			p = 50
+		}
+		add("INSERT INTO TimeSeriesPower VALUES (?,2,?,?);", t, p)
+	}
+
+	for i, s := range stmts {
+		if _, err := db.Exec(s, args[i]...); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	return db, cleanup
+}
+
+func TestBatches(t *testing.T) {
+	db, cleanup := burnupDB(t)
+	defer cleanup()
+
+	// 200 and 100 MWt for 3 days each per 9 kg of heavy metal
+	const eff = 0.5
+	want := []Batch{
+		{AgentId: 2, Proto: "lwr", Time: 3, HM: 9, Energy: 600 * nuc.MWd},
+		{AgentId: 2, Proto: "lwr", Time: 6, HM: 9, Energy: 300 * nuc.MWd},
+	}
+
+	tests := []struct {
+		f    Filter
+		want []Batch
+	}{
+		{Filter{}, want},
+		{Filter{Prototypes: []string{"lwr"}, Commods: []string{"spent"}}, want},
+		{Filter{T0: 4}, want[1:]},
+		{Filter{T1: 4}, want[:1]},
+		{Filter{Prototypes: []string{"src"}}, nil},
+		{Filter{Commods: []string{"fuel"}}, nil},
+	}
+
+	for i, test := range tests {
+		bs, err := Batches(db, burnupSimid, test.f, eff)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(bs) != len(test.want) {
+			t.Errorf("test %v: want %v batches, got %v", i, len(test.want), len(bs))
+			continue
+		}
+		for j, b := range bs {
+			w := test.want[j]
+			if b.AgentId != w.AgentId || b.Proto != w.Proto || b.Time != w.Time ||
+				math.Abs(b.HM-w.HM) > 1e-9 || math.Abs(b.Energy-w.Energy) > 1e-3 {
+				t.Errorf("test %v batch %v: want %+v, got %+v", i, j, w, b)
+			}
+			if m := b.Mat.Mass(); math.Abs(float64(m)-10) > 1e-9 {
+				t.Errorf("test %v batch %v: want 10 kg discharged, got %v", i, j, m)
+			}
+		}
+	}
+
+	bs, err := Batches(db, burnupSimid, Filter{}, eff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bs[0].Burnup(); math.Abs(got-600.0/9) > 1e-9 {
+		t.Errorf("batch burnup: want %v MWd/kgHM, got %v", 600.0/9, got)
+	}
+	if got := AvgBurnup(bs); math.Abs(got-50) > 1e-9 {
+		t.Errorf("average burnup: want 50 MWd/kgHM, got %v", got)
+	}
+	vec := DischargedMat(bs).Normalize()
+	if math.Abs(float64(vec[551370000])-0.05) > 1e-9 {
+		t.Errorf("discharged Cs137 fraction: want 0.05, got %v", vec[551370000])
+	}
+
+	xys, err := BurnupSeries(db, burnupSimid, Filter{}, eff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, xy := range xys {
+		want := 0.0
+		if xy.X == 3 {
+			want = 600.0 / 9
+		} else if xy.X == 6 {
+			want = 300.0 / 9
+		}
+		if math.Abs(xy.Y-want) > 1e-9 {
+			t.Errorf("burnup at %v: want %v, got %v", xy.X, want, xy.Y)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package query
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Comparison holds a time series from each of several simulations merged on
+// their times.
+type Comparison struct {
+	SimIds [][]byte
+	// Times holds every distinct time of any of the series in order.
+	Times []string
+	// Values holds a row for each time with one value per simulation.
+	// Values for times missing from a simulation's series are invalid
+	// (NULL).
+	Values [][]sql.NullFloat64
+}
+
+// CompareSeries runs a time series query for each simulation and merges the
+// results.  The query must return a time column followed by a single value
+// column.  args returns the query arguments for a simulation.  Times are
+// ordered numerically if they are all numbers and lexically (e.g. dates)
+// otherwise.
+func CompareSeries(db *sql.DB, simids [][]byte, query string, args func(simid []byte) []interface{}) (*Comparison, error) {
+	c := &Comparison{SimIds: simids}
+	index := map[string]int{}
+	for j, simid := range simids {
+		rows, err := db.Query(query, args(simid)...)
+		if err != nil {
+			return nil, err
+		}
+		if cols, err := rows.Columns(); err != nil {
+			rows.Close()
+			return nil, err
+		} else if len(cols) != 2 {
+			rows.Close()
+			return nil, fmt.Errorf("time series query returned %v columns, want 2", len(cols))
+		}
+
+		for rows.Next() {
+			var t string
+			var v sql.NullFloat64
+			if err := rows.Scan(&t, &v); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			i, ok := index[t]
+			if !ok {
+				i = len(c.Times)
+				index[t] = i
+				c.Times = append(c.Times, t)
+				c.Values = append(c.Values, make([]sql.NullFloat64, len(simids)))
+			}
+			c.Values[i][j] = v
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	numeric := true
+	for _, t := range c.Times {
+		if _, err := strconv.ParseFloat(t, 64); err != nil {
+			numeric = false
+			break
+		}
+	}
+	sort.Sort(byTime{c, numeric})
+	return c, nil
+}
+
+// byTime sorts the rows of a comparison by time.
+type byTime struct {
+	*Comparison
+	numeric bool
+}
+
+func (c byTime) Len() int { return len(c.Times) }
+
+func (c byTime) Swap(i, j int) {
+	c.Times[i], c.Times[j] = c.Times[j], c.Times[i]
+	c.Values[i], c.Values[j] = c.Values[j], c.Values[i]
+}
+
+func (c byTime) Less(i, j int) bool {
+	if !c.numeric {
+		return c.Times[i] < c.Times[j]
+	}
+	x, _ := strconv.ParseFloat(c.Times[i], 64)
+	y, _ := strconv.ParseFloat(c.Times[j], 64)
+	return x < y
+}
@@ -0,0 +1,48 @@
+package query
+
+import (
+	"database/sql"
+	"strconv"
+	"testing"
+)
+
+func TestCompareSeries(t *testing.T) {
+	db, cleanup := timeDB(t)
+	defer cleanup()
+
+	other := []byte("simid-other")
+	for i := 8; i < 12; i++ {
+		if _, err := db.Exec("INSERT INTO TimeList (SimId,Time) VALUES (?,?);", other, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args := func(simid []byte) []interface{} { return []interface{}{simid} }
+	c, err := CompareSeries(db, [][]byte{timeSimid, other}, "SELECT Time, Time*2 FROM TimeList WHERE SimId = ?;", args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Times) != 14 {
+		t.Fatalf("got %v times, want 14", len(c.Times))
+	}
+	for i, tm := range c.Times {
+		if tm != strconv.Itoa(i) {
+			t.Errorf("time %v: got %v, want %v", i, tm, i)
+		}
+		want := []sql.NullFloat64{{Float64: float64(2 * i), Valid: true}, {}}
+		if i >= 8 && i < 12 {
+			want[1] = want[0]
+		}
+		for j := range want {
+			if c.Values[i][j] != want[j] {
+				t.Errorf("time %v sim %v: got %+v, want %+v", i, j, c.Values[i][j], want[j])
+			}
+		}
+	}
+
+	_, err = CompareSeries(db, [][]byte{timeSimid}, "SELECT Time, Time, Time FROM TimeList WHERE SimId = ?;", args)
+	if err == nil {
+		t.Error("got nil error for a 3 column query")
+	}
+}
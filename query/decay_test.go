@@ -0,0 +1,164 @@
+package query
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/nuc"
+	_ "github.com/rwcarlsen/go-sqlite3"
+)
+
+var decaySimid = []byte("simid-decay")
+
+const (
+	cs137 nuc.Nuc = 551370000
+	ba137 nuc.Nuc = 561370000
+)
+
+// halfStep is decay data where cs137 decays to stable ba137 with a half
+// life of one (default length) time step.
+type halfStep struct{}
+
+func (halfStep) DecayConst(n nuc.Nuc) float64 {
+	if n == cs137 {
+		return math.Ln2 / DefaultDt
+	}
+	return 0
+}
+
+func (halfStep) DecayEnergy(n nuc.Nuc) float64 { return 0 }
+
+func (halfStep) Branches(n nuc.Nuc) map[nuc.Nuc]float64 {
+	if n == cs137 {
+		return map[nuc.Nuc]float64{ba137: 1}
+	}
+	return nil
+}
+
+// decayDB returns a database for a 4 time step simulation where a reactor
+// (agent 1) creates 1 kg of cs137 at time 0 and holds it until time 2 when
+// it is split off into a new resource and sent to a pool (agent 2) that
+// keeps it to the end of the simulation.
+func decayDB(t *testing.T) (db *sql.DB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "cyan-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	stmts := []string{
+		"CREATE TABLE Info (SimId BLOB,Duration INTEGER);",
+		"CREATE TABLE TimeList (SimId BLOB,Time INTEGER);",
+		"CREATE TABLE Agents (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER,ExitTime INTEGER);",
+		"CREATE TABLE Resources (SimId BLOB,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
+		"CREATE TABLE ResCreators (SimId BLOB,ResourceId INTEGER,AgentId INTEGER);",
+		"CREATE TABLE Compositions (SimId BLOB,QualId INTEGER,NucId INTEGER,MassFrac REAL);",
+		"CREATE TABLE Inventories (SimId BLOB,ResourceId INTEGER,AgentId INTEGER,StartTime INTEGER,EndTime INTEGER,QualId INTEGER,Quantity REAL);",
+	}
+	var args [][]interface{}
+	for range stmts {
+		args = append(args, nil)
+	}
+	add := func(s string, vals ...interface{}) {
+		stmts = append(stmts, s)
+		args = append(args, append([]interface{}{decaySimid}, vals...))
+	}
+
+	add("INSERT INTO Info VALUES (?,4);")
+	for i := 0; i < 4; i++ {
+		add("INSERT INTO TimeList VALUES (?,?);", i)
+	}
+	add("INSERT INTO Agents VALUES (?,1,'Facility',':agents:Source','reactor',-1,-1,0,NULL);")
+	add("INSERT INTO Agents VALUES (?,2,'Facility',':agents:Sink','pool',-1,-1,0,NULL);")
+	add("INSERT INTO Resources VALUES (?,1,1,'Material',0,1,'kg',1,0,0);")
+	add("INSERT INTO Resources VALUES (?,2,2,'Material',2,1,'kg',1,1,0);")
+	add("INSERT INTO ResCreators VALUES (?,1,1);")
+	add("INSERT INTO Compositions VALUES (?,1,?,1);", int(cs137))
+	add("INSERT INTO Inventories VALUES (?,1,1,0,2,1,1);")
+	add("INSERT INTO Inventories VALUES (?,2,2,2,?,1,1);", math.MaxInt32)
+
+	for i, s := range stmts {
+		if _, err := db.Exec(s, args[i]...); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	return db, cleanup
+}
+
+func TestDecayedInvSeries(t *testing.T) {
+	db, cleanup := decayDB(t)
+	defer cleanup()
+
+	// the material keeps decaying from its creation at time 0 after it is
+	// split off and moved to the pool
+	tests := []struct {
+		f    Filter
+		want []float64
+	}{
+		{Filter{Nucs: []nuc.Nuc{cs137}}, []float64{1, 0.5, 0.25, 0.125}},
+		{Filter{Nucs: []nuc.Nuc{ba137}}, []float64{0, 0.5, 0.75, 0.875}},
+		{Filter{}, []float64{1, 1, 1, 1}},
+		{Filter{Prototypes: []string{"pool"}, Nucs: []nuc.Nuc{cs137}}, []float64{0, 0, 0.25, 0.125}},
+		{Filter{Agents: []int{1}, Nucs: []nuc.Nuc{cs137}, T0: 1, T1: 3}, []float64{0.5, 0}},
+	}
+	for _, test := range tests {
+		xys, err := DecayedInvSeries(db, decaySimid, test.f, halfStep{})
+		if err != nil {
+			t.Fatal(err)
+		} else if len(xys) != len(test.want) {
+			t.Errorf("%+v: got %v, want %v", test.f, xys, test.want)
+			continue
+		}
+		for i, xy := range xys {
+			if math.Abs(xy.Y-test.want[i]) > 1e-9 {
+				t.Errorf("%+v: got %v, want %v", test.f, xys, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestDecayedMatCreated(t *testing.T) {
+	db, cleanup := decayDB(t)
+	defer cleanup()
+
+	tests := []struct {
+		f    Filter
+		want nuc.Material
+	}{
+		// decayed to the end of the simulation
+		{Filter{}, nuc.Material{cs137: 0.0625, ba137: 0.9375}},
+		{Filter{T1: 2}, nuc.Material{cs137: 0.25, ba137: 0.75}},
+		{Filter{Nucs: []nuc.Nuc{ba137}, T1: 3}, nuc.Material{ba137: 0.875}},
+		{Filter{Prototypes: []string{"pool"}}, nuc.Material{}},
+	}
+	for _, test := range tests {
+		m, err := DecayedMatCreated(db, decaySimid, test.f, halfStep{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := range m {
+			if _, ok := test.want[n]; !ok && m[n] > 1e-9 {
+				t.Errorf("%+v: got %v, want %v", test.f, m, test.want)
+			}
+		}
+		for n, qty := range test.want {
+			if math.Abs(float64(m[n]-qty)) > 1e-9 {
+				t.Errorf("%+v: got %v of %v, want %v", test.f, m[n], n, qty)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package diff compares the results of two cyclus simulations - usually of
+// the same scenario in separate databases before and after a change to an
+// archetype or input file.
+package diff
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/rwcarlsen/cyan/post"
+	"github.com/rwcarlsen/cyan/query"
+)
+
+// Names of the metrics compared by Compare.
+const (
+	// Deployed compares the number of agents ever deployed per prototype.
+	Deployed = "deployed"
+	// Commods compares the total quantity transacted per commodity.
+	Commods = "commods"
+	// Inventory compares the total inventory of every nuclide at the end of
+	// the simulation.
+	Inventory = "inventory"
+	// Power compares the total power produced at every time step.
+	Power = "power"
+)
+
+// AllMetrics lists the names of every available metric.
+var AllMetrics = []string{Deployed, Commods, Inventory, Power}
+
+// DefaultTol is the default tolerance for comparing quantities.
+const DefaultTol = 1e-6
+
+// Difference describes a single quantity that differs between the two
+// simulations.  Quantities missing from one of the simulations (e.g. a
+// prototype that was never deployed) are zero.
+type Difference struct {
+	Metric string `json:"metric"`
+	// Key identifies the compared quantity within its metric - i.e. a
+	// prototype, commodity, nuclide or time step.
+	Key string  `json:"key"`
+	A   float64 `json:"a"`
+	B   float64 `json:"b"`
+	// Abs is B-A.
+	Abs float64 `json:"abs"`
+	// Rel is Abs relative to the larger magnitude of A and B.
+	Rel float64 `json:"rel"`
+}
+
+// Report holds the results of comparing two simulations.
+type Report struct {
+	SimIdA      string       `json:"simid_a"`
+	SimIdB      string       `json:"simid_b"`
+	Tol         float64      `json:"tolerance"`
+	Metrics     []string     `json:"metrics"`
+	Differences []Difference `json:"differences"`
+}
+
+// OK returns true if no differences were found.
+func (r *Report) OK() bool { return len(r.Differences) == 0 }
+
+// Compare computes the named metrics (all of them if none are given) for
+// simulation simidA in dbA and simidB in dbB and reports every quantity
+// that differs.  Both databases must already be post processed.  Quantities
+// a and b are considered equal if |a-b| <= tol*max(1,|a|,|b|).
+func Compare(dbA *sql.DB, simidA []byte, dbB *sql.DB, simidB []byte, tol float64, metrics ...string) (*Report, error) {
+	if len(metrics) == 0 {
+		metrics = AllMetrics
+	}
+	r := &Report{
+		SimIdA:      fmt.Sprintf("%x", simidA),
+		SimIdB:      fmt.Sprintf("%x", simidB),
+		Tol:         tol,
+		Metrics:     metrics,
+		Differences: []Difference{},
+	}
+
+	for _, name := range metrics {
+		var fn func(*sql.DB, []byte) (map[string]float64, error)
+		switch name {
+		case Deployed:
+			fn = deployed
+		case Commods:
+			fn = commods
+		case Inventory:
+			fn = inventory
+		case Power:
+			fn = power
+		default:
+			return nil, fmt.Errorf("unknown metric %q", name)
+		}
+
+		a, err := fn(dbA, simidA)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+		b, err := fn(dbB, simidB)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+		r.Differences = append(r.Differences, differences(name, a, b, tol)...)
+	}
+	return r, nil
+}
+
+// differences returns the differing quantities of a metric ordered by key.
+// Keys that are all integers (i.e. time steps) are ordered numerically.
+func differences(metric string, a, b map[string]float64, tol float64) []Difference {
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+
+	var ds []Difference
+	for k := range keys {
+		x, y := a[k], b[k]
+		if equal(x, y, tol) {
+			continue
+		}
+		d := Difference{Metric: metric, Key: k, A: x, B: y, Abs: y - x}
+		d.Rel = d.Abs / math.Max(math.Abs(x), math.Abs(y))
+		ds = append(ds, d)
+	}
+
+	sort.Slice(ds, func(i, j int) bool {
+		x, errx := strconv.Atoi(ds[i].Key)
+		y, erry := strconv.Atoi(ds[j].Key)
+		if errx == nil && erry == nil {
+			return x < y
+		}
+		return ds[i].Key < ds[j].Key
+	})
+	return ds
+}
+
+func deployed(db *sql.DB, simid []byte) (map[string]float64, error) {
+	s := "SELECT Prototype,COUNT(*) FROM Agents WHERE SimId = ? AND Kind = 'Facility' GROUP BY Prototype;"
+	return keyed(db, s, simid)
+}
+
+func commods(db *sql.DB, simid []byte) (map[string]float64, error) {
+	s := `SELECT t.Commodity,TOTAL(r.Quantity) FROM Transactions AS t
+		JOIN Resources AS r ON r.ResourceId = t.ResourceId AND r.SimId = t.SimId
+		WHERE t.SimId = ? GROUP BY t.Commodity;`
+	return keyed(db, s, simid)
+}
+
+func inventory(db *sql.DB, simid []byte) (map[string]float64, error) {
+	m, err := query.InvAt(db, simid, -1, query.Filter{})
+	if err != nil {
+		return nil, err
+	}
+	vals := map[string]float64{}
+	for n, qty := range m {
+		vals[fmt.Sprint(n)] = float64(qty)
+	}
+	return vals, nil
+}
+
+// power returns no values for simulations without a TimeSeriesPower table
+// (i.e. without any facilities reporting power).
+func power(db *sql.DB, simid []byte) (map[string]float64, error) {
+	if ok, err := post.TableExists(db, "TimeSeriesPower"); err != nil {
+		return nil, err
+	} else if !ok {
+		return map[string]float64{}, nil
+	}
+	s := `SELECT tl.Time,TOTAL(p.Value) FROM TimeList AS tl
+		LEFT JOIN TimeSeriesPower AS p ON p.Time = tl.Time AND p.SimId = tl.SimId
+		WHERE tl.SimId = ? GROUP BY tl.Time;`
+	return keyed(db, s, simid)
+}
+
+// keyed runs a query returning key-value rows.
+func keyed(db *sql.DB, s string, args ...interface{}) (map[string]float64, error) {
+	rows, err := db.Query(s, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vals := map[string]float64{}
+	for rows.Next() {
+		var k string
+		var v float64
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		vals[k] = v
+	}
+	return vals, rows.Err()
+}
+
+func equal(a, b, tol float64) bool {
+	return math.Abs(a-b) <= tol*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
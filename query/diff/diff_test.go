@@ -0,0 +1,120 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/post"
+	_ "github.com/rwcarlsen/go-sqlite3"
+)
+
+var schema = []string{
+	"CREATE TABLE Info (SimId BLOB,Duration INTEGER);",
+	"CREATE TABLE AgentEntry (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER);",
+	"CREATE TABLE Resources (SimId BLOB,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
+	"CREATE TABLE ResCreators (SimId BLOB,ResourceId INTEGER,AgentId INTEGER);",
+	"CREATE TABLE Transactions (SimId BLOB,TransactionId INTEGER,SenderId INTEGER,ReceiverId INTEGER,ResourceId INTEGER,Commodity TEXT,Time INTEGER);",
+	"CREATE TABLE Compositions (SimId BLOB,QualId INTEGER,NucId INTEGER,MassFrac REAL);",
+}
+
+var simid = []byte("diff-simid")
+
+func TestCompare(t *testing.T) {
+	a, cleanup := build(t, 100, true)
+	defer cleanup()
+	b, cleanup := build(t, 150, false)
+	defer cleanup()
+
+	r, err := Compare(a, simid, a, simid, DefaultTol)
+	if err != nil {
+		t.Fatal(err)
+	} else if !r.OK() {
+		t.Errorf("identical simulations differ: %+v", r.Differences)
+	}
+
+	r, err = Compare(a, simid, b, simid, DefaultTol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Difference{
+		{Commods, "fuel", 100, 150, 50, 50.0 / 150},
+		{Inventory, "922350000", 100, 150, 50, 50.0 / 150},
+		{Power, "2", 1000, 0, -1000, -1},
+		{Power, "3", 1000, 0, -1000, -1},
+	}
+	if len(r.Differences) != len(want) {
+		t.Fatalf("got %v differences, want %v: %+v", len(r.Differences), len(want), r.Differences)
+	}
+	for i, d := range r.Differences {
+		if d != want[i] {
+			t.Errorf("difference %v: got %+v, want %+v", i, d, want[i])
+		}
+	}
+
+	// both differences are 1/3 relative
+	r, err = Compare(a, simid, b, simid, 0.5, Commods, Inventory)
+	if err != nil {
+		t.Fatal(err)
+	} else if !r.OK() {
+		t.Errorf("differences within tolerance reported: %+v", r.Differences)
+	}
+
+	if _, err := Compare(a, simid, b, simid, DefaultTol, "bogus"); err == nil {
+		t.Error("got nil error for unknown metric")
+	}
+}
+
+// build creates a database in which a mine (agent 1) creates qty kg of U235
+// and sends it to a reactor (agent 2) producing 1000 MWe at time steps 2 and
+// 3 if power is true.
+func build(t *testing.T, qty float64, power bool) (db *sql.DB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "cyan-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+	db, err = sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	stmts := append([]string{}, schema...)
+	args := make([][]interface{}, len(stmts))
+	add := func(s string, vals ...interface{}) {
+		stmts = append(stmts, s)
+		args = append(args, append([]interface{}{simid}, vals...))
+	}
+	add("INSERT INTO Info VALUES (?,4);")
+	add("INSERT INTO AgentEntry VALUES (?,1,'Facility',':agents:Source','mine',-1,-1,0);")
+	add("INSERT INTO AgentEntry VALUES (?,2,'Facility',':agents:Sink','reactor',-1,-1,0);")
+	add("INSERT INTO Resources VALUES (?,1,1,'Material',0,?,'kg',1,0,0);", qty)
+	add("INSERT INTO ResCreators VALUES (?,1,1);")
+	add("INSERT INTO Transactions VALUES (?,1,1,2,1,'fuel',1);")
+	add("INSERT INTO Compositions VALUES (?,1,922350000,1);")
+	if power {
+		stmts = append(stmts, "CREATE TABLE TimeSeriesPower (SimId BLOB,AgentId INTEGER,Time INTEGER,Value REAL);")
+		args = append(args, nil)
+		add("INSERT INTO TimeSeriesPower VALUES (?,2,2,1000);")
+		add("INSERT INTO TimeSeriesPower VALUES (?,2,3,1000);")
+	}
+	for i, s := range stmts {
+		if _, err := db.Exec(s, args[i]...); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := post.Process(context.Background(), db); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return db, cleanup
+}
@@ -0,0 +1,145 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+// Filter restricts the agents, commodities, nuclides and times a query
+// considers.  Empty fields don't restrict anything.  Values within a field
+// are alternatives while separate fields must all match.  Filters are
+// rendered as parameterized SQL, so values never need quoting or escaping.
+type Filter struct {
+	Agents     []int
+	Prototypes []string
+	// Specs matches agent archetype specs either exactly (e.g.
+	// ":cycamore:Reactor") or by archetype name (e.g. "Reactor").
+	Specs   []string
+	Commods []string
+	Nucs    []nuc.Nuc
+	// Elements matches every nuclide with one of the atomic numbers in
+	// addition to those in Nucs.
+	Elements []int
+	// T0 and T1 restrict times to the interval [T0,T1).  T1 <= 0 means
+	// end-of-simulation.
+	T0, T1 int
+}
+
+// Agent returns a copy of f with only its agent fields (Agents, Prototypes
+// and Specs).
+func (f Filter) Agent() Filter {
+	return Filter{Agents: f.Agents, Prototypes: f.Prototypes, Specs: f.Specs}
+}
+
+// nucs returns the nuclides of m matching the Nucs and Elements fields of f
+// for queries that match nuclides after computing materials (e.g. decay).
+func (f Filter) nucs(m nuc.Material) nuc.Material {
+	if len(f.Nucs)+len(f.Elements) == 0 {
+		return m
+	}
+	isNuc, isElt := nuc.Nucs(f.Nucs...), nuc.Elements(f.Elements...)
+	return m.Filter(func(n nuc.Nuc) bool { return isNuc(n) || isElt(n) })
+}
+
+// Cols names the SQL column expressions a query applies each Filter field
+// to.  Queries leave the columns of fields they can't filter by empty.
+type Cols struct {
+	Agent  string
+	Proto  string
+	Spec   string
+	Commod string
+	// Nuc is the nuclide id column used for both the Nucs and Elements
+	// fields.
+	Nuc  string
+	Time string
+}
+
+// SQL renders f as a sequence of " AND ..." conditions on the columns in c
+// and returns them with the arguments for their placeholders.  An error is
+// returned if f restricts a field that c has no column for.
+func (f Filter) SQL(c Cols) (string, []interface{}, error) {
+	var buf strings.Builder
+	var args []interface{}
+	var err error
+	cond := func(field, col string, n int, s string, vals ...interface{}) {
+		if n == 0 || err != nil {
+			return
+		} else if col == "" {
+			err = fmt.Errorf("query does not support filtering by %v", field)
+			return
+		}
+		buf.WriteString(" AND " + s)
+		args = append(args, vals...)
+	}
+
+	agents := make([]interface{}, len(f.Agents))
+	for i, a := range f.Agents {
+		agents[i] = a
+	}
+	cond("agent", c.Agent, len(agents), in(c.Agent, len(agents)), agents...)
+
+	protos := strs(f.Prototypes)
+	cond("prototype", c.Proto, len(protos), in(c.Proto, len(protos)), protos...)
+
+	// match the spec exactly or its trailing ":<archetype>" part
+	var specs []interface{}
+	var alts []string
+	for _, s := range f.Specs {
+		alts = append(alts, c.Spec+" = ? OR substr("+c.Spec+", ?) = ?")
+		specs = append(specs, s, -utf8.RuneCountInString(s)-1, ":"+s)
+	}
+	cond("spec", c.Spec, len(specs), "("+strings.Join(alts, " OR ")+")", specs...)
+
+	commods := strs(f.Commods)
+	cond("commodity", c.Commod, len(commods), in(c.Commod, len(commods)), commods...)
+
+	// nuclides match if they are listed or their element is
+	var nucs []interface{}
+	var nalts []string
+	if len(f.Nucs) > 0 {
+		for _, n := range f.Nucs {
+			nucs = append(nucs, int(n))
+		}
+		nalts = append(nalts, in(c.Nuc, len(f.Nucs)))
+	}
+	if len(f.Elements) > 0 {
+		for _, z := range f.Elements {
+			nucs = append(nucs, z)
+		}
+		nalts = append(nalts, in(c.Nuc+" / 10000000", len(f.Elements)))
+	}
+	cond("nuclide", c.Nuc, len(nucs), "("+strings.Join(nalts, " OR ")+")", nucs...)
+
+	if f.T0 > 0 {
+		cond("time", c.Time, 1, c.Time+" >= ?", f.T0)
+	}
+	if f.T1 > 0 {
+		cond("time", c.Time, 1, c.Time+" < ?", f.T1)
+	}
+
+	if err != nil {
+		return "", nil, err
+	}
+	return buf.String(), args, nil
+}
+
+// in returns an SQL condition matching col against n placeholders.
+func in(col string, n int) string {
+	if n == 0 {
+		return ""
+	} else if n == 1 {
+		return col + " = ?"
+	}
+	return col + " IN (?" + strings.Repeat(",?", n-1) + ")"
+}
+
+func strs(ss []string) []interface{} {
+	vals := make([]interface{}, len(ss))
+	for i, s := range ss {
+		vals[i] = s
+	}
+	return vals
+}
@@ -0,0 +1,64 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/nuc"
+)
+
+func TestFilter_SQL(t *testing.T) {
+	cols := Cols{Agent: "a.Id", Proto: "a.Proto", Spec: "a.Spec", Commod: "t.Commod", Nuc: "c.Nuc", Time: "t.Time"}
+	var tests = []struct {
+		F    Filter
+		Cols Cols
+		SQL  string
+		Args []interface{}
+		Err  bool
+	}{
+		{Filter{}, Cols{}, "", nil, false},
+		{Filter{T1: -1}, cols, "", nil, false},
+		{
+			Filter{Agents: []int{1, 2}, Prototypes: []string{"o'brien"}},
+			cols,
+			" AND a.Id IN (?,?) AND a.Proto = ?",
+			[]interface{}{1, 2, "o'brien"},
+			false,
+		},
+		{
+			Filter{Specs: []string{"Reactor"}, Commods: []string{"fuel"}},
+			cols,
+			" AND (a.Spec = ? OR substr(a.Spec, ?) = ?) AND t.Commod = ?",
+			[]interface{}{"Reactor", -8, ":Reactor", "fuel"},
+			false,
+		},
+		{
+			Filter{Nucs: []nuc.Nuc{922350000}, Elements: []int{94}, T0: 2, T1: 5},
+			cols,
+			" AND (c.Nuc = ? OR c.Nuc / 10000000 = ?) AND t.Time >= ? AND t.Time < ?",
+			[]interface{}{922350000, 94, 2, 5},
+			false,
+		},
+		{Filter{Commods: []string{"fuel"}}, Cols{Agent: "a.Id"}, "", nil, true},
+		{Filter{Elements: []int{92}}, Cols{Time: "t.Time"}, "", nil, true},
+	}
+
+	for i, test := range tests {
+		s, args, err := test.F.SQL(test.Cols)
+		if test.Err {
+			if err == nil {
+				t.Errorf("test %v: expected an unsupported filter error", i)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("test %v: %v", i, err)
+			continue
+		}
+		if s != test.SQL {
+			t.Errorf("test %v: got sql %q, want %q", i, s, test.SQL)
+		}
+		if !reflect.DeepEqual(args, test.Args) {
+			t.Errorf("test %v: got args %v, want %v", i, args, test.Args)
+		}
+	}
+}
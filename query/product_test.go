@@ -0,0 +1,129 @@
+package query
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/rwcarlsen/go-sqlite3"
+)
+
+var productSimid = []byte("simid-product")
+
+// productDB returns a database where a plant (agent 1) creates 10 units of
+// natural_gas at time 0 that it sends to a user (agent 2) at time 2 and 5
+// units of steam at time 1 that it keeps.  It also holds 7 kg of material
+// that must not show up in Product queries.
+func productDB(t *testing.T) (db *sql.DB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "cyan-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	stmts := []string{
+		"CREATE TABLE Info (SimId BLOB,Duration INTEGER);",
+		"CREATE TABLE Agents (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER,ExitTime INTEGER);",
+		"CREATE TABLE Resources (SimId BLOB,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
+		"CREATE TABLE Products (SimId BLOB,QualId INTEGER,Quality TEXT);",
+		"CREATE TABLE Transactions (SimId BLOB,TransactionId INTEGER,SenderId INTEGER,ReceiverId INTEGER,ResourceId INTEGER,Commodity TEXT,Time INTEGER);",
+		"CREATE TABLE Inventories (SimId BLOB,ResourceId INTEGER,AgentId INTEGER,StartTime INTEGER,EndTime INTEGER,QualId INTEGER,Quantity REAL);",
+	}
+	var args [][]interface{}
+	for range stmts {
+		args = append(args, nil)
+	}
+	add := func(s string, vals ...interface{}) {
+		stmts = append(stmts, s)
+		args = append(args, append([]interface{}{productSimid}, vals...))
+	}
+
+	add("INSERT INTO Info VALUES (?,4);")
+	add("INSERT INTO Agents VALUES (?,1,'Facility',':agents:Source','plant',-1,-1,0,NULL);")
+	add("INSERT INTO Agents VALUES (?,2,'Facility',':agents:Sink','user',-1,-1,0,NULL);")
+	add("INSERT INTO Resources VALUES (?,1,1,'Product',0,10,'',1,0,0);")
+	add("INSERT INTO Resources VALUES (?,2,2,'Product',1,5,'',2,0,0);")
+	add("INSERT INTO Resources VALUES (?,3,3,'Material',0,7,'kg',3,0,0);")
+	add("INSERT INTO Products VALUES (?,1,'natural_gas');")
+	add("INSERT INTO Products VALUES (?,2,'steam');")
+	add("INSERT INTO Transactions VALUES (?,1,1,2,1,'gas',2);")
+	add("INSERT INTO Transactions VALUES (?,2,1,2,3,'gas',3);")
+	add("INSERT INTO Inventories VALUES (?,1,1,0,2,1,10);")
+	add("INSERT INTO Inventories VALUES (?,1,2,2,?,1,10);", math.MaxInt32)
+	add("INSERT INTO Inventories VALUES (?,2,1,1,?,2,5);", math.MaxInt32)
+	add("INSERT INTO Inventories VALUES (?,3,1,0,3,3,7);")
+	add("INSERT INTO Inventories VALUES (?,3,2,3,?,3,7);", math.MaxInt32)
+
+	for i, s := range stmts {
+		if _, err := db.Exec(s, args[i]...); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	return db, cleanup
+}
+
+func TestProductInvAt(t *testing.T) {
+	db, cleanup := productDB(t)
+	defer cleanup()
+
+	tests := []struct {
+		t    int
+		f    Filter
+		want map[string]float64
+	}{
+		{0, Filter{}, map[string]float64{"natural_gas": 10}},
+		{1, Filter{}, map[string]float64{"natural_gas": 10, "steam": 5}},
+		{-1, Filter{}, map[string]float64{"natural_gas": 10, "steam": 5}},
+		{3, Filter{Agents: []int{1}}, map[string]float64{"steam": 5}},
+		{3, Filter{Prototypes: []string{"user"}}, map[string]float64{"natural_gas": 10}},
+		{1, Filter{Specs: []string{"Sink"}}, map[string]float64{}},
+	}
+	for _, test := range tests {
+		got, err := ProductInvAt(db, productSimid, test.t, test.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ProductInvAt(%v, %+v): got %v, want %v", test.t, test.f, got, test.want)
+		}
+	}
+}
+
+func TestProductFlow(t *testing.T) {
+	db, cleanup := productDB(t)
+	defer cleanup()
+
+	tests := []struct {
+		f, from, to Filter
+		want        map[string]float64
+	}{
+		{Filter{}, Filter{}, Filter{}, map[string]float64{"natural_gas": 10}},
+		{Filter{Commods: []string{"gas"}}, Filter{Prototypes: []string{"plant"}}, Filter{Agents: []int{2}}, map[string]float64{"natural_gas": 10}},
+		{Filter{Commods: []string{"steam"}}, Filter{}, Filter{}, map[string]float64{}},
+		{Filter{}, Filter{Prototypes: []string{"user"}}, Filter{}, map[string]float64{}},
+		{Filter{T0: 2, T1: 3}, Filter{}, Filter{}, map[string]float64{"natural_gas": 10}},
+		{Filter{T1: 2}, Filter{}, Filter{}, map[string]float64{}},
+	}
+	for _, test := range tests {
+		got, err := ProductFlow(db, productSimid, test.f, test.from, test.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ProductFlow(%+v, %+v, %+v): got %v, want %v", test.f, test.from, test.to, got, test.want)
+		}
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"database/sql"
 	"fmt"
-	"strconv"
+	"sort"
 
 	"github.com/rwcarlsen/cyan/nuc"
 )
@@ -75,18 +75,19 @@ func (ai AgentInfo) String() string {
 		ai.Kind, ai.Impl, ai.Proto, ai.Parent, ai.Lifetime, ai.Enter, ai.Exit)
 }
 
-func AllAgents(db *sql.DB, simid []byte, proto string) (ags []AgentInfo, err error) {
+// AllAgents returns info for all agents in the simulation that match the
+// agent fields of f.
+func AllAgents(db *sql.DB, simid []byte, f Filter) (ags []AgentInfo, err error) {
+	filt, fargs, err := f.SQL(Cols{Agent: "AgentId", Proto: "Prototype", Spec: "Spec"})
+	if err != nil {
+		return nil, err
+	}
 	s := `SELECT AgentId,Kind,Spec,Prototype,ParentId,EnterTime,ExitTime,Lifetime FROM
 				Agents
 			WHERE Agents.SimId = ?`
+	s += filt
 
-	var rows *sql.Rows
-	if proto != "" {
-		s += ` AND Agents.Prototype = ?`
-		rows, err = db.Query(s, simid, proto)
-	} else {
-		rows, err = db.Query(s, simid)
-	}
+	rows, err := db.Query(s, append([]interface{}{simid}, fargs...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +110,13 @@ func AllAgents(db *sql.DB, simid []byte, proto string) (ags []AgentInfo, err err
 	return ags, nil
 }
 
-func DeployCumulative(db *sql.DB, simid []byte, proto string) (xys []XY, err error) {
+// DeployCumulative returns a time series of the number of deployed agents
+// matching the agent fields of f.
+func DeployCumulative(db *sql.DB, simid []byte, f Filter) (xys []XY, err error) {
+	filt, fargs, err := f.SQL(Cols{Agent: "ag.AgentId", Proto: "ag.Prototype", Spec: "ag.Spec"})
+	if err != nil {
+		return nil, err
+	}
 	sql := `SELECT Time, IFNULL(Count, 0) FROM 
 			TimeList LEFT JOIN
 			(SELECT ti.Time AS Timestep,COUNT(*) AS Count FROM
@@ -117,11 +124,13 @@ func DeployCumulative(db *sql.DB, simid []byte, proto string) (xys []XY, err err
 				JOIN Agents AS ag ON (ti.Time >= ag.EnterTime) AND (ag.ExitTime >= ti.Time OR ag.ExitTime IS NULL)
 				WHERE
 				    ti.SimId = ag.SimId
-					AND ag.SimId = ?
-					AND ag.Prototype = ?
+					AND ag.SimId = ?`
+	sql += filt
+	sql += `
 				GROUP BY ti.Time
-				ORDER BY ti.Time) AS foo ON foo.Timestep = TimeList.Time;`
-	rows, err := db.Query(sql, simid, proto)
+				ORDER BY ti.Time) AS foo ON foo.Timestep = TimeList.Time
+			WHERE TimeList.SimId = ?;`
+	rows, err := db.Query(sql, append(append([]interface{}{simid}, fargs...), simid)...)
 	if err != nil {
 		return nil, err
 	}
@@ -144,16 +153,23 @@ type XY struct {
 	Y float64
 }
 
-func InvSeries(db *sql.DB, simid []byte, agent int, iso int) (xys []XY, err error) {
+// InvSeries returns a time series of the total inventory mass of the agents
+// and nuclides matching f.
+func InvSeries(db *sql.DB, simid []byte, f Filter) (xys []XY, err error) {
+	filt, fargs, err := f.SQL(Cols{Agent: "ag.AgentId", Proto: "ag.Prototype", Spec: "ag.Spec", Nuc: "cmp.NucId", Time: "ti.Time"})
+	if err != nil {
+		return nil, err
+	}
 	sql := `SELECT ti.Time,SUM(cmp.MassFrac * inv.Quantity) FROM (
 				Compositions AS cmp
 				INNER JOIN Inventories AS inv ON inv.QualId = cmp.QualId
 				INNER JOIN TimeList AS ti ON (ti.Time >= inv.StartTime AND ti.Time < inv.EndTime)
+				INNER JOIN Agents AS ag ON ag.AgentId = inv.AgentId
 			) WHERE (
-				inv.SimId = ? AND inv.SimId = cmp.SimId AND ti.SimId = inv.SimId
-				AND inv.AgentId = ? AND cmp.NucId = ?
-			) GROUP BY ti.Time,cmp.NucId;`
-	rows, err := db.Query(sql, simid, agent, iso)
+				inv.SimId = ? AND inv.SimId = cmp.SimId AND ti.SimId = inv.SimId AND ag.SimId = inv.SimId`
+	sql += filt
+	sql += `) GROUP BY ti.Time;`
+	rows, err := db.Query(sql, append([]interface{}{simid}, fargs...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -170,43 +186,299 @@ func InvSeries(db *sql.DB, simid []byte, agent int, iso int) (xys []XY, err erro
 	return xys, nil
 }
 
-// MatCreated returns the total amount of material created by the listed
-// agent ids in the simulation for the given sim id between t0 and t1. Passing no
-// agents defaults to all agents. Use t0=0 to specify beginning-of-simulation.
-// Use t1=-1 to specify end-of-simulation.
-func MatCreated(db *sql.DB, simid []byte, t0, t1 int, agents ...int) (m nuc.Material, err error) {
-	if t1 == -1 {
+// MatXY is the material at time step X.
+type MatXY struct {
+	X   int
+	Mat nuc.Material
+}
+
+// InvMatSeries returns a time series of the material inventory of the agents
+// and nuclides matching f.  The series has an entry for every time step of
+// the simulation matching f.
+func InvMatSeries(db *sql.DB, simid []byte, f Filter) (xys []MatXY, err error) {
+	filt, fargs, err := f.SQL(Cols{Agent: "ag.AgentId", Proto: "ag.Prototype", Spec: "ag.Spec", Nuc: "cmp.NucId", Time: "ti.Time"})
+	if err != nil {
+		return nil, err
+	}
+	times, err := timeSteps(db, simid, f)
+	if err != nil {
+		return nil, err
+	}
+	index := map[int]int{}
+	for i, t := range times {
+		index[t] = i
+		xys = append(xys, MatXY{X: t, Mat: nuc.Material{}})
+	}
+
+	sql := `SELECT ti.Time,cmp.NucId,SUM(cmp.MassFrac * inv.Quantity) FROM (
+				Compositions AS cmp
+				INNER JOIN Inventories AS inv ON inv.QualId = cmp.QualId
+				INNER JOIN TimeList AS ti ON (ti.Time >= inv.StartTime AND ti.Time < inv.EndTime)
+				INNER JOIN Agents AS ag ON ag.AgentId = inv.AgentId
+			) WHERE (
+				inv.SimId = ? AND inv.SimId = cmp.SimId AND ti.SimId = inv.SimId AND ag.SimId = inv.SimId`
+	sql += filt
+	sql += `) GROUP BY ti.Time,cmp.NucId;`
+	rows, err := db.Query(sql, append([]interface{}{simid}, fargs...)...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var t, n int
+		var qty float64
+		if err := rows.Scan(&t, &n, &qty); err != nil {
+			return nil, err
+		}
+		if i, ok := index[t]; ok {
+			xys[i].Mat[nuc.Nuc(n)] = nuc.Mass(qty)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return xys, nil
+}
+
+// ActivitySeries returns a time series of the total activity in Bq of the
+// inventory of the agents and nuclides matching f.
+func ActivitySeries(db *sql.DB, simid []byte, f Filter, d nuc.DecayData) ([]XY, error) {
+	return decaySeries(db, simid, f, d, nuc.Activity)
+}
+
+// DecayHeatSeries returns a time series of the total decay heat in Watts of
+// the inventory of the agents and nuclides matching f.
+func DecayHeatSeries(db *sql.DB, simid []byte, f Filter, d nuc.DecayData) ([]XY, error) {
+	return decaySeries(db, simid, f, d, nuc.DecayHeat)
+}
+
+func decaySeries(db *sql.DB, simid []byte, f Filter, d nuc.DecayData, fn func(nuc.Material, nuc.DecayData) map[nuc.Nuc]float64) ([]XY, error) {
+	mats, err := InvMatSeries(db, simid, f)
+	if err != nil {
+		return nil, err
+	}
+	xys := make([]XY, len(mats))
+	for i, m := range mats {
+		xys[i] = XY{X: m.X, Y: nuc.Total(fn(m.Mat, d))}
+	}
+	return xys, nil
+}
+
+// DecayedInvSeries returns a time series of the total inventory mass of the
+// agents and nuclides matching f like InvSeries, but with the material of
+// each inventory decayed using d for its age (see resourceOrigins).
+// Nuclides are matched after decay, so daughters grown into inventories are
+// included.
+func DecayedInvSeries(db *sql.DB, simid []byte, f Filter, d nuc.DecayData) (xys []XY, err error) {
+	dt, err := TimeStepDur(db, simid)
+	if err != nil {
+		return nil, err
+	}
+	filt, fargs, err := f.Agent().SQL(Cols{Agent: "ag.AgentId", Proto: "ag.Prototype", Spec: "ag.Spec"})
+	if err != nil {
+		return nil, err
+	}
+	times, err := timeSteps(db, simid, f)
+	if err != nil {
+		return nil, err
+	}
+	index := map[int]int{}
+	for i, t := range times {
+		index[t] = i
+		xys = append(xys, XY{X: t})
+	}
+	origins, err := resourceOrigins(db, simid)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `SELECT inv.ResourceId,inv.StartTime,inv.EndTime,cmp.NucId,SUM(cmp.MassFrac * inv.Quantity) FROM (
+				Compositions AS cmp
+				INNER JOIN Inventories AS inv ON inv.QualId = cmp.QualId
+				INNER JOIN Agents AS ag ON ag.AgentId = inv.AgentId
+			) WHERE (
+				inv.SimId = ? AND inv.SimId = cmp.SimId AND ag.SimId = inv.SimId`
+	sql += filt
+	sql += `) GROUP BY inv.ResourceId,inv.StartTime,inv.EndTime,cmp.NucId;`
+	rows, err := db.Query(sql, append([]interface{}{simid}, fargs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// inventory material keyed by the time span it was held over and the
+	// time its resources originated
+	type span struct{ t0, t1, origin int }
+	spans := map[span]nuc.Material{}
+	for rows.Next() {
+		var id, t0, t1, n int
+		var qty float64
+		if err := rows.Scan(&id, &t0, &t1, &n, &qty); err != nil {
+			return nil, err
+		}
+		origin, ok := origins[id]
+		if !ok {
+			origin = t0
+		}
+		sp := span{t0, t1, origin}
+		if spans[sp] == nil {
+			spans[sp] = nuc.Material{}
+		}
+		spans[sp][nuc.Nuc(n)] += nuc.Mass(qty)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// inventory material keyed by time step and age
+	type key struct{ t, age int }
+	mats := map[key]nuc.Material{}
+	for sp, m := range spans {
+		// open inventories end at math.MaxInt32, so only visit the listed
+		// time steps
+		for _, t := range times[sort.SearchInts(times, sp.t0):] {
+			if t >= sp.t1 {
+				break
+			}
+			k := key{t, t - sp.origin}
+			if mats[k] == nil {
+				mats[k] = nuc.Material{}
+			}
+			for n, qty := range m {
+				mats[k][n] += qty
+			}
+		}
+	}
+
+	decayer := nuc.NewDecayer(d)
+	for k, m := range mats {
+		decayed := f.nucs(decayer.Decay(m, float64(k.age)*dt))
+		xys[index[k.t]].Y += float64(decayed.Mass())
+	}
+	return xys, nil
+}
+
+// resourceOrigins returns the time step at which the material of each
+// resource of simid originated keyed by resource id.  Resources split off
+// from or otherwise derived from a single parent with the same composition
+// (e.g. when transferred) keep the parent's origin, so their age runs on
+// across Inventories rows.  Created, transmuted and combined resources
+// originate at their creation since their composition is new.
+func resourceOrigins(db *sql.DB, simid []byte) (map[int]int, error) {
+	// cyclus assigns resource ids in order of creation, so parents are
+	// always seen before their children
+	rows, err := db.Query("SELECT ResourceId,QualId,TimeCreated,Parent1,Parent2 FROM Resources WHERE SimId = ? ORDER BY ResourceId;", simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	origins := map[int]int{}
+	quals := map[int]int{}
+	for rows.Next() {
+		var id, qual, t, p1, p2 int
+		if err := rows.Scan(&id, &qual, &t, &p1, &p2); err != nil {
+			return nil, err
+		}
+		quals[id] = qual
+		origins[id] = t
+		if o, ok := origins[p1]; ok && p2 == 0 && quals[p1] == qual {
+			origins[id] = o
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return origins, nil
+}
+
+// DecayedMatCreated returns the material created by the agents and between
+// the times matching f like MatCreated, but with each resource decayed using
+// d from its creation to the end of f's time interval (or the simulation).
+// Nuclides are matched after decay.
+func DecayedMatCreated(db *sql.DB, simid []byte, f Filter, d nuc.DecayData) (nuc.Material, error) {
+	dt, err := TimeStepDur(db, simid)
+	if err != nil {
+		return nil, err
+	}
+	end := f.T1
+	if end <= 0 {
 		si, err := SimStat(db, simid)
 		if err != nil {
 			return nil, err
 		}
-		t1 = si.Duration
+		end = si.Duration
 	}
-	filt := ""
-	if len(agents) > 0 {
-		filt += " AND cre.AgentId IN (" + strconv.Itoa(agents[0])
-		for _, a := range agents[1:] {
-			filt += "," + strconv.Itoa(a)
+
+	cf := f.Agent()
+	cf.T0, cf.T1 = f.T0, f.T1
+	filt, fargs, err := cf.SQL(Cols{Agent: "cre.AgentId", Proto: "ag.Prototype", Spec: "ag.Spec", Time: "res.TimeCreated"})
+	if err != nil {
+		return nil, err
+	}
+	sql := `SELECT res.TimeCreated,cmp.NucId,SUM(cmp.MassFrac * res.Quantity) FROM (
+				Resources As res
+				INNER JOIN Compositions AS cmp ON res.QualId = cmp.QualId
+				INNER JOIN ResCreators AS cre ON res.ResourceId = cre.ResourceId
+				INNER JOIN Agents AS ag ON ag.AgentId = cre.AgentId
+			) WHERE (
+				cre.SimId = ? AND cre.SimId = res.SimId AND cre.SimId = cmp.SimId AND cre.SimId = ag.SimId`
+	sql += filt
+	sql += `) GROUP BY res.TimeCreated,cmp.NucId;`
+	rows, err := db.Query(sql, append([]interface{}{simid}, fargs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	created := map[int]nuc.Material{}
+	for rows.Next() {
+		var t, n int
+		var qty float64
+		if err := rows.Scan(&t, &n, &qty); err != nil {
+			return nil, err
+		}
+		if created[t] == nil {
+			created[t] = nuc.Material{}
 		}
-		filt += ") "
+		created[t][nuc.Nuc(n)] += nuc.Mass(qty)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	decayer := nuc.NewDecayer(d)
+	m := nuc.Material{}
+	for t, mat := range created {
+		m = m.Add(decayer.Decay(mat, float64(end-t)*dt))
+	}
+	return f.nucs(m), nil
+}
+
+// MatCreated returns the total amount of material created in the simulation
+// for the given sim id by the agents, of the nuclides and between the times
+// matching f.
+func MatCreated(db *sql.DB, simid []byte, f Filter) (m nuc.Material, err error) {
+	filt, fargs, err := f.SQL(Cols{Agent: "cre.AgentId", Proto: "ag.Prototype", Spec: "ag.Spec", Nuc: "cmp.NucId", Time: "res.TimeCreated"})
+	if err != nil {
+		return nil, err
 	}
 
 	sql := `SELECT cmp.NucId,SUM(cmp.MassFrac * res.Quantity) FROM (
 				Resources As res
 				INNER JOIN Compositions AS cmp ON res.QualId = cmp.QualId
 				INNER JOIN ResCreators AS cre ON res.ResourceId = cre.ResourceId
+				INNER JOIN Agents AS ag ON ag.AgentId = cre.AgentId
 			) WHERE (
-				cre.SimId = ? AND cre.SimId = res.SimId AND cre.SimId = cmp.SimId
-				AND res.TimeCreated >= ? AND res.TimeCreated < ?`
+				cre.SimId = ? AND cre.SimId = res.SimId AND cre.SimId = cmp.SimId AND cre.SimId = ag.SimId`
 	sql += filt
 	sql += `) GROUP BY cmp.NucId;`
-	return makeMaterial(db, sql, simid, t0, t1)
+	return makeMaterial(db, sql, append([]interface{}{simid}, fargs...)...)
 }
 
-// InvAt returns the material inventory of the listed agent ids for the
-// specified sim id at time t. Passing no agents defaults to all agents. Use
-// t=-1 to specify end-of-simulation.
-func InvAt(db *sql.DB, simid []byte, t int, agents ...int) (m nuc.Material, err error) {
+// InvAt returns the material inventory of the agents and nuclides matching
+// f for the specified sim id at time t. Use t=-1 to specify
+// end-of-simulation.
+func InvAt(db *sql.DB, simid []byte, t int, f Filter) (m nuc.Material, err error) {
 	if t == -1 {
 		si, err := SimStat(db, simid)
 		if err != nil {
@@ -214,30 +486,56 @@ func InvAt(db *sql.DB, simid []byte, t int, agents ...int) (m nuc.Material, err
 		}
 		t = si.Duration
 	}
-	filt := ""
-	if len(agents) > 0 {
-		filt += " AND inv.AgentId IN (" + strconv.Itoa(agents[0])
-		for _, a := range agents[1:] {
-			filt += "," + strconv.Itoa(a)
-		}
-		filt += ") "
+	filt, fargs, err := f.SQL(Cols{Agent: "inv.AgentId", Proto: "ag.Prototype", Spec: "ag.Spec", Nuc: "cmp.NucId"})
+	if err != nil {
+		return nil, err
 	}
 	sql := `SELECT cmp.NucId,SUM(cmp.MassFrac * inv.Quantity) FROM (
 				Inventories AS inv
 				INNER JOIN Compositions AS cmp ON inv.QualId = cmp.QualId
+				INNER JOIN Agents AS ag ON ag.AgentId = inv.AgentId
 			) WHERE (
-				inv.SimId = ? AND inv.SimId = cmp.SimId
+				inv.SimId = ? AND inv.SimId = cmp.SimId AND inv.SimId = ag.SimId
 				AND inv.StartTime <= ? AND inv.EndTime > ?`
 	sql += filt
 	sql += `) GROUP BY cmp.NucId;`
-	return makeMaterial(db, sql, simid, t, t)
+	return makeMaterial(db, sql, append([]interface{}{simid, t, t}, fargs...)...)
 }
 
-// InvMassAt returns the mass of material inventory of the listed agent ids
-// for the specified sim id at time t. Passing no agents defaults to all
-// agents. Use t=-1 to specify end-of-simulation.
-func InvMassAt(db *sql.DB, simid []byte, t int, agents ...int) (mass float64, err error) {
-	m, err := InvAt(db, simid, t, agents...)
+// ProductInvAt returns the Product resource inventory of the agents matching
+// f for the specified sim id at time t as quantities keyed by quality.  Use
+// t=-1 to specify end-of-simulation.
+func ProductInvAt(db *sql.DB, simid []byte, t int, f Filter) (m map[string]float64, err error) {
+	if t == -1 {
+		si, err := SimStat(db, simid)
+		if err != nil {
+			return nil, err
+		}
+		t = si.Duration
+	}
+	filt, fargs, err := f.SQL(Cols{Agent: "inv.AgentId", Proto: "ag.Prototype", Spec: "ag.Spec"})
+	if err != nil {
+		return nil, err
+	}
+	sql := `SELECT pd.Quality,SUM(inv.Quantity) FROM (
+				Inventories AS inv
+				INNER JOIN Resources AS res ON res.ResourceId = inv.ResourceId
+				INNER JOIN Products AS pd ON pd.QualId = inv.QualId
+				INNER JOIN Agents AS ag ON ag.AgentId = inv.AgentId
+			) WHERE (
+				inv.SimId = ? AND res.SimId = inv.SimId AND pd.SimId = inv.SimId AND ag.SimId = inv.SimId
+				AND res.Type = 'Product'
+				AND inv.StartTime <= ? AND inv.EndTime > ?`
+	sql += filt
+	sql += `) GROUP BY pd.Quality;`
+	return makeProducts(db, sql, append([]interface{}{simid, t, t}, fargs...)...)
+}
+
+// InvMassAt returns the mass of material inventory of the agents and
+// nuclides matching f for the specified sim id at time t. Use t=-1 to
+// specify end-of-simulation.
+func InvMassAt(db *sql.DB, simid []byte, t int, f Filter) (mass float64, err error) {
+	m, err := InvAt(db, simid, t, f)
 	if err != nil {
 		return 0, err
 	}
@@ -254,39 +552,30 @@ type FlowArc struct {
 	Quantity float64
 }
 
-func FlowGraph(db *sql.DB, simid []byte, t0, t1 int, groupByProto bool) (arcs []FlowArc, err error) {
-	if t1 == -1 {
-		si, err := SimStat(db, simid)
-		if err != nil {
-			return nil, err
-		}
-		t1 = si.Duration
+// FlowGraph returns the total quantity of resources transacted between each
+// pair of agents (or prototypes if groupByProto is true) for the
+// commodities and between the times matching f.
+func FlowGraph(db *sql.DB, simid []byte, f Filter, groupByProto bool) (arcs []FlowArc, err error) {
+	filt, fargs, err := f.SQL(Cols{Commod: "tr.Commodity", Time: "tr.Time"})
+	if err != nil {
+		return nil, err
 	}
 
-	var sql string
+	sql := `SELECT snd.AgentId,rcv.AgentId,snd.Prototype,rcv.Prototype,tr.Commodity,SUM(res.Quantity) FROM (
+				Resources AS res
+				INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId
+				INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId
+				INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId
+			) WHERE (
+				res.SimId = ? AND tr.SimId = res.SimId`
+	sql += filt
 	if !groupByProto {
-		sql = `SELECT snd.AgentId,rcv.AgentId,snd.Prototype,rcv.Prototype,tr.Commodity,SUM(res.Quantity) FROM (
-					Resources AS res
-					INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId
-					INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId
-					INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId
-				) WHERE (
-					res.SimId = ? AND tr.SimId = res.SimId
-					AND tr.Time >= ? AND tr.Time < ?
-				) GROUP BY tr.SenderId,tr.ReceiverId,tr.Commodity;`
+		sql += `) GROUP BY tr.SenderId,tr.ReceiverId,tr.Commodity;`
 	} else {
-		sql = `SELECT snd.AgentId,rcv.AgentId,snd.Prototype,rcv.Prototype,tr.Commodity,SUM(res.Quantity) FROM (
-					Resources AS res
-					INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId
-					INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId
-					INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId
-				) WHERE (
-					res.SimId = ? AND tr.SimId = res.SimId
-					AND tr.Time >= ? AND tr.Time < ?
-				) GROUP BY snd.Prototype,rcv.Prototype,tr.Commodity;`
+		sql += `) GROUP BY snd.Prototype,rcv.Prototype,tr.Commodity;`
 	}
 
-	rows, err := db.Query(sql, simid, t0, t1)
+	rows, err := db.Query(sql, append([]interface{}{simid}, fargs...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -303,62 +592,209 @@ func FlowGraph(db *sql.DB, simid []byte, t0, t1 int, groupByProto bool) (arcs []
 	return arcs, nil
 }
 
-func Flow(db *sql.DB, simid []byte, t0, t1 int, fromAgents, toAgents []int) (m nuc.Material, err error) {
-	if t1 == -1 {
-		si, err := SimStat(db, simid)
-		if err != nil {
-			return nil, err
-		}
-		t1 = si.Duration
-	}
-	filt := " AND tr.SenderId IN (" + strconv.Itoa(fromAgents[0])
-	for _, a := range fromAgents[1:] {
-		filt += "," + strconv.Itoa(a)
-	}
-	filt += ") "
-	filt += " AND tr.ReceiverId IN (" + strconv.Itoa(toAgents[0])
-	for _, a := range toAgents[1:] {
-		filt += "," + strconv.Itoa(a)
+// Flow returns the material transacted from agents matching from to agents
+// matching to for the commodities, nuclides and times matching f.  Only the
+// agent fields of from and to are used.
+func Flow(db *sql.DB, simid []byte, f, from, to Filter) (m nuc.Material, err error) {
+	filt, fargs, err := flowFilter(f, from, to, Cols{Commod: "tr.Commodity", Nuc: "cmp.NucId", Time: "tr.Time"})
+	if err != nil {
+		return nil, err
 	}
-	filt += ") "
 
 	sql := `SELECT cmp.NucId,SUM(cmp.MassFrac * res.Quantity) FROM (
 				Resources AS res
 				INNER JOIN Compositions AS cmp ON cmp.QualId = res.QualId
 				INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId
+				INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId
+				INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId
 			) WHERE (
 				res.SimId = ? AND cmp.SimId = res.SimId AND tr.SimId = res.SimId
-				AND tr.Time >= ? AND tr.Time < ?`
+				AND snd.SimId = res.SimId AND rcv.SimId = res.SimId`
 	sql += filt
 	sql += `) GROUP BY cmp.NucId;`
-	return makeMaterial(db, sql, simid, t0, t1)
+	return makeMaterial(db, sql, append([]interface{}{simid}, fargs...)...)
 }
 
-// EnergyProduced returns the total amount of energy produced between t0 and
-// t1 in Joules. Use t1=-1 to specify end-of-simulation.
-func EnergyProduced(db *sql.DB, simid []byte, t0, t1 int) (float64, error) {
-	t2 := t1 + 1
-	if t1 < 0 {
-		t2 = -1
+// ProductFlow returns the total quantity of Product resources transacted
+// from agents matching from to agents matching to for the commodities and
+// times matching f keyed by quality.  Only the agent fields of from and to
+// are used.
+func ProductFlow(db *sql.DB, simid []byte, f, from, to Filter) (m map[string]float64, err error) {
+	filt, fargs, err := flowFilter(f, from, to, Cols{Commod: "tr.Commodity", Time: "tr.Time"})
+	if err != nil {
+		return nil, err
+	}
+
+	sql := `SELECT pd.Quality,SUM(res.Quantity) FROM (
+				Resources AS res
+				INNER JOIN Products AS pd ON pd.QualId = res.QualId
+				INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId
+				INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId
+				INNER JOIN Agents AS rcv ON rcv.AgentId = tr.ReceiverId
+			) WHERE (
+				res.SimId = ? AND pd.SimId = res.SimId AND tr.SimId = res.SimId
+				AND snd.SimId = res.SimId AND rcv.SimId = res.SimId
+				AND res.Type = 'Product'`
+	sql += filt
+	sql += `) GROUP BY pd.Quality;`
+	return makeProducts(db, sql, append([]interface{}{simid}, fargs...)...)
+}
+
+// flowFilter renders f on the columns in c along with the agent fields of
+// from and to on the sender (snd) and receiver (rcv) agents of transactions
+// (tr).
+func flowFilter(f, from, to Filter, c Cols) (string, []interface{}, error) {
+	filt, args, err := f.SQL(c)
+	if err != nil {
+		return "", nil, err
+	}
+	sfilt, sargs, err := from.Agent().SQL(Cols{Agent: "tr.SenderId", Proto: "snd.Prototype", Spec: "snd.Spec"})
+	if err != nil {
+		return "", nil, err
+	}
+	rfilt, rargs, err := to.Agent().SQL(Cols{Agent: "tr.ReceiverId", Proto: "rcv.Prototype", Spec: "rcv.Spec"})
+	if err != nil {
+		return "", nil, err
+	}
+	args = append(append(args, sargs...), rargs...)
+	return filt + sfilt + rfilt, args, nil
+}
+
+// EnergyProduced returns the total amount of energy produced between f.T0
+// and f.T1 in Joules by the agents matching f. Use f.T1 <= 0 to specify
+// end-of-simulation.  Fission potential energy is computed with nuc.FPE.
+func EnergyProduced(db *sql.DB, simid []byte, f Filter) (float64, error) {
+	return EnergyProducedFPE(db, simid, f, nuc.FPE)
+}
+
+// EnergyProducedFPE is like EnergyProduced but computes the fission potential
+// energy of materials with fpe.  The energy produced is the fission potential
+// energy the agents lost - that of their initial inventory and the material
+// they created or received less that of their final inventory and the
+// material they sent away.
+func EnergyProducedFPE(db *sql.DB, simid []byte, f Filter, fpe func(nuc.Material) float64) (float64, error) {
+	t0, t1 := f.T0, f.T1
+	created := f.Agent()
+	created.T0 = t0 + 1
+	if t1 > 0 {
+		created.T1 = t1 + 1
+	} else {
+		t1 = -1
+	}
+	mcreated, err := MatCreated(db, simid, created)
+	if err != nil {
+		return 0, err
+	}
+	mat0, err := InvAt(db, simid, t0, f.Agent())
+	if err != nil {
+		return 0, err
 	}
-	created, err := MatCreated(db, simid, t0+1, t2)
+	mat1, err := InvAt(db, simid, t1, f.Agent())
 	if err != nil {
 		return 0, err
 	}
-	mat0, err := InvAt(db, simid, t0)
+
+	// transfers between matching agents show up in both flows and cancel
+	times := Filter{T0: created.T0, T1: created.T1}
+	in, err := Flow(db, simid, times, Filter{}, f)
 	if err != nil {
 		return 0, err
 	}
-	mat1, err := InvAt(db, simid, t1)
+	out, err := Flow(db, simid, times, f, Filter{})
 	if err != nil {
 		return 0, err
 	}
 
-	fpeCreated := nuc.FPE(created)
-	fpe0 := nuc.FPE(mat0)
-	fpe1 := nuc.FPE(mat1)
+	return fpe(mat0) + fpe(mcreated) + fpe(in) - fpe(mat1) - fpe(out), nil
+}
+
+// EnrichXY is the enrichment at time step X.
+type EnrichXY struct {
+	X int
+	nuc.Enrichment
+}
+
+// EnrichSeries returns a time series of the enrichment needed to produce
+// the uranium transacted away by the agents matching f for the commodities
+// and times matching f.  Each transaction is enriched separately from feed
+// with assay xf leaving tails with assay xt.  The series has an entry for
+// every time step of the simulation matching f.
+func EnrichSeries(db *sql.DB, simid []byte, f Filter, xf, xt float64) (xys []EnrichXY, err error) {
+	filt, fargs, err := f.SQL(Cols{Agent: "tr.SenderId", Proto: "snd.Prototype", Spec: "snd.Spec", Commod: "tr.Commodity", Time: "tr.Time"})
+	if err != nil {
+		return nil, err
+	}
+	times, err := timeSteps(db, simid, f)
+	if err != nil {
+		return nil, err
+	}
+	index := map[int]int{}
+	for i, t := range times {
+		index[t] = i
+		xys = append(xys, EnrichXY{X: t})
+	}
+
+	sql := `SELECT tr.Time,
+				TOTAL(CASE WHEN cmp.NucId = ? THEN cmp.MassFrac * res.Quantity ELSE 0 END),
+				TOTAL(cmp.MassFrac * res.Quantity)
+			FROM (
+				Resources AS res
+				INNER JOIN Compositions AS cmp ON cmp.QualId = res.QualId
+				INNER JOIN Transactions AS tr ON tr.ResourceId = res.ResourceId
+				INNER JOIN Agents AS snd ON snd.AgentId = tr.SenderId
+			) WHERE (
+				res.SimId = ? AND cmp.SimId = res.SimId AND tr.SimId = res.SimId AND snd.SimId = res.SimId
+				AND cmp.NucId >= 920000000 AND cmp.NucId < 930000000`
+	sql += filt
+	sql += `) GROUP BY tr.TransactionId;`
+	rows, err := db.Query(sql, append([]interface{}{int(nuc.U235), simid}, fargs...)...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var t int
+		var u235, u float64
+		if err := rows.Scan(&t, &u235, &u); err != nil {
+			return nil, err
+		}
+		i, ok := index[t]
+		if !ok || u <= 0 {
+			continue
+		}
+		e, err := nuc.Enrich(u, u235/u, xf, xt)
+		if err != nil {
+			return nil, err
+		}
+		xys[i].Enrichment = xys[i].Enrichment.Add(e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return xys, nil
+}
 
-	return fpe0 - (fpe1 - fpeCreated), nil
+// timeSteps returns the time steps of the simulation in the time interval of
+// f in order.
+func timeSteps(db *sql.DB, simid []byte, f Filter) (times []int, err error) {
+	filt, fargs, err := Filter{T0: f.T0, T1: f.T1}.SQL(Cols{Time: "Time"})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT Time FROM TimeList WHERE SimId = ?"+filt+" ORDER BY Time;", append([]interface{}{simid}, fargs...)...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var t int
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return times, nil
 }
 
 // Index builds an sql statement for creating a new index on the specified
@@ -398,3 +834,25 @@ func makeMaterial(db *sql.DB, sql string, args ...interface{}) (m nuc.Material,
 	}
 	return m, nil
 }
+
+func makeProducts(db *sql.DB, sql string, args ...interface{}) (m map[string]float64, err error) {
+	rows, err := db.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	m = map[string]float64{}
+	var quality string
+	var qty float64
+	for rows.Next() {
+		if err := rows.Scan(&quality, &qty); err != nil {
+			return nil, err
+		}
+		m[quality] = qty
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,202 @@
+package query
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// TimeUnit selects how times are expressed - either as raw time steps or
+// using the calendar columns of the post processed TimeList table.
+type TimeUnit string
+
+const (
+	// Step is the integer simulation time step.
+	Step TimeUnit = "step"
+	// Month is the number of months elapsed since the start of the
+	// simulation.
+	Month TimeUnit = "month"
+	// Year is the decimal calendar year (e.g. 2030.5).
+	Year TimeUnit = "year"
+	// Date is the calendar date (YYYY-MM-DD) a time step starts on.
+	Date TimeUnit = "date"
+)
+
+// TimeUnits lists all valid time units.
+var TimeUnits = []TimeUnit{Step, Month, Year, Date}
+
+// ParseTimeUnit returns the time unit named s.
+func ParseTimeUnit(s string) (TimeUnit, error) {
+	for _, u := range TimeUnits {
+		if string(u) == s {
+			return u, nil
+		}
+	}
+	return "", fmt.Errorf("invalid time unit '%v'", s)
+}
+
+// Col returns the column of the TimeList table (with the given alias) that
+// holds times in unit u.
+func (u TimeUnit) Col(alias string) string {
+	switch u {
+	case Month:
+		return alias + ".Months"
+	case Year:
+		return alias + ".DecYear"
+	case Date:
+		return alias + ".Date"
+	}
+	return alias + ".Time"
+}
+
+// Label returns a description of u suitable for plot axes.
+func (u TimeUnit) Label() string {
+	switch u {
+	case Month:
+		return "Time (Months)"
+	case Year:
+		return "Time (Years)"
+	case Date:
+		return "Date"
+	}
+	return "Time (Steps)"
+}
+
+// TimeStep returns the first time step of simid starting at or after the
+// time v given in unit u (e.g. "2030.5" for Year or "2030-07-01" for Date).
+// Times past the end of the simulation return one past its last time step.
+func TimeStep(db *sql.DB, simid []byte, u TimeUnit, v string) (int, error) {
+	var arg interface{} = v
+	switch u {
+	case Step:
+		t, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time step '%v'", v)
+		}
+		return t, nil
+	case Month, Year:
+		x, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %v '%v'", u, v)
+		}
+		arg = x
+	}
+
+	s := "SELECT COALESCE(MIN(Time), (SELECT MAX(Time)+1 FROM TimeList WHERE SimId = ?)) FROM TimeList WHERE SimId = ? AND " + u.Col("TimeList") + " >= ?;"
+	var t sql.NullInt64
+	if err := db.QueryRow(s, simid, simid, arg).Scan(&t); err != nil {
+		return 0, err
+	}
+	return int(t.Int64), nil
+}
+
+// YearlyBins aggregates a time series indexed by time step into calendar
+// years using the post processed TimeList table.  The returned series has a
+// calendar year as X for every year the simulation spans.  Values are summed
+// for quantities that accumulate per time step (e.g. flows) or averaged if
+// avg is true for quantities that are levels (e.g. inventories or power).
+func YearlyBins(db *sql.DB, simid []byte, xys []XY, avg bool) (bins []XY, err error) {
+	rows, err := db.Query("SELECT Time,Year FROM TimeList WHERE SimId = ? ORDER BY Time;", simid)
+	if err != nil {
+		return nil, err
+	}
+
+	years := map[int]int{}
+	index := map[int]int{}
+	var steps []int
+	for rows.Next() {
+		var t, y int
+		if err := rows.Scan(&t, &y); err != nil {
+			return nil, err
+		}
+		years[t] = y
+		if _, ok := index[y]; !ok {
+			index[y] = len(bins)
+			bins = append(bins, XY{X: y})
+			steps = append(steps, 0)
+		}
+		steps[index[y]]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, xy := range xys {
+		y, ok := years[xy.X]
+		if !ok {
+			return nil, fmt.Errorf("time step %v is not in the TimeList table", xy.X)
+		}
+		bins[index[y]].Y += xy.Y
+	}
+	if avg {
+		for i := range bins {
+			bins[i].Y /= float64(steps[i])
+		}
+	}
+	return bins, nil
+}
+
+// DefaultDt is the cyclus default time step duration in seconds - one
+// twelfth of an average Gregorian year.
+const DefaultDt = 2629846
+
+// Queryer is implemented by both *sql.DB and *sql.Tx.
+type Queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// InfoCols holds the positive integer columns of a simulation's row in the
+// Info table keyed by column name.  Columns that older cyclus databases lack
+// are missing.
+type InfoCols map[string]int64
+
+// InfoColumns reads the Info table row for simid.
+func InfoColumns(q Queryer, simid []byte) (InfoCols, error) {
+	rows, err := q.Query("SELECT * FROM Info WHERE SimId = ?;", simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	info := InfoCols{}
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	if rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, col := range cols {
+			if v, ok := vals[i].(int64); ok && v > 0 {
+				info[col] = v
+			}
+		}
+	}
+	return info, rows.Err()
+}
+
+// Dt returns the time step duration in seconds or DefaultDt if the Info
+// table has no DtTime column.
+func (ic InfoCols) Dt() int64 {
+	if dt, ok := ic["DtTime"]; ok {
+		return dt
+	}
+	return DefaultDt
+}
+
+// TimeStepDur returns the time step duration of simid in seconds.  Older
+// cyclus databases without a DtTime column in their Info table use
+// DefaultDt.
+func TimeStepDur(db *sql.DB, simid []byte) (float64, error) {
+	info, err := InfoColumns(db, simid)
+	if err != nil {
+		return 0, err
+	}
+	return float64(info.Dt()), nil
+}
@@ -0,0 +1,138 @@
+package query
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/rwcarlsen/go-sqlite3"
+)
+
+var timeSimid = []byte("simid-time")
+
+// timeDB returns a database with a TimeList table of 14 monthly time steps
+// starting in November 2030.
+func timeDB(t *testing.T) (db *sql.DB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "cyan-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	_, err = db.Exec("CREATE TABLE TimeList (SimId BLOB,Time INTEGER,Year INTEGER,Month INTEGER,Date TEXT,DecYear REAL,Months REAL);")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	for i := 0; i < 14; i++ {
+		y, m := 2030+(10+i)/12, (10+i)%12+1
+		date := fmt.Sprintf("%04d-%02d-01", y, m)
+		_, err := db.Exec("INSERT INTO TimeList VALUES (?,?,?,?,?,?,?);", timeSimid, i, y, m, date, float64(y)+float64(m-1)/12, float64(i))
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	return db, cleanup
+}
+
+func TestTimeStep(t *testing.T) {
+	db, cleanup := timeDB(t)
+	defer cleanup()
+
+	var tests = []struct {
+		Unit TimeUnit
+		V    string
+		Want int
+	}{
+		{Step, "5", 5},
+		{Month, "2", 2},
+		{Month, "2.5", 3},
+		{Year, "2031", 2},
+		{Year, "2031.5", 8},
+		{Date, "2031-07-01", 8},
+		{Date, "2031-07", 8},
+		{Date, "2000-01-01", 0},
+		{Date, "2040-01-01", 14},
+	}
+	for _, test := range tests {
+		got, err := TimeStep(db, timeSimid, test.Unit, test.V)
+		if err != nil {
+			t.Errorf("%v %v: %v", test.Unit, test.V, err)
+		} else if got != test.Want {
+			t.Errorf("%v %v: got time step %v, want %v", test.Unit, test.V, got, test.Want)
+		}
+	}
+
+	if _, err := TimeStep(db, timeSimid, Year, "soon"); err == nil {
+		t.Errorf("expected error for invalid year")
+	}
+}
+
+func TestYearlyBins(t *testing.T) {
+	db, cleanup := timeDB(t)
+	defer cleanup()
+
+	xys := []XY{{0, 1}, {1, 3}, {2, 12}, {13, 24}}
+	got, err := YearlyBins(db, timeSimid, xys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []XY{{2030, 4}, {2031, 36}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summed: got %v, want %v", got, want)
+	}
+
+	got, err = YearlyBins(db, timeSimid, xys, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []XY{{2030, 2}, {2031, 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("averaged: got %v, want %v", got, want)
+	}
+
+	if _, err := YearlyBins(db, timeSimid, []XY{{20, 1}}, false); err == nil {
+		t.Errorf("expected error for time step missing from TimeList")
+	}
+}
+
+func TestTimeStepDur(t *testing.T) {
+	db, cleanup := timeDB(t)
+	defer cleanup()
+
+	// no DtTime column
+	if _, err := db.Exec("CREATE TABLE Info (SimId BLOB,Duration INTEGER);"); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec("INSERT INTO Info VALUES (?,14);", timeSimid); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := TimeStepDur(db, timeSimid); err != nil {
+		t.Fatal(err)
+	} else if got != DefaultDt {
+		t.Errorf("without DtTime: got %v, want %v", got, DefaultDt)
+	}
+
+	if _, err := db.Exec("ALTER TABLE Info ADD COLUMN DtTime INTEGER;"); err != nil {
+		t.Fatal(err)
+	} else if _, err := db.Exec("UPDATE Info SET DtTime = 86400;"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := TimeStepDur(db, timeSimid); err != nil {
+		t.Fatal(err)
+	} else if got != 86400 {
+		t.Errorf("with DtTime: got %v, want 86400", got)
+	}
+}
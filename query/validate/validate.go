@@ -0,0 +1,415 @@
+// Package validate checks post processed cyclus databases for violations of
+// mass conservation and for broken references between tables.  Such
+// violations usually point to bugs in cyclus archetypes.
+package validate
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Names of the checks performed by Check.
+const (
+	// AgentMass verifies for every agent and time step that the change in
+	// the agent's material inventory equals the material it created plus
+	// the material it received minus the material it sent.
+	AgentMass = "agent-mass"
+	// ParentChild verifies that the quantities of resources consumed by a
+	// split, combine or transmute equal the quantities of the resources
+	// produced.
+	ParentChild = "parent-child"
+	// TransRefs verifies that every transaction references an existing
+	// sender, receiver and resource.
+	TransRefs = "trans-refs"
+)
+
+// AllChecks lists the names of every available check.
+var AllChecks = []string{AgentMass, ParentChild, TransRefs}
+
+// DefaultTol is the default tolerance for comparing quantities.
+const DefaultTol = 1e-6
+
+// Violation describes a single failed check.
+type Violation struct {
+	Check   string `json:"check"`
+	Time    int    `json:"time"`
+	AgentId int    `json:"agent_id,omitempty"`
+	// ResourceIds lists the resources involved in the violation.
+	ResourceIds []int `json:"resource_ids,omitempty"`
+	// TransactionId is set for transaction reference violations.
+	TransactionId int     `json:"transaction_id,omitempty"`
+	Expected      float64 `json:"expected"`
+	Actual        float64 `json:"actual"`
+	Message       string  `json:"message"`
+}
+
+// Report holds the results of checking a single simulation.
+type Report struct {
+	SimId      string      `json:"simid"`
+	Tol        float64     `json:"tolerance"`
+	Checks     []string    `json:"checks"`
+	Violations []Violation `json:"violations"`
+}
+
+// OK returns true if no violations were found.
+func (r *Report) OK() bool { return len(r.Violations) == 0 }
+
+// Check runs the named checks (all of them if none are given) against the
+// simulation simid in db and returns a report of all violations found
+// ordered by time.  db must already be post processed.  Quantities a and b
+// are considered equal if |a-b| <= tol*max(1,|a|,|b|).
+func Check(db *sql.DB, simid []byte, tol float64, checks ...string) (*Report, error) {
+	if len(checks) == 0 {
+		checks = AllChecks
+	}
+	r := &Report{SimId: fmt.Sprintf("%x", simid), Tol: tol, Checks: checks, Violations: []Violation{}}
+
+	for _, name := range checks {
+		var vs []Violation
+		var err error
+		switch name {
+		case AgentMass:
+			vs, err = CheckAgentMass(db, simid, tol)
+		case ParentChild:
+			vs, err = CheckParentChild(db, simid, tol)
+		case TransRefs:
+			vs, err = CheckTransRefs(db, simid)
+		default:
+			return nil, fmt.Errorf("unknown check %q", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Violations = append(r.Violations, vs...)
+	}
+
+	sort.SliceStable(r.Violations, func(i, j int) bool {
+		return r.Violations[i].Time < r.Violations[j].Time
+	})
+	return r, nil
+}
+
+// agentTime identifies a single agent at a single time step.
+type agentTime struct {
+	Agent, Time int
+}
+
+// balance accumulates the terms of an agent's mass balance for one time step.
+type balance struct {
+	Delta, Created, Received, Sent float64
+	Resources                      map[int]bool
+}
+
+func (b *balance) add(resid int) {
+	if b.Resources == nil {
+		b.Resources = map[int]bool{}
+	}
+	b.Resources[resid] = true
+}
+
+const (
+	invEventsSql = `SELECT inv.AgentId,inv.ResourceId,inv.StartTime,inv.EndTime,inv.Quantity
+		FROM Inventories AS inv
+		INNER JOIN Resources AS res ON res.ResourceId = inv.ResourceId AND res.SimId = inv.SimId
+		WHERE inv.SimId = ? AND res.Type = 'Material';`
+	createdSql = `SELECT cre.AgentId,res.ResourceId,res.TimeCreated,res.Quantity
+		FROM ResCreators AS cre
+		INNER JOIN Resources AS res ON res.ResourceId = cre.ResourceId AND res.SimId = cre.SimId
+		WHERE cre.SimId = ? AND res.Type = 'Material';`
+	transferredSql = `SELECT tr.SenderId,tr.ReceiverId,res.ResourceId,tr.Time,res.Quantity
+		FROM Transactions AS tr
+		INNER JOIN Resources AS res ON res.ResourceId = tr.ResourceId AND res.SimId = tr.SimId
+		WHERE tr.SimId = ? AND res.Type = 'Material';`
+)
+
+// CheckAgentMass verifies for every agent and time step that the change in
+// the agent's material inventory equals the material it created plus the
+// material it received minus the material it sent.
+func CheckAgentMass(db *sql.DB, simid []byte, tol float64) ([]Violation, error) {
+	bals := map[agentTime]*balance{}
+	get := func(agent, t int) *balance {
+		k := agentTime{agent, t}
+		b, ok := bals[k]
+		if !ok {
+			b = &balance{}
+			bals[k] = b
+		}
+		return b
+	}
+
+	rows, err := db.Query(invEventsSql, simid)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var agent, resid, start, end int
+		var qty float64
+		if err := rows.Scan(&agent, &resid, &start, &end, &qty); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		b := get(agent, start)
+		b.Delta += qty
+		b.add(resid)
+		if end != math.MaxInt32 {
+			b = get(agent, end)
+			b.Delta -= qty
+			b.add(resid)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(createdSql, simid)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var agent, resid, t int
+		var qty float64
+		if err := rows.Scan(&agent, &resid, &t, &qty); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		b := get(agent, t)
+		b.Created += qty
+		b.add(resid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(transferredSql, simid)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var sender, receiver, resid, t int
+		var qty float64
+		if err := rows.Scan(&sender, &receiver, &resid, &t, &qty); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if sender == receiver {
+			continue
+		}
+		b := get(sender, t)
+		b.Sent += qty
+		b.add(resid)
+		b = get(receiver, t)
+		b.Received += qty
+		b.add(resid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var vs []Violation
+	for k, b := range bals {
+		want := b.Created + b.Received - b.Sent
+		if equal(want, b.Delta, tol) {
+			continue
+		}
+		vs = append(vs, Violation{
+			Check:       AgentMass,
+			Time:        k.Time,
+			AgentId:     k.Agent,
+			ResourceIds: sortedIds(b.Resources),
+			Expected:    want,
+			Actual:      b.Delta,
+			Message: fmt.Sprintf("agent %v inventory changed by %g but created %g + received %g - sent %g = %g",
+				k.Agent, b.Delta, b.Created, b.Received, b.Sent, want),
+		})
+	}
+	sort.Slice(vs, func(i, j int) bool {
+		if vs[i].Time != vs[j].Time {
+			return vs[i].Time < vs[j].Time
+		}
+		return vs[i].AgentId < vs[j].AgentId
+	})
+	return vs, nil
+}
+
+type resource struct {
+	Id, Time int
+	Type     string
+	Qty      float64
+	P1, P2   int
+}
+
+// CheckParentChild verifies that the quantities of resources consumed by a
+// split, combine or transmute equal the quantities of the resources
+// produced.  Resources connected through shared parents or children form a
+// single event that is checked as a whole.
+func CheckParentChild(db *sql.DB, simid []byte, tol float64) ([]Violation, error) {
+	rows, err := db.Query("SELECT ResourceId,TimeCreated,Type,Quantity,Parent1,Parent2 FROM Resources WHERE SimId = ?;", simid)
+	if err != nil {
+		return nil, err
+	}
+	var res []resource
+	index := map[int]int{}
+	for rows.Next() {
+		var r resource
+		if err := rows.Scan(&r.Id, &r.Time, &r.Type, &r.Qty, &r.P1, &r.P2); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[r.Id] = len(res)
+		res = append(res, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// union-find over parent roles (index i) and child roles (index n+i) so
+	// a resource produced by one event and consumed by another keeps the
+	// events separate
+	n := len(res)
+	set := make([]int, 2*n)
+	for i := range set {
+		set[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		for set[i] != i {
+			set[i] = set[set[i]]
+			i = set[i]
+		}
+		return i
+	}
+	isKid := make([]bool, n)
+	isParent := make([]bool, n)
+	for i, r := range res {
+		for _, p := range []int{r.P1, r.P2} {
+			j, ok := index[p]
+			if p == 0 || !ok || (p == r.P2 && r.P1 == r.P2) {
+				continue
+			}
+			isKid[i], isParent[j] = true, true
+			set[find(j)] = find(n + i)
+		}
+	}
+
+	type event struct {
+		Parents, Kids []int
+		In, Out       float64
+		Time          int
+	}
+	events := map[int]*event{}
+	var order []int
+	for i, r := range res {
+		if isParent[i] {
+			k := find(i)
+			e, ok := events[k]
+			if !ok {
+				e = &event{}
+				events[k] = e
+				order = append(order, k)
+			}
+			e.Parents = append(e.Parents, r.Id)
+			e.In += r.Qty
+		}
+		if isKid[i] {
+			k := find(n + i)
+			e, ok := events[k]
+			if !ok {
+				e = &event{}
+				events[k] = e
+				order = append(order, k)
+			}
+			e.Kids = append(e.Kids, r.Id)
+			e.Out += r.Qty
+			if r.Time > e.Time {
+				e.Time = r.Time
+			}
+		}
+	}
+
+	var vs []Violation
+	for _, k := range order {
+		e := events[k]
+		if equal(e.In, e.Out, tol) {
+			continue
+		}
+		kind := "transmute"
+		if len(e.Parents) > 1 {
+			kind = "combine"
+		} else if len(e.Kids) > 1 {
+			kind = "split"
+		}
+		ids := append(append([]int{}, e.Parents...), e.Kids...)
+		vs = append(vs, Violation{
+			Check:       ParentChild,
+			Time:        e.Time,
+			ResourceIds: ids,
+			Expected:    e.In,
+			Actual:      e.Out,
+			Message: fmt.Sprintf("%v of resources %v into %v: parents total %g but children total %g",
+				kind, e.Parents, e.Kids, e.In, e.Out),
+		})
+	}
+	return vs, nil
+}
+
+// CheckTransRefs verifies that every transaction references an existing
+// sender, receiver and resource.
+func CheckTransRefs(db *sql.DB, simid []byte) ([]Violation, error) {
+	sql := `SELECT tr.TransactionId,tr.Time,tr.SenderId,tr.ReceiverId,tr.ResourceId,
+				snd.AgentId IS NULL,rcv.AgentId IS NULL,res.ResourceId IS NULL
+			FROM Transactions AS tr
+			LEFT JOIN AgentEntry AS snd ON snd.AgentId = tr.SenderId AND snd.SimId = tr.SimId
+			LEFT JOIN AgentEntry AS rcv ON rcv.AgentId = tr.ReceiverId AND rcv.SimId = tr.SimId
+			LEFT JOIN Resources AS res ON res.ResourceId = tr.ResourceId AND res.SimId = tr.SimId
+			WHERE tr.SimId = ? AND (snd.AgentId IS NULL OR rcv.AgentId IS NULL OR res.ResourceId IS NULL)
+			ORDER BY tr.Time,tr.TransactionId;`
+	rows, err := db.Query(sql, simid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vs []Violation
+	for rows.Next() {
+		var id, t, sender, receiver, resid int
+		var noSender, noReceiver, noRes bool
+		if err := rows.Scan(&id, &t, &sender, &receiver, &resid, &noSender, &noReceiver, &noRes); err != nil {
+			return nil, err
+		}
+		add := func(msg string, agent int) {
+			vs = append(vs, Violation{
+				Check:         TransRefs,
+				Time:          t,
+				AgentId:       agent,
+				ResourceIds:   []int{resid},
+				TransactionId: id,
+				Message:       fmt.Sprintf("transaction %v %v", id, msg),
+			})
+		}
+		if noSender {
+			add(fmt.Sprintf("references nonexistent sender agent %v", sender), sender)
+		}
+		if noReceiver {
+			add(fmt.Sprintf("references nonexistent receiver agent %v", receiver), receiver)
+		}
+		if noRes {
+			add(fmt.Sprintf("references nonexistent resource %v", resid), 0)
+		}
+	}
+	return vs, rows.Err()
+}
+
+func equal(a, b, tol float64) bool {
+	return math.Abs(a-b) <= tol*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+func sortedIds(ids map[int]bool) []int {
+	s := make([]int, 0, len(ids))
+	for id := range ids {
+		s = append(s, id)
+	}
+	sort.Ints(s)
+	return s
+}
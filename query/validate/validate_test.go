@@ -0,0 +1,162 @@
+package validate
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/post"
+	_ "github.com/rwcarlsen/go-sqlite3"
+)
+
+var schema = []string{
+	"CREATE TABLE Info (SimId BLOB,Duration INTEGER);",
+	"CREATE TABLE AgentEntry (SimId BLOB,AgentId INTEGER,Kind TEXT,Spec TEXT,Prototype TEXT,ParentId INTEGER,Lifetime INTEGER,EnterTime INTEGER);",
+	"CREATE TABLE Resources (SimId BLOB,ResourceId INTEGER,ObjId INTEGER,Type TEXT,TimeCreated INTEGER,Quantity REAL,Units TEXT,QualId INTEGER,Parent1 INTEGER,Parent2 INTEGER);",
+	"CREATE TABLE ResCreators (SimId BLOB,ResourceId INTEGER,AgentId INTEGER);",
+	"CREATE TABLE Transactions (SimId BLOB,TransactionId INTEGER,SenderId INTEGER,ReceiverId INTEGER,ResourceId INTEGER,Commodity TEXT,Time INTEGER);",
+}
+
+var simid = []byte("validate-simid")
+
+// res is Resources table content: id, time, quantity, parent1, parent2.
+type res [5]float64
+
+// trans is Transactions table content: id, sender, receiver, resource, time.
+type trans [5]int
+
+// A mine (agent 1) creates ore that is sent to a mill (agent 2) which splits
+// it into product and tailings and sends the product to a store (agent 3)
+// where it is combined with more product.
+var (
+	goodRes = []res{
+		{1, 0, 100, 0, 0},
+		{2, 2, 30, 1, 0},
+		{3, 2, 70, 1, 0},
+		{4, 3, 5, 0, 0},
+		{5, 4, 35, 2, 4},
+	}
+	goodCreators = [][2]int{{1, 1}, {4, 3}}
+	goodTrans    = []trans{{1, 1, 2, 1, 1}, {2, 2, 3, 2, 3}}
+)
+
+func TestCheck(t *testing.T) {
+	var tests = []struct {
+		Descrip  string
+		Res      []res
+		Trans    []trans
+		Checks   map[string]int
+		Resource int
+	}{
+		{"conserved", goodRes, goodTrans, map[string]int{}, 0},
+		{
+			"split loses mass",
+			[]res{{1, 0, 100, 0, 0}, {2, 2, 30, 1, 0}, {3, 2, 60, 1, 0}, {4, 3, 5, 0, 0}, {5, 4, 35, 2, 4}},
+			goodTrans,
+			map[string]int{AgentMass: 1, ParentChild: 1},
+			3,
+		},
+		{
+			"combine gains mass",
+			[]res{{1, 0, 100, 0, 0}, {2, 2, 30, 1, 0}, {3, 2, 70, 1, 0}, {4, 3, 5, 0, 0}, {5, 4, 40, 2, 4}},
+			goodTrans,
+			map[string]int{AgentMass: 1, ParentChild: 1},
+			5,
+		},
+		{
+			"transaction to missing agent",
+			goodRes,
+			[]trans{{1, 1, 2, 1, 1}, {2, 2, 9, 2, 3}},
+			// the product sent to the missing agent is later combined with
+			// the store's product, moving mass between them without a
+			// transaction
+			map[string]int{TransRefs: 1, AgentMass: 2},
+			2,
+		},
+		{
+			"transaction of missing resource",
+			goodRes,
+			[]trans{{1, 1, 2, 1, 1}, {2, 2, 3, 2, 3}, {3, 3, 1, 42, 4}},
+			map[string]int{TransRefs: 1},
+			42,
+		},
+	}
+
+	for _, test := range tests {
+		db, cleanup := build(t, test.Res, test.Trans)
+		r, err := Check(db, simid, DefaultTol)
+		cleanup()
+		if err != nil {
+			t.Fatalf("[%v] %v", test.Descrip, err)
+		}
+
+		got := map[string]int{}
+		found := test.Resource == 0
+		for _, v := range r.Violations {
+			got[v.Check]++
+			for _, id := range v.ResourceIds {
+				found = found || id == test.Resource
+			}
+		}
+		for _, name := range AllChecks {
+			if got[name] != test.Checks[name] {
+				t.Errorf("[%v] got %v %v violations, want %v: %+v", test.Descrip, got[name], name, test.Checks[name], r.Violations)
+			}
+		}
+		if !found {
+			t.Errorf("[%v] no violation references resource %v", test.Descrip, test.Resource)
+		}
+	}
+}
+
+func build(t *testing.T, rs []res, ts []trans) (db *sql.DB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "cyan-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+	db, err = sql.Open("sqlite3", filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	stmts := append([]string{}, schema...)
+	args := [][]interface{}{nil, nil, nil, nil, nil}
+	stmts = append(stmts, "INSERT INTO Info VALUES (?,10);")
+	args = append(args, []interface{}{simid})
+	for id := 1; id <= 3; id++ {
+		stmts = append(stmts, "INSERT INTO AgentEntry VALUES (?,?,'Facility',':agents:Sink','proto',-1,-1,0);")
+		args = append(args, []interface{}{simid, id})
+	}
+	for _, r := range rs {
+		stmts = append(stmts, "INSERT INTO Resources VALUES (?,?,?,'Material',?,?,'kg',1,?,?);")
+		args = append(args, []interface{}{simid, int(r[0]), int(r[0]), int(r[1]), r[2], int(r[3]), int(r[4])})
+	}
+	for _, c := range goodCreators {
+		stmts = append(stmts, "INSERT INTO ResCreators VALUES (?,?,?);")
+		args = append(args, []interface{}{simid, c[0], c[1]})
+	}
+	for _, tr := range ts {
+		stmts = append(stmts, "INSERT INTO Transactions VALUES (?,?,?,?,?,'commod',?);")
+		args = append(args, []interface{}{simid, tr[0], tr[1], tr[2], tr[3], tr[4]})
+	}
+	for i, s := range stmts {
+		if _, err := db.Exec(s, args[i]...); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := post.Process(context.Background(), db); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return db, cleanup
+}
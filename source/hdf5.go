@@ -0,0 +1,420 @@
+//go:build hdf5
+// +build hdf5
+
+package source
+
+/*
+#cgo LDFLAGS: -lhdf5
+#include <stdlib.h>
+#include <hdf5.h>
+
+enum { CY_INT, CY_UINT, CY_FLOAT, CY_STR, CY_VLSTR, CY_DIGEST, CY_BLOB };
+
+// The size of the SHA1 digests (5 unsigned ints) cyclus stores in tables in
+// place of variable length strings and blobs.
+enum { CY_DIGEST_SIZE = 20 };
+
+static void cy_quiet(void) { H5Eset_auto2(H5E_DEFAULT, NULL, NULL); }
+
+static hid_t cy_open(const char* path) { return H5Fopen(path, H5F_ACC_RDONLY, H5P_DEFAULT); }
+
+static hsize_t cy_nlinks(hid_t f) {
+	H5G_info_t info;
+	if (H5Gget_info(f, &info) < 0) {
+		return 0;
+	}
+	return info.nlinks;
+}
+
+static ssize_t cy_link_name(hid_t f, hsize_t i, char* buf, size_t size) {
+	return H5Lget_name_by_idx(f, ".", H5_INDEX_NAME, H5_ITER_INC, i, buf, size, H5P_DEFAULT);
+}
+
+static hid_t cy_open_dataset(hid_t f, const char* name) { return H5Dopen2(f, name, H5P_DEFAULT); }
+
+// cy_mem_type returns the native memory type for a dataset of compound
+// (table) type or a negative value for any other dataset.
+static hid_t cy_mem_type(hid_t dset) {
+	hid_t ft = H5Dget_type(dset);
+	if (ft < 0) {
+		return ft;
+	} else if (H5Tget_class(ft) != H5T_COMPOUND) {
+		H5Tclose(ft);
+		return -1;
+	}
+	hid_t mt = H5Tget_native_type(ft, H5T_DIR_ASCEND);
+	H5Tclose(ft);
+	return mt;
+}
+
+// cy_native_type returns the native memory type for any dataset.
+static hid_t cy_native_type(hid_t dset) {
+	hid_t ft = H5Dget_type(dset);
+	if (ft < 0) {
+		return ft;
+	}
+	hid_t mt = H5Tget_native_type(ft, H5T_DIR_ASCEND);
+	H5Tclose(ft);
+	return mt;
+}
+
+static hid_t cy_vlstr_type(void) {
+	hid_t t = H5Tcopy(H5T_C_S1);
+	H5Tset_size(t, H5T_VARIABLE);
+	return t;
+}
+
+static hssize_t cy_nrows(hid_t dset) {
+	hid_t sp = H5Dget_space(dset);
+	hssize_t n = H5Sget_simple_extent_npoints(sp);
+	H5Sclose(sp);
+	return n;
+}
+
+static int cy_member(hid_t t, unsigned i, char** name, size_t* offset, size_t* size) {
+	*name = H5Tget_member_name(t, i);
+	*offset = H5Tget_member_offset(t, i);
+	hid_t mt = H5Tget_member_type(t, i);
+	*size = H5Tget_size(mt);
+	int kind = CY_BLOB;
+	switch (H5Tget_class(mt)) {
+	case H5T_INTEGER:
+		kind = H5Tget_sign(mt) == H5T_SGN_NONE ? CY_UINT : CY_INT;
+		break;
+	case H5T_FLOAT:
+		kind = CY_FLOAT;
+		break;
+	case H5T_STRING:
+		kind = H5Tis_variable_str(mt) > 0 ? CY_VLSTR : CY_STR;
+		break;
+	case H5T_ARRAY:
+		if (*size == CY_DIGEST_SIZE) {
+			kind = CY_DIGEST;
+		}
+		break;
+	default:
+		break;
+	}
+	H5Tclose(mt);
+	return kind;
+}
+
+static herr_t cy_read(hid_t dset, hid_t mt, hsize_t start, hsize_t count, void* buf) {
+	hid_t fsp = H5Dget_space(dset);
+	hid_t msp = H5Screate_simple(1, &count, NULL);
+	herr_t err = H5Sselect_hyperslab(fsp, H5S_SELECT_SET, &start, NULL, &count, NULL);
+	if (err >= 0) {
+		err = H5Dread(dset, mt, msp, fsp, H5P_DEFAULT, buf);
+	}
+	H5Sclose(msp);
+	H5Sclose(fsp);
+	return err;
+}
+
+static void cy_reclaim(hid_t mt, hsize_t count, void* buf) {
+	hid_t msp = H5Screate_simple(1, &count, NULL);
+	H5Dvlen_reclaim(mt, msp, H5P_DEFAULT, buf);
+	H5Sclose(msp);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// The number of rows read from an HDF5 dataset at a time.
+const chunkRows = 50000
+
+// digest is the SHA1 digest cyclus stores in a table in place of a variable
+// length string or blob.
+type digest [C.CY_DIGEST_SIZE]byte
+
+// vlValues holds the variable length strings and blobs of a cyclus HDF5
+// database keyed by their digests.  Cyclus keeps them out of the tables in
+// pairs of datasets (e.g. StringKeys and StringVals) where the value at each
+// index of the Vals dataset has the digest at the same index of the Keys
+// dataset.
+type vlValues struct {
+	Strings map[digest]string
+	Blobs   map[digest]string
+}
+
+// column is a single member of the compound type of a cyclus HDF5 table.
+type column struct {
+	Name   string
+	Kind   C.int
+	Offset int
+	Size   int
+}
+
+func (c column) sqlType() string {
+	switch {
+	case c.Name == "SimId" || c.Kind == C.CY_BLOB:
+		return "BLOB"
+	case c.Kind == C.CY_INT || c.Kind == C.CY_UINT:
+		return "INTEGER"
+	case c.Kind == C.CY_FLOAT:
+		return "REAL"
+	}
+	return "TEXT"
+}
+
+// value decodes the column from a single row of native table data.  Cyclus
+// stores uuids as fixed length strings that may contain NUL bytes, so SimId
+// columns are kept as raw bytes.  Digests are replaced by the string or blob
+// they refer to in vl.  Members of any other type (e.g. digests of variable
+// length vectors and maps) are kept as raw bytes.
+func (c column) value(row []byte, vl *vlValues) interface{} {
+	p := unsafe.Pointer(&row[c.Offset])
+	switch c.Kind {
+	case C.CY_INT:
+		switch c.Size {
+		case 1:
+			return int64(*(*int8)(p))
+		case 2:
+			return int64(*(*int16)(p))
+		case 4:
+			return int64(*(*int32)(p))
+		case 8:
+			return *(*int64)(p)
+		}
+	case C.CY_UINT:
+		switch c.Size {
+		case 1:
+			return int64(*(*uint8)(p))
+		case 2:
+			return int64(*(*uint16)(p))
+		case 4:
+			return int64(*(*uint32)(p))
+		case 8:
+			return int64(*(*uint64)(p))
+		}
+	case C.CY_FLOAT:
+		switch c.Size {
+		case 4:
+			return float64(*(*float32)(p))
+		case 8:
+			return *(*float64)(p)
+		}
+	case C.CY_VLSTR:
+		s := *(**C.char)(p)
+		if s == nil {
+			return nil
+		}
+		return C.GoString(s)
+	case C.CY_DIGEST:
+		var d digest
+		copy(d[:], row[c.Offset:c.Offset+c.Size])
+		if s, ok := vl.Strings[d]; ok {
+			return s
+		} else if b, ok := vl.Blobs[d]; ok {
+			return []byte(b)
+		}
+	case C.CY_STR:
+		b := row[c.Offset : c.Offset+c.Size]
+		if c.Name != "SimId" {
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				b = b[:i]
+			}
+			return string(b)
+		}
+	}
+	return append([]byte{}, row[c.Offset:c.Offset+c.Size]...)
+}
+
+func convertHDF5(h5path string, db *sql.DB) error {
+	for _, s := range []string{"PRAGMA synchronous = OFF;", "PRAGMA journal_mode = OFF;"} {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+
+	C.cy_quiet()
+	cpath := C.CString(h5path)
+	defer C.free(unsafe.Pointer(cpath))
+	f := C.cy_open(cpath)
+	if f < 0 {
+		return fmt.Errorf("could not open HDF5 file %v", h5path)
+	}
+	defer C.H5Fclose(f)
+
+	strs, err := readVL(f, "String")
+	if err != nil {
+		return fmt.Errorf("reading variable length strings: %v", err)
+	}
+	blobs, err := readVL(f, "Blob")
+	if err != nil {
+		return fmt.Errorf("reading blobs: %v", err)
+	}
+	vl := &vlValues{Strings: strs, Blobs: blobs}
+
+	for i := C.hsize_t(0); i < C.cy_nlinks(f); i++ {
+		n := C.cy_link_name(f, i, nil, 0)
+		if n < 0 {
+			return fmt.Errorf("could not read dataset names from %v", h5path)
+		}
+		buf := (*C.char)(C.malloc(C.size_t(n + 1)))
+		C.cy_link_name(f, i, buf, C.size_t(n+1))
+		name := C.GoString(buf)
+		C.free(unsafe.Pointer(buf))
+
+		if !convertTable(name) {
+			continue
+		} else if err := convertDataset(f, name, db, vl); err != nil {
+			return fmt.Errorf("converting table %v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// readVL reads the values cyclus stores for the variable length type name in
+// the datasets name+"Keys" and name+"Vals".  Databases without them (e.g.
+// because no value of the type was ever recorded) give an empty map.
+func readVL(f C.hid_t, name string) (map[digest]string, error) {
+	m := map[digest]string{}
+
+	ckeys := C.CString(name + "Keys")
+	defer C.free(unsafe.Pointer(ckeys))
+	keys := C.cy_open_dataset(f, ckeys)
+	if keys < 0 {
+		return m, nil
+	}
+	defer C.H5Dclose(keys)
+	cvals := C.CString(name + "Vals")
+	defer C.free(unsafe.Pointer(cvals))
+	vals := C.cy_open_dataset(f, cvals)
+	if vals < 0 {
+		return m, nil
+	}
+	defer C.H5Dclose(vals)
+
+	kt := C.cy_native_type(keys)
+	if kt < 0 {
+		return nil, fmt.Errorf("could not read %vKeys type", name)
+	}
+	defer C.H5Tclose(kt)
+	if size := int(C.H5Tget_size(kt)); size != len(digest{}) {
+		return nil, fmt.Errorf("%vKeys has %v byte keys, want %v", name, size, len(digest{}))
+	}
+	vt := C.cy_vlstr_type()
+	defer C.H5Tclose(vt)
+
+	n := int(C.cy_nrows(keys))
+	if nv := int(C.cy_nrows(vals)); nv < n {
+		n = nv
+	}
+	if n <= 0 {
+		return m, nil
+	}
+
+	kbuf := C.malloc(C.size_t(n * len(digest{})))
+	defer C.free(kbuf)
+	if C.cy_read(keys, kt, 0, C.hsize_t(n), kbuf) < 0 {
+		return nil, fmt.Errorf("could not read %vKeys", name)
+	}
+	vbuf := C.malloc(C.size_t(n) * C.size_t(unsafe.Sizeof((*C.char)(nil))))
+	defer C.free(vbuf)
+	if C.cy_read(vals, vt, 0, C.hsize_t(n), vbuf) < 0 {
+		return nil, fmt.Errorf("could not read %vVals", name)
+	}
+	defer C.cy_reclaim(vt, C.hsize_t(n), vbuf)
+
+	nk := n * len(digest{})
+	kdata := (*[1 << 40]byte)(kbuf)[:nk:nk]
+	vdata := (*[1 << 30]*C.char)(vbuf)[:n:n]
+	for i, v := range vdata {
+		var d digest
+		copy(d[:], kdata[i*len(d):])
+		if v != nil {
+			m[d] = C.GoString(v)
+		}
+	}
+	return m, nil
+}
+
+func convertDataset(f C.hid_t, name string, db *sql.DB, vl *vlValues) error {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	dset := C.cy_open_dataset(f, cname)
+	if dset < 0 {
+		// not a dataset
+		return nil
+	}
+	defer C.H5Dclose(dset)
+	mt := C.cy_mem_type(dset)
+	if mt < 0 {
+		// not a table
+		return nil
+	}
+	defer C.H5Tclose(mt)
+
+	rowsize := int(C.H5Tget_size(mt))
+	cols := make([]column, int(C.H5Tget_nmembers(mt)))
+	hasvl := false
+	for i := range cols {
+		var cn *C.char
+		var off, size C.size_t
+		kind := C.cy_member(mt, C.unsigned(i), &cn, &off, &size)
+		cols[i] = column{Name: C.GoString(cn), Kind: kind, Offset: int(off), Size: int(size)}
+		C.H5free_memory(unsafe.Pointer(cn))
+		hasvl = hasvl || kind == C.CY_VLSTR
+	}
+
+	defs := make([]string, len(cols))
+	marks := make([]string, len(cols))
+	for i, c := range cols {
+		defs[i] = `"` + c.Name + `" ` + c.sqlType()
+		marks[i] = "?"
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`CREATE TABLE "` + name + `" (` + strings.Join(defs, ",") + `);`); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO "` + name + `" VALUES (` + strings.Join(marks, ",") + `);`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	nrows := int(C.cy_nrows(dset))
+	buf := C.malloc(C.size_t(rowsize * chunkRows))
+	defer C.free(buf)
+	vals := make([]interface{}, len(cols))
+	for start := 0; start < nrows; start += chunkRows {
+		count := chunkRows
+		if nrows-start < count {
+			count = nrows - start
+		}
+		if C.cy_read(dset, mt, C.hsize_t(start), C.hsize_t(count), buf) < 0 {
+			return fmt.Errorf("could not read rows %v-%v", start, start+count)
+		}
+
+		n := rowsize * count
+		data := (*[1 << 40]byte)(buf)[:n:n]
+		for r := 0; r < count; r++ {
+			row := data[r*rowsize : (r+1)*rowsize]
+			for j, c := range cols {
+				vals[j] = c.value(row, vl)
+			}
+			if _, err := stmt.Exec(vals...); err != nil {
+				if hasvl {
+					C.cy_reclaim(mt, C.hsize_t(count), buf)
+				}
+				return err
+			}
+		}
+		if hasvl {
+			C.cy_reclaim(mt, C.hsize_t(count), buf)
+		}
+	}
+	return tx.Commit()
+}
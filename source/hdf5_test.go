@@ -0,0 +1,77 @@
+//go:build hdf5
+// +build hdf5
+
+package source_test
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rwcarlsen/cyan/post"
+	"github.com/rwcarlsen/cyan/query"
+	"github.com/rwcarlsen/cyan/source"
+	_ "github.com/rwcarlsen/go-sqlite3"
+)
+
+// fixture is a database written by cyclus's HDF5 backend.
+var fixture = filepath.Join("testdata", "cyclus.h5")
+
+func TestConvert_Cyclus(t *testing.T) {
+	if _, err := os.Stat(fixture); err != nil {
+		t.Skipf("no cyclus HDF5 fixture: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "cyan-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbpath := filepath.Join(dir, "cyclus.sqlite")
+	if err := source.Convert(fixture, dbpath); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", dbpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	simids, err := post.Process(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(simids) == 0 {
+		t.Fatal("no simulations post processed")
+	}
+
+	for _, simid := range simids {
+		ags, err := query.AllAgents(db, simid, query.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		} else if len(ags) == 0 {
+			t.Errorf("simulation %x has no agents", simid)
+		}
+		for _, ag := range ags {
+			switch ag.Kind {
+			case "Region", "Inst", "Facility":
+			default:
+				t.Errorf("agent %v has kind %q, want a cyclus agent kind", ag.Id, ag.Kind)
+			}
+			if ag.Proto == "" || ag.Impl == "" {
+				t.Errorf("agent %v has prototype %q and spec %q", ag.Id, ag.Proto, ag.Impl)
+			}
+		}
+
+		var n int
+		err = db.QueryRow("SELECT COUNT(*) FROM Resources WHERE SimId = ? AND Type NOT IN ('Material','Product')", simid).Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		} else if n != 0 {
+			t.Errorf("simulation %x has %v resources of unknown type", simid, n)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !hdf5
+// +build !hdf5
+
+package source
+
+import "database/sql"
+
+func convertHDF5(h5path string, db *sql.DB) error { return ErrNoHDF5 }
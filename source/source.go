@@ -0,0 +1,137 @@
+// Package source opens cyclus output databases of any supported backend as
+// sqlite databases usable by the post and query packages.  Sqlite files are
+// opened directly.  HDF5 files are converted into a cached sqlite file next
+// to the original, which requires building with the hdf5 tag:
+//
+//	go build -tags hdf5
+package source
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/rwcarlsen/go-sqlite3"
+)
+
+// ErrNoHDF5 is returned when converting an HDF5 database with a cyan built
+// without the hdf5 tag.
+var ErrNoHDF5 = errors.New("HDF5 support not available: rebuild with '-tags hdf5'")
+
+// Tables lists the cyclus tables converted from HDF5 databases if present.
+// In addition, every table whose name starts with "TimeSeries" is
+// converted.
+var Tables = []string{
+	"Info",
+	"AgentEntry",
+	"AgentExit",
+	"Resources",
+	"ResCreators",
+	"Transactions",
+	"Compositions",
+	"Products",
+	"Prototypes",
+	"AgentVersions",
+	"DecayMode",
+	"InputFiles",
+	"XMLPPInfo",
+	"TimeStepDur",
+	"Finish",
+}
+
+// hdf5Sig is the signature at the start of every HDF5 file without a user
+// block.
+var hdf5Sig = []byte("\x89HDF\r\n\x1a\n")
+
+// IsHDF5 returns true if the file at path has an HDF5 extension (.h5 or
+// .hdf5) or begins with the HDF5 file signature.
+func IsHDF5(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".h5", ".hdf5":
+		return true
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sig := make([]byte, len(hdf5Sig))
+	if _, err := io.ReadFull(f, sig); err != nil {
+		return false
+	}
+	return bytes.Equal(sig, hdf5Sig)
+}
+
+// CachePath returns the path of the sqlite file an HDF5 database at path is
+// converted to (e.g. "run.h5" becomes "run.h5.sqlite").
+func CachePath(path string) string { return path + ".sqlite" }
+
+// Cached returns the path of a sqlite database containing the data of the
+// database at path.  For sqlite databases this is path itself.  HDF5
+// databases are converted to CachePath(path) unless a conversion newer than
+// the HDF5 file already exists.
+func Cached(path string) (string, error) {
+	if !IsHDF5(path) {
+		return path, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	cache := CachePath(path)
+	if cinfo, err := os.Stat(cache); err == nil && !cinfo.ModTime().Before(info.ModTime()) {
+		return cache, nil
+	}
+	return cache, Convert(path, cache)
+}
+
+// Open opens the cyclus database at path (see Cached).
+func Open(path string) (*sql.DB, error) {
+	dbpath, err := Cached(path)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite3", dbpath)
+}
+
+// Convert copies the cyclus tables (see Tables) in the HDF5 database at
+// h5path into a new sqlite database at dbpath, replacing any existing file.
+// The conversion is written to a temporary file first so an interrupted
+// conversion never leaves a partial database at dbpath.
+func Convert(h5path, dbpath string) error {
+	tmp := dbpath + ".tmp"
+	os.Remove(tmp)
+	db, err := sql.Open("sqlite3", tmp)
+	if err != nil {
+		return err
+	}
+
+	err = convertHDF5(h5path, db)
+	if err2 := db.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dbpath)
+}
+
+// convertTable returns true if the HDF5 dataset name should be converted.
+func convertTable(name string) bool {
+	if strings.HasPrefix(name, "TimeSeries") {
+		return true
+	}
+	for _, tbl := range Tables {
+		if name == tbl {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsHDF5(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cyan-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		Name string
+		Data string
+		Want bool
+	}{
+		{"run.h5", "", true},
+		{"run.HDF5", "", true},
+		{"run.sqlite", "SQLite format 3\x00", false},
+		{"run.dat", string(hdf5Sig) + "rest", true},
+		{"short.dat", "\x89HD", false},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.Name)
+		if err := ioutil.WriteFile(path, []byte(test.Data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := IsHDF5(path); got != test.Want {
+			t.Errorf("IsHDF5(%v) = %v, want %v", test.Name, got, test.Want)
+		}
+	}
+
+	if IsHDF5(filepath.Join(dir, "missing.sqlite")) {
+		t.Errorf("missing non-HDF5 file detected as HDF5")
+	}
+}
+
+func TestCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cyan-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "run.sqlite")
+	got, err := Cached(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if got != path {
+		t.Errorf("Cached(%v) = %v, want the same path", path, got)
+	}
+}
+
+func TestConvert_NoHDF5(t *testing.T) {
+	if err := convertHDF5("", nil); err != ErrNoHDF5 {
+		t.Skip("built with HDF5 support")
+	}
+
+	dir, err := ioutil.TempDir("", "cyan-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h5 := filepath.Join(dir, "run.h5")
+	if err := ioutil.WriteFile(h5, hdf5Sig, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Cached(h5); err != ErrNoHDF5 {
+		t.Fatalf("got error %v, want %v", err, ErrNoHDF5)
+	}
+	for _, p := range []string{CachePath(h5), CachePath(h5) + ".tmp"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("conversion left file %v behind", p)
+		}
+	}
+}